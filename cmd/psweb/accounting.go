@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"peerswap-web/cmd/psweb/bitcoin"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/internet"
+	"peerswap-web/cmd/psweb/liquid"
+	"peerswap-web/cmd/psweb/ln"
+)
+
+// fees of peg-ins, peg-outs and withdrawals by txid
+var onchainCosts = make(map[string]*db.OnchainCost)
+
+func loadOnchainCosts() {
+	var err error
+	onchainCosts, err = db.LoadOnchainCosts()
+	if err != nil {
+		log.Println("Failed to load on-chain costs:", err)
+	}
+}
+
+// records the fee of a peg-in, peg-out or withdrawal transaction once
+func recordOnchainCost(kind, asset, txId string) {
+	if txId == "" || onchainCosts[txId] != nil {
+		return
+	}
+
+	fee := onchainTxFee(asset, txId)
+	if fee == 0 {
+		// try next time
+		return
+	}
+
+	c := &db.OnchainCost{
+		TxId:      txId,
+		TimeStamp: onchainTxTime(asset, txId),
+		Kind:      kind,
+		Asset:     asset,
+		Fee:       fee,
+	}
+	onchainCosts[txId] = c
+	db.SaveOnchainCost(c)
+}
+
+// block time of the transaction, now if unknown
+func onchainTxTime(asset, txId string) int64 {
+	var blockTime int64
+
+	switch asset {
+	case "lbtc":
+		if tx, err := liquid.GetTransaction(txId); err == nil {
+			blockTime = tx.BlockTime
+		}
+	case "btc":
+		var tx bitcoin.Transaction
+		if _, err := bitcoin.GetRawTransaction(txId, &tx); err == nil {
+			blockTime = tx.BlockTime
+		}
+		if blockTime == 0 {
+			blockTime = internet.GetTxBlockTime(txId)
+		}
+	}
+
+	if blockTime == 0 {
+		// recorded once confirmed, so close enough
+		return time.Now().Unix()
+	}
+	return blockTime
+}
+
+// income and spend of a channel or a peer for the period, sats
+type AccountingRow struct {
+	// zero for peer totals
+	ChannelId      uint64
+	PeerId         string
+	PeerAlias      string
+	RoutingIncome  uint64
+	RebalanceSpend uint64
+	// premiums and rebates
+	SwapSpend int64
+	// swap transaction fees
+	SwapOnchain int64
+	// income less spend
+	Net int64
+}
+
+func (a *AccountingRow) add(b *AccountingRow) {
+	a.RoutingIncome += b.RoutingIncome
+	a.RebalanceSpend += b.RebalanceSpend
+	a.SwapSpend += b.SwapSpend
+	a.SwapOnchain += b.SwapOnchain
+	a.Net += b.Net
+}
+
+type AccountingReport struct {
+	// unix time, inclusive
+	From           int64
+	To             int64
+	RoutingIncome  uint64
+	RebalanceSpend uint64
+	SwapSpend      int64
+	PeginFees      int64
+	// swap, withdrawal and peg-out transaction fees
+	OnchainCosts int64
+	Net          int64
+	Channels     []*AccountingRow
+	Peers        []*AccountingRow
+}
+
+// node profit and loss for the period
+func accountingReport(from, to int64) (*AccountingReport, error) {
+	cl, clean, err := ln.GetClient()
+	if err != nil {
+		return nil, err
+	}
+	defer clean()
+
+	res, err := ln.ListPeers(cl, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AccountingReport{
+		From: from,
+		To:   to,
+	}
+
+	channels := make(map[uint64]*AccountingRow)
+	for _, peer := range res.GetPeers() {
+		for _, channel := range peer.Channels {
+			stats := ln.GetChannelStatsBetween(channel.ChannelId, uint64(from-1), uint64(to))
+			channels[channel.ChannelId] = &AccountingRow{
+				ChannelId:      channel.ChannelId,
+				PeerId:         peer.NodeId,
+				RoutingIncome:  stats.FeeSat,
+				RebalanceSpend: stats.RebalanceCost,
+			}
+		}
+	}
+
+	// closed channels earned in the past periods too
+	closed := ln.ClosedChannelPeers(cl)
+	for _, channelId := range ln.ForwardedChannels() {
+		if channels[channelId] != nil {
+			continue
+		}
+		stats := ln.GetChannelStatsBetween(channelId, uint64(from-1), uint64(to))
+		if stats.FeeSat == 0 && stats.RebalanceCost == 0 {
+			continue
+		}
+		channels[channelId] = &AccountingRow{
+			ChannelId:      channelId,
+			PeerId:         closed[channelId],
+			RoutingIncome:  stats.FeeSat,
+			RebalanceSpend: stats.RebalanceCost,
+		}
+	}
+
+	for _, e := range sortedLedger() {
+		if e.TimeStamp < from || e.TimeStamp > to {
+			continue
+		}
+		row, ok := channels[e.ChannelId]
+		if !ok {
+			// closed channel
+			row = &AccountingRow{
+				ChannelId: e.ChannelId,
+				PeerId:    e.PeerId,
+			}
+			channels[e.ChannelId] = row
+		}
+		if row.PeerId == "" {
+			row.PeerId = e.PeerId
+		}
+		row.SwapSpend += e.Premium + e.Rebate
+		row.SwapOnchain += e.Opening + e.Claim
+	}
+
+	peers := make(map[string]*AccountingRow)
+	for _, row := range channels {
+		row.PeerAlias = getNodeAlias(row.PeerId)
+		row.Net = int64(row.RoutingIncome) - int64(row.RebalanceSpend) - row.SwapSpend - row.SwapOnchain
+		report.Channels = append(report.Channels, row)
+
+		if peers[row.PeerId] == nil {
+			peers[row.PeerId] = &AccountingRow{
+				PeerId:    row.PeerId,
+				PeerAlias: row.PeerAlias,
+			}
+		}
+		peers[row.PeerId].add(row)
+
+		report.RoutingIncome += row.RoutingIncome
+		report.RebalanceSpend += row.RebalanceSpend
+		report.SwapSpend += row.SwapSpend
+		report.OnchainCosts += row.SwapOnchain
+	}
+
+	for _, row := range peers {
+		report.Peers = append(report.Peers, row)
+	}
+
+	for _, c := range onchainCosts {
+		if c.TimeStamp < from || c.TimeStamp > to {
+			continue
+		}
+		if c.Kind == "pegin" {
+			report.PeginFees += c.Fee
+		} else {
+			report.OnchainCosts += c.Fee
+		}
+	}
+
+	report.Net = int64(report.RoutingIncome) - int64(report.RebalanceSpend) - report.SwapSpend - report.PeginFees - report.OnchainCosts
+
+	// most profitable first
+	for _, rows := range [][]*AccountingRow{report.Channels, report.Peers} {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return rows[i].Net > rows[j].Net
+		})
+	}
+
+	return report, nil
+}
+
+// summary followed by a row per channel and per peer
+func writeAccountingCsv(w io.Writer, r *AccountingReport) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"From", time.Unix(r.From, 0).UTC().Format(time.RFC3339)})
+	c.Write([]string{"To", time.Unix(r.To, 0).UTC().Format(time.RFC3339)})
+	c.Write([]string{"Routing Income", fmt.Sprint(r.RoutingIncome)})
+	c.Write([]string{"Rebalance Spend", fmt.Sprint(r.RebalanceSpend)})
+	c.Write([]string{"Swap Spend", fmt.Sprint(r.SwapSpend)})
+	c.Write([]string{"Peg-in Fees", fmt.Sprint(r.PeginFees)})
+	c.Write([]string{"On-chain Costs", fmt.Sprint(r.OnchainCosts)})
+	c.Write([]string{"Net Margin", fmt.Sprint(r.Net)})
+	c.Write([]string{})
+
+	c.Write([]string{"Channel Id", "Peer Id", "Peer Alias", "Routing Income", "Rebalance Spend", "Swap Spend", "Swap On-chain", "Net Margin"})
+	for _, rows := range [][]*AccountingRow{r.Channels, r.Peers} {
+		for _, row := range rows {
+			channelId := ""
+			if row.ChannelId > 0 {
+				channelId = fmt.Sprint(row.ChannelId)
+			}
+			c.Write([]string{
+				channelId,
+				row.PeerId,
+				row.PeerAlias,
+				fmt.Sprint(row.RoutingIncome),
+				fmt.Sprint(row.RebalanceSpend),
+				fmt.Sprint(row.SwapSpend),
+				fmt.Sprint(row.SwapOnchain),
+				fmt.Sprint(row.Net),
+			})
+		}
+	}
+
+	c.Flush()
+	return c.Error()
+}
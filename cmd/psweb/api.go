@@ -0,0 +1,773 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/ln"
+	"peerswap-web/cmd/psweb/ps"
+
+	"github.com/elementsproject/peerswap/peerswaprpc"
+	"github.com/gorilla/mux"
+)
+
+// JSON API for scripts and dashboards
+// bump the version on breaking changes
+const API_PREFIX = "/api/v1"
+
+func addApiRoutes(r *mux.Router) {
+	api := r.PathPrefix(API_PREFIX).Subrouter()
+
+	api.HandleFunc("/peers", apiPeersHandler).Methods(http.MethodGet)
+	api.HandleFunc("/peers/{id}", apiPeerHandler).Methods(http.MethodGet)
+	api.HandleFunc("/channels", apiChannelsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/channels/{id}/fee", apiSetFeeHandler).Methods(http.MethodPost)
+	api.HandleFunc("/swaps", apiSwapsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/swaps", apiDoSwapHandler).Methods(http.MethodPost)
+	api.HandleFunc("/swaps/{id}", apiSwapHandler).Methods(http.MethodGet)
+	api.HandleFunc("/balances", apiBalancesHandler).Methods(http.MethodGet)
+	api.HandleFunc("/autofees", apiAutoFeesHandler).Methods(http.MethodGet)
+	api.HandleFunc("/autofees/{id}", apiAutoFeeHandler).Methods(http.MethodGet)
+	api.HandleFunc("/pegin", apiPeginHandler).Methods(http.MethodGet)
+	api.HandleFunc("/pegins", apiPeginsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/pegouts", apiPegoutsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/pegouts/compare", apiPegoutCompareHandler).Methods(http.MethodGet)
+	api.HandleFunc("/premiums", apiPremiumsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/autoswaps/candidates", apiAutoSwapCandidatesHandler).Methods(http.MethodGet)
+	api.HandleFunc("/ledger", apiLedgerHandler).Methods(http.MethodGet)
+	api.HandleFunc("/accounting", apiAccountingHandler).Methods(http.MethodGet)
+
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJsonError(w, http.StatusNotFound, errors.New("unknown endpoint"))
+	})
+	api.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJsonError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	})
+}
+
+func writeJson(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Println("writeJson:", err)
+	}
+}
+
+func writeJsonError(w http.ResponseWriter, status int, err error) {
+	type ErrorResponse struct {
+		Error string
+	}
+	writeJson(w, status, ErrorResponse{Error: err.Error()})
+}
+
+type ApiChannel struct {
+	ChannelId     uint64
+	LocalBalance  uint64
+	RemoteBalance uint64
+	Active        bool
+	FeeRate       int64
+	InboundRate   int64
+	AutoFee       bool
+}
+
+type ApiPeer struct {
+	NodeId            string
+	Alias             string
+	SwapsAllowed      bool
+	SupportedAssets   []string
+	Allowed           bool
+	Suspicious        bool
+	LiquidBalance     *ln.BalanceInfo // advertised by peer
+	BitcoinBalance    *ln.BalanceInfo // advertised by peer
+	AsSender          *peerswaprpc.SwapStats
+	AsReceiver        *peerswaprpc.SwapStats
+	SenderInProfit    int64
+	SenderOutProfit   int64
+	ReceiverInProfit  int64
+	ReceiverOutProfit int64
+	Channels          []*ApiChannel
+}
+
+type ApiSwap struct {
+	*peerswaprpc.PrettyPrintSwap
+	PeerAlias string
+	Status    string // pending, success or failed
+	Cost      int64  // negative means profit
+	Breakdown string
+}
+
+func newApiSwap(swap *peerswaprpc.PrettyPrintSwap) *ApiSwap {
+	cost, breakdown := swapCost(swap)
+	return &ApiSwap{
+		PrettyPrintSwap: swap,
+		PeerAlias:       getNodeAlias(swap.PeerNodeId),
+		Status:          simplifySwapState(swap.State),
+		Cost:            cost,
+		Breakdown:       breakdown,
+	}
+}
+
+// GET /api/v1/peers
+func apiPeersHandler(w http.ResponseWriter, r *http.Request) {
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer cleanup()
+
+	res, err := ps.ListPeers(client)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	policy, err := ps.ReloadPolicyFile(client)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	res2, err := ps.ListSwaps(client)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	swaps := res2.GetSwaps()
+
+	cl, clean, err := ln.GetClient()
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer clean()
+
+	// get fee rates for all channels
+	outboundFeeRates := make(map[uint64]int64)
+	inboundFeeRates := make(map[uint64]int64)
+
+	ln.FeeReport(cl, outboundFeeRates, inboundFeeRates)
+
+	peers := []*ApiPeer{}
+	for _, peer := range res.GetPeers() {
+		peers = append(peers, newApiPeer(peer, policy, swaps, outboundFeeRates, inboundFeeRates))
+	}
+
+	writeJson(w, http.StatusOK, peers)
+}
+
+// GET /api/v1/peers/{id}
+func apiPeerHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer cleanup()
+
+	res, err := ps.ListPeers(client)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	peer := findPeerById(res.GetPeers(), id)
+	if peer == nil {
+		writeJsonError(w, http.StatusNotFound, errors.New("peer "+id+" not found"))
+		return
+	}
+
+	policy, err := ps.ReloadPolicyFile(client)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	res2, err := ps.ListSwaps(client)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	cl, clean, err := ln.GetClient()
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer clean()
+
+	outboundFeeRates := make(map[uint64]int64)
+	inboundFeeRates := make(map[uint64]int64)
+
+	ln.FeeReport(cl, outboundFeeRates, inboundFeeRates)
+
+	type PeerDetails struct {
+		*ApiPeer
+		ChannelInfo []*ln.ChanneInfo
+		Stats       []*ln.ForwardingStats
+		Premiums    []Premium
+	}
+
+	data := PeerDetails{
+		ApiPeer: newApiPeer(peer, policy, res2.GetSwaps(), outboundFeeRates, inboundFeeRates),
+	}
+
+	for _, ch := range peer.Channels {
+		info := ln.GetChannelInfo(cl, ch.ChannelId, peer.NodeId)
+		info.LocalBalance = ch.GetLocalBalance()
+		info.RemoteBalance = ch.GetRemoteBalance()
+		info.Active = ch.GetActive()
+		if info.Capacity > 0 {
+			info.LocalPct = info.LocalBalance * 100 / info.Capacity
+		}
+		data.ChannelInfo = append(data.ChannelInfo, info)
+		data.Stats = append(data.Stats, ln.GetForwardingStats(ch.ChannelId))
+	}
+
+	for _, asset := range []peerswaprpc.AssetType{peerswaprpc.AssetType_BTC, peerswaprpc.AssetType_LBTC} {
+		for _, operation := range []peerswaprpc.OperationType{peerswaprpc.OperationType_SWAP_IN, peerswaprpc.OperationType_SWAP_OUT} {
+			peerRate, err := ps.GetPremiumRate(client, peer.NodeId, asset, operation)
+			if err != nil {
+				continue
+			}
+			data.Premiums = append(data.Premiums, Premium{
+				Asset:          int32(asset.Number()),
+				Operation:      int32(operation.Number()),
+				PremiumRatePpm: peerRate.PremiumRatePpm,
+			})
+		}
+	}
+
+	writeJson(w, http.StatusOK, data)
+}
+
+func newApiPeer(peer *peerswaprpc.PeerSwapPeer,
+	policy *peerswaprpc.Policy,
+	swaps []*peerswaprpc.PrettyPrintSwap,
+	outboundFeeRates map[uint64]int64,
+	inboundFeeRates map[uint64]int64) *ApiPeer {
+
+	p := ApiPeer{
+		NodeId:          peer.NodeId,
+		Alias:           getNodeAlias(peer.NodeId),
+		SwapsAllowed:    peer.SwapsAllowed,
+		SupportedAssets: peer.SupportedAssets,
+		Allowed:         stringIsInSlice(peer.NodeId, policy.GetAllowlistedPeers()),
+		Suspicious:      stringIsInSlice(peer.NodeId, policy.GetSuspiciousPeerList()),
+		LiquidBalance:   ln.LiquidBalances[peer.NodeId],
+		BitcoinBalance:  ln.BitcoinBalances[peer.NodeId],
+		AsSender:        peer.AsSender,
+		AsReceiver:      peer.AsReceiver,
+		Channels:        []*ApiChannel{},
+	}
+
+	p.SenderInProfit, p.SenderOutProfit, p.ReceiverInProfit, p.ReceiverOutProfit = peerSwapProfits(swaps, peer.NodeId)
+
+	for _, ch := range peer.Channels {
+		p.Channels = append(p.Channels, &ApiChannel{
+			ChannelId:     ch.ChannelId,
+			LocalBalance:  ch.LocalBalance,
+			RemoteBalance: ch.RemoteBalance,
+			Active:        ch.Active,
+			FeeRate:       outboundFeeRates[ch.ChannelId],
+			InboundRate:   inboundFeeRates[ch.ChannelId],
+			AutoFee:       ln.AutoFeeIsEnabled(ch.ChannelId),
+		})
+	}
+
+	return &p
+}
+
+// GET /api/v1/channels
+// all Lightning channels, including non-peerswap peers
+func apiChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	cl, clean, err := ln.GetClient()
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer clean()
+
+	res, err := ln.ListPeers(cl, "", nil)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	outboundFeeRates := make(map[uint64]int64)
+	inboundFeeRates := make(map[uint64]int64)
+
+	ln.FeeReport(cl, outboundFeeRates, inboundFeeRates)
+
+	type Channel struct {
+		ApiChannel
+		PeerId    string
+		PeerAlias string
+		LocalPct  uint64
+	}
+
+	channels := []*Channel{}
+
+	for _, peer := range res.GetPeers() {
+		alias := getNodeAlias(peer.NodeId)
+		for _, ch := range peer.Channels {
+			localPct := uint64(0)
+			if ch.LocalBalance+ch.RemoteBalance > 0 {
+				localPct = ch.LocalBalance * 100 / (ch.LocalBalance + ch.RemoteBalance)
+			}
+			channels = append(channels, &Channel{
+				ApiChannel: ApiChannel{
+					ChannelId:     ch.ChannelId,
+					LocalBalance:  ch.LocalBalance,
+					RemoteBalance: ch.RemoteBalance,
+					Active:        ch.Active,
+					FeeRate:       outboundFeeRates[ch.ChannelId],
+					InboundRate:   inboundFeeRates[ch.ChannelId],
+					AutoFee:       ln.AutoFeeIsEnabled(ch.ChannelId),
+				},
+				PeerId:    peer.NodeId,
+				PeerAlias: alias,
+				LocalPct:  localPct,
+			})
+		}
+	}
+
+	writeJson(w, http.StatusOK, channels)
+}
+
+// POST /api/v1/channels/{id}/fee
+// {"FeeRate": 500, "Inbound": false, "PeerNodeId": "optional"}
+func apiSetFeeHandler(w http.ResponseWriter, r *http.Request) {
+	channelId, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var req struct {
+		FeeRate    int64
+		Inbound    bool
+		PeerNodeId string
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.PeerNodeId == "" {
+		req.PeerNodeId = peerNodeId[channelId]
+	}
+
+	if req.PeerNodeId == "" {
+		writeJsonError(w, http.StatusBadRequest, errors.New("PeerNodeId is required"))
+		return
+	}
+
+	if err := setChannelFeeRate(req.PeerNodeId, channelId, req.FeeRate, req.Inbound); err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, req)
+}
+
+// GET /api/v1/swaps?id=&state=&role=
+func apiSwapsHandler(w http.ResponseWriter, r *http.Request) {
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer cleanup()
+
+	var res *peerswaprpc.ListSwapsResponse
+	if r.URL.Query().Get("active") != "" {
+		res, err = ps.ListActiveSwaps(client)
+	} else {
+		res, err = ps.ListSwaps(client)
+	}
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	// same filters as the home page
+	nodeId := r.URL.Query().Get("id")
+	state := r.URL.Query().Get("state")
+	role := r.URL.Query().Get("role")
+
+	swaps := []*ApiSwap{}
+
+	for _, swap := range res.GetSwaps() {
+		if nodeId != "" && nodeId != swap.PeerNodeId {
+			continue
+		}
+		if state != "" && state != simplifySwapState(swap.State) {
+			continue
+		}
+		if role != "" && role != swap.Role {
+			continue
+		}
+
+		swaps = append(swaps, newApiSwap(swap))
+	}
+
+	// newest first
+	sort.Slice(swaps, func(i, j int) bool {
+		return swaps[i].CreatedAt > swaps[j].CreatedAt
+	})
+
+	writeJson(w, http.StatusOK, swaps)
+}
+
+// GET /api/v1/swaps/{id}
+func apiSwapHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer cleanup()
+
+	res, err := ps.GetSwap(client, id)
+	if err != nil {
+		writeJsonError(w, http.StatusNotFound, err)
+		return
+	}
+
+	swap := res.GetSwap()
+
+	// refresh swap rebate
+	ln.GetChannelStats(swap.LndChanId, uint64(time.Now().Add(-time.Hour).Unix()))
+
+	writeJson(w, http.StatusOK, newApiSwap(swap))
+}
+
+// POST /api/v1/swaps
+// {"NodeId": "...", "ChannelId": 123, "Amount": 100000, "From": "lbtc", "To": "ln", "PremiumLimit": 1000}
+func apiDoSwapHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		NodeId       string
+		ChannelId    uint64
+		Amount       uint64
+		From         string
+		To           string
+		PremiumLimit int64
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id, err := startSwap(req.NodeId, req.ChannelId, req.Amount, req.From, req.To, req.PremiumLimit)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	type Response struct {
+		Id string
+	}
+
+	writeJson(w, http.StatusCreated, Response{Id: id})
+}
+
+// GET /api/v1/balances
+func apiBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	cl, clean, err := ln.GetClient()
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer clean()
+
+	type Balances struct {
+		LiquidBalance   uint64 // spendable L-BTC
+		BitcoinBalance  uint64 // confirmed on-chain BTC
+		LightningLocal  uint64
+		LightningRemote uint64
+	}
+
+	data := Balances{
+		LiquidBalance:  getUnlockedLbtcBalance(),
+		BitcoinBalance: uint64(ln.ConfirmedWalletBalance(cl)),
+	}
+
+	res, err := ln.ListPeers(cl, "", nil)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	for _, peer := range res.GetPeers() {
+		for _, ch := range peer.Channels {
+			data.LightningLocal += ch.LocalBalance
+			data.LightningRemote += ch.RemoteBalance
+		}
+	}
+
+	writeJson(w, http.StatusOK, data)
+}
+
+// GET /api/v1/autofees
+func apiAutoFeesHandler(w http.ResponseWriter, r *http.Request) {
+	type AutoFees struct {
+		GlobalEnabled bool
+		DryRun        bool
+		*ln.AutoFeeRules
+	}
+
+	// the engine changes the live maps meanwhile
+	data := AutoFees{
+		GlobalEnabled: ln.AutoFeeEnabledAll,
+		DryRun:        ln.AutoFeeDryRun,
+		AutoFeeRules:  ln.AutoFeeRulesSnapshot(),
+	}
+
+	writeJson(w, http.StatusOK, data)
+}
+
+// GET /api/v1/autofees/{id}
+// rule and 30 days of fee changes for a channel
+func apiAutoFeeHandler(w http.ResponseWriter, r *http.Request) {
+	channelId, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	type AutoFee struct {
+		ChannelId uint64
+		*ln.AutoFeeChannel
+	}
+
+	// the engine changes the live maps meanwhile
+	data := AutoFee{
+		ChannelId:      channelId,
+		AutoFeeChannel: ln.AutoFeeChannelSnapshot(channelId, time.Now().AddDate(0, 0, -30).Unix()),
+	}
+
+	writeJson(w, http.StatusOK, data)
+}
+
+type PeginStatus struct {
+	Id                  uint64
+	Pending             bool
+	TxId                string
+	ReplacedTxIds       []string
+	IsPegin             bool // false for ordinary BTC withdrawal
+	IsExternal          bool
+	Address             string
+	Amount              int64
+	FeeRate             float64
+	Confirmations       int32
+	TargetConfirmations int32
+	IsClaimJoin         bool
+	ClaimJoinStatus     string
+	ClaimJoinInvite     bool
+}
+
+func peginStatus(p *db.Pegin) *PeginStatus {
+	data := &PeginStatus{
+		Id:                  p.Id,
+		Pending:             true,
+		TxId:                p.TxId,
+		ReplacedTxIds:       p.ReplacedTxIds,
+		IsPegin:             p.IsPegin(),
+		IsExternal:          p.TxId == "external",
+		Address:             p.Address,
+		Amount:              p.Amount,
+		FeeRate:             p.FeeRate,
+		TargetConfirmations: int32(peginBlocks),
+		IsClaimJoin:         p.ClaimJoin,
+		ClaimJoinInvite:     ln.ClaimJoinHandler != "",
+	}
+
+	if p.ClaimJoin && (ln.ClaimPeginId == 0 || ln.ClaimPeginId == p.Id) {
+		data.ClaimJoinStatus = ln.ClaimStatus
+	}
+
+	if !data.IsExternal {
+		data.Confirmations, _ = peginConfirmations(p.TxId)
+	}
+
+	return data
+}
+
+// GET /api/v1/pegin
+// the oldest pending peg-in or withdrawal
+func apiPeginHandler(w http.ResponseWriter, r *http.Request) {
+	pegins := activePegins()
+	if len(pegins) == 0 {
+		writeJson(w, http.StatusOK, &PeginStatus{
+			TargetConfirmations: int32(peginBlocks),
+			ClaimJoinStatus:     ln.ClaimStatus,
+			ClaimJoinInvite:     ln.ClaimJoinHandler != "",
+		})
+		return
+	}
+
+	writeJson(w, http.StatusOK, peginStatus(pegins[0]))
+}
+
+// GET /api/v1/pegins
+// all pending peg-ins and withdrawals
+func apiPeginsHandler(w http.ResponseWriter, r *http.Request) {
+	data := []*PeginStatus{}
+	for _, p := range activePegins() {
+		data = append(data, peginStatus(p))
+	}
+
+	writeJson(w, http.StatusOK, data)
+}
+
+// peg-out record with its progress
+type PegoutStatus struct {
+	*db.Pegout
+	// "liquid", "payout" or "confirming"
+	Stage string
+}
+
+// GET /api/v1/pegouts
+// all pending peg-outs
+func apiPegoutsHandler(w http.ResponseWriter, r *http.Request) {
+	data := []*PegoutStatus{}
+	for _, p := range activePegouts() {
+		data = append(data, &PegoutStatus{
+			Pegout: p,
+			Stage:  pegoutStage(p),
+		})
+	}
+
+	writeJson(w, http.StatusOK, data)
+}
+
+// GET /api/v1/pegouts/compare?amount=
+// cost of a peg-out versus BTC swap-out and L-BTC swap-in
+func apiPegoutCompareHandler(w http.ResponseWriter, r *http.Request) {
+	amount, err := strconv.ParseInt(r.URL.Query().Get("amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		writeJsonError(w, http.StatusBadRequest, errors.New("amount must be a positive number of sats"))
+		return
+	}
+
+	data, err := comparePegout(amount)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, data)
+}
+
+// GET /api/v1/premiums
+// global premium rates
+func apiPremiumsHandler(w http.ResponseWriter, r *http.Request) {
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer cleanup()
+
+	premiums := []Premium{}
+
+	for _, asset := range []peerswaprpc.AssetType{peerswaprpc.AssetType_BTC, peerswaprpc.AssetType_LBTC} {
+		for _, operation := range []peerswaprpc.OperationType{peerswaprpc.OperationType_SWAP_IN, peerswaprpc.OperationType_SWAP_OUT} {
+			rate, err := ps.GetGlobalPremiumRate(client, asset, operation)
+			if err != nil {
+				writeJsonError(w, http.StatusServiceUnavailable, err)
+				return
+			}
+			premiums = append(premiums, Premium{
+				Asset:          int32(asset.Number()),
+				Operation:      int32(operation.Number()),
+				PremiumRatePpm: rate.PremiumRatePpm,
+			})
+		}
+	}
+
+	writeJson(w, http.StatusOK, premiums)
+}
+
+// GET /api/v1/autoswaps/candidates
+// every channel evaluated by auto swaps with its score, amount and exclusion rule
+func apiAutoSwapCandidatesHandler(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		SwapIns      []*AutoSwapRank
+		SwapOuts     []*AutoSwapRank
+		PausedReason string
+	}
+
+	swapIns, err := rankSwapInCandidates(true)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	swapOuts, err := rankSwapOutCandidates()
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	sortAutoSwapRanks(swapIns)
+	sortAutoSwapRanks(swapOuts)
+
+	data := Response{
+		SwapIns:  swapIns,
+		SwapOuts: swapOuts,
+	}
+	_, data.PausedReason = autoSwapRoom()
+
+	writeJson(w, http.StatusOK, data)
+}
+
+// GET /api/v1/ledger?month=2006-01
+// swap P&L records with totals by peer and by month
+func apiLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		Entries []*db.SwapLedgerEntry
+		ByPeer  []*LedgerTotal
+		ByMonth []*LedgerTotal
+	}
+
+	entries := ledgerMonth(sortedLedger(), r.URL.Query().Get("month"))
+
+	writeJson(w, http.StatusOK, Response{
+		Entries: entries,
+		ByPeer:  ledgerByPeer(entries),
+		ByMonth: ledgerByMonth(entries),
+	})
+}
+
+// GET /api/v1/accounting?from=2006-01-02&to=2006-01-02
+// routing income, rebalance, swap, peg-in and on-chain spend with margin per channel and peer
+func apiAccountingHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := accountingPeriod(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := accountingReport(from.Unix(), to.AddDate(0, 0, 1).Unix()-1)
+	if err != nil {
+		writeJsonError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, report)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/ps"
+)
+
+// reasons auto swaps are paused
+const (
+	budgetDaySpent  = "24h fee budget spent"
+	budgetWeekSpent = "7d fee budget spent"
+)
+
+// auto swap that has not finished yet
+type AutoSwapInFlight struct {
+	AutoSwapParams
+	// "in" or "out"
+	Direction string
+	TimeStamp int64
+}
+
+var (
+	// guards auto swap state shared by the timer, web pages and the bot
+	autoSwapMutex sync.Mutex
+	// pending auto swaps by swap id, to check the state later
+	autoSwapsInFlight = make(map[string]*AutoSwapInFlight)
+	// why new auto swaps are not initiated, to notify once
+	autoSwapPausedReason string
+)
+
+func loadAutoSwapsInFlight() {
+	db.Load("Swaps", "AutoSwapsInFlight", &autoSwapsInFlight)
+}
+
+// starts following a new auto swap
+func addAutoSwapInFlight(swapId, direction string, candidate AutoSwapParams) {
+	autoSwapMutex.Lock()
+	defer autoSwapMutex.Unlock()
+
+	autoSwapsInFlight[swapId] = &AutoSwapInFlight{
+		AutoSwapParams: candidate,
+		Direction:      direction,
+		TimeStamp:      time.Now().Unix(),
+	}
+	db.Save("Swaps", "AutoSwapsInFlight", autoSwapsInFlight)
+}
+
+// records outcomes of the auto swaps that are no longer pending
+func followAutoSwaps() {
+	inFlight := autoSwapsInFlightSnapshot()
+	if len(inFlight) == 0 {
+		return
+	}
+
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	done := []string{}
+	for id, s := range inFlight {
+		res, err := ps.GetSwap(client, id)
+		if err != nil {
+			log.Println("GetSwap:", err)
+			// someting is wrong
+			autoSwapError(s.Direction, &s.AutoSwapParams, id, err)
+		} else if simplifySwapState(res.GetSwap().State) == "pending" {
+			continue
+		} else {
+			autoSwapFinished(s.Direction, res.GetSwap())
+		}
+
+		// stop following
+		done = append(done, id)
+	}
+
+	if len(done) > 0 {
+		autoSwapMutex.Lock()
+		for _, id := range done {
+			delete(autoSwapsInFlight, id)
+		}
+		db.Save("Swaps", "AutoSwapsInFlight", autoSwapsInFlight)
+		autoSwapMutex.Unlock()
+	}
+}
+
+// copy of pending auto swaps by swap id
+func autoSwapsInFlightSnapshot() map[string]AutoSwapInFlight {
+	autoSwapMutex.Lock()
+	defer autoSwapMutex.Unlock()
+
+	result := make(map[string]AutoSwapInFlight, len(autoSwapsInFlight))
+	for id, s := range autoSwapsInFlight {
+		result[id] = *s
+	}
+	return result
+}
+
+// fees of auto swaps since the time: actual of finished, estimated of pending
+func autoSwapFeesSpent(since int64) int64 {
+	autoSwapMutex.Lock()
+	defer autoSwapMutex.Unlock()
+
+	spent := int64(0)
+	for _, o := range autoSwapOutcomes {
+		if o.TimeStamp > since {
+			spent += o.Cost
+		}
+	}
+	for _, s := range autoSwapsInFlight {
+		if s.TimeStamp > since {
+			spent += s.Cost
+		}
+	}
+	return spent
+}
+
+// the amount another auto swap may have,
+// zero with the reason when limits or fee budgets are reached
+func autoSwapRoom() (uint64, string) {
+	if config.Config.AutoSwapFeeBudgetDay > 0 &&
+		autoSwapFeesSpent(time.Now().Add(-24*time.Hour).Unix()) >= config.Config.AutoSwapFeeBudgetDay {
+		return 0, budgetDaySpent
+	}
+
+	if config.Config.AutoSwapFeeBudgetWeek > 0 &&
+		autoSwapFeesSpent(time.Now().AddDate(0, 0, -7).Unix()) >= config.Config.AutoSwapFeeBudgetWeek {
+		return 0, budgetWeekSpent
+	}
+
+	inFlight := autoSwapsInFlightSnapshot()
+	if uint64(len(inFlight)) >= config.Config.AutoSwapMaxInFlight {
+		return 0, "max swaps in flight"
+	}
+
+	amount := uint64(0)
+	for _, s := range inFlight {
+		amount += s.Amount
+	}
+	if amount >= config.Config.AutoSwapInFlightAmount {
+		return 0, "max amount in flight"
+	}
+
+	return config.Config.AutoSwapInFlightAmount - amount, ""
+}
+
+// amount available for the next auto swap, notifies when fee budget pauses automation
+func autoSwapAllowance() uint64 {
+	room, reason := autoSwapRoom()
+	paused := reason == budgetDaySpent || reason == budgetWeekSpent
+
+	switch {
+	case paused && reason != autoSwapPausedReason:
+		log.Println("Auto swaps paused:", reason)
+		telegramSendMessage("🤖 Auto swaps paused: " + reason)
+	case !paused && autoSwapPausedReason != "":
+		log.Println("Auto swaps resumed")
+	}
+
+	autoSwapPausedReason = ""
+	if paused {
+		autoSwapPausedReason = reason
+	}
+
+	return room
+}
+
+// a channel evaluated by auto swaps and why it was or was not chosen
+type AutoSwapRank struct {
+	AutoSwapParams
+	// "in" or "out"
+	Direction string
+	// rule that excluded the channel, empty if eligible
+	Excluded string
+	// the channel auto swap would pick now
+	Selected bool
+	// swap-out only, local balance is above AutoSwapOutThresholdPct
+	aboveThreshold bool
+}
+
+// marks eligible channels below minPPM as excluded and selects the best of the rest.
+// Highest PPM wins, if ppm ties, the one with larger potential swap amount.
+func selectAutoSwap(ranks []*AutoSwapRank, minPPM uint64) {
+	var best *AutoSwapRank
+	for _, r := range ranks {
+		if r.Excluded != "" {
+			continue
+		}
+		if r.RoutingPpm < minPPM {
+			r.Excluded = fmt.Sprintf("PPM below threshold %d", minPPM)
+			continue
+		}
+		if best == nil || r.RoutingPpm > best.RoutingPpm || r.RoutingPpm == best.RoutingPpm && r.Amount > best.Amount {
+			best = r
+		}
+	}
+	if best != nil {
+		best.Selected = true
+	}
+}
+
+// selected first, then eligible by PPM and amount, then excluded
+func sortAutoSwapRanks(ranks []*AutoSwapRank) {
+	sort.SliceStable(ranks, func(i, j int) bool {
+		a, b := ranks[i], ranks[j]
+		if a.Selected != b.Selected {
+			return a.Selected
+		}
+		if (a.Excluded == "") != (b.Excluded == "") {
+			return a.Excluded == ""
+		}
+		if a.RoutingPpm != b.RoutingPpm {
+			return a.RoutingPpm > b.RoutingPpm
+		}
+		return a.Amount > b.Amount
+	})
+}
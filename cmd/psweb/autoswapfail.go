@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+
+	"github.com/elementsproject/peerswap/peerswaprpc"
+)
+
+// failure classes of auto swaps
+const (
+	failPeerRejected = "peer rejected"
+	failPremium      = "premium too high"
+	failPeerOffline  = "peer offline"
+	failOnchain      = "on-chain failure"
+	failOwn          = "own error"
+)
+
+const (
+	// first block after a failure, doubles with each consecutive one
+	AUTO_SWAP_BACKOFF     = time.Hour
+	AUTO_SWAP_MAX_BACKOFF = 7 * 24 * time.Hour
+	// outcomes kept for the liquid page
+	AUTO_SWAP_OUTCOMES = 50
+)
+
+// result of an auto swap
+type AutoSwapOutcome struct {
+	TimeStamp int64
+	SwapId    string
+	// "in" or "out"
+	Direction string
+	PeerId    string
+	ChannelId uint64
+	Amount    uint64
+	Asset     string
+	// "success" or failure class
+	Result  string
+	Message string
+	// premium plus on-chain fees, sats
+	Cost int64
+}
+
+// peer or channel excluded from auto swaps until the time
+type AutoSwapBlock struct {
+	Until    int64
+	Failures int
+	Reason   string
+}
+
+var (
+	autoSwapOutcomes      []AutoSwapOutcome
+	autoSwapPeerBlocks    = make(map[string]*AutoSwapBlock)
+	autoSwapChannelBlocks = make(map[uint64]*AutoSwapBlock)
+)
+
+func loadAutoSwapOutcomes() {
+	db.Load("Swaps", "AutoSwapOutcomes", &autoSwapOutcomes)
+	db.Load("Swaps", "AutoSwapPeerBlocks", &autoSwapPeerBlocks)
+	db.Load("Swaps", "AutoSwapChannelBlocks", &autoSwapChannelBlocks)
+}
+
+// true if auto swaps with the peer or over the channel are on hold
+func autoSwapBlocked(peerId string, channelId uint64) bool {
+	autoSwapMutex.Lock()
+	defer autoSwapMutex.Unlock()
+
+	now := time.Now().Unix()
+	if b := autoSwapPeerBlocks[peerId]; b != nil && b.Until > now {
+		return true
+	}
+	if b := autoSwapChannelBlocks[channelId]; b != nil && b.Until > now {
+		return true
+	}
+	return false
+}
+
+// sorts the reason of a failed swap into a failure class
+func classifySwapFailure(state, openingTxId, message string) string {
+	m := strings.ToLower(message)
+	switch {
+	case strings.Contains(m, "premium"):
+		return failPremium
+	case strings.Contains(m, "not connected"),
+		strings.Contains(m, "offline"),
+		strings.Contains(m, "not online"),
+		strings.Contains(m, "timed out"),
+		strings.Contains(m, "timeout"),
+		strings.Contains(m, "unreachable"):
+		return failPeerOffline
+	case openingTxId != "",
+		state == "State_ClaimedCsv",
+		state == "State_ClaimedCoop",
+		strings.Contains(m, "confirm"),
+		strings.Contains(m, "tx "):
+		// the opening tx was broadcast
+		return failOnchain
+	case strings.Contains(m, "reject"),
+		strings.Contains(m, "not allowed"),
+		strings.Contains(m, "not supported"),
+		strings.Contains(m, "does not support"),
+		strings.Contains(m, "denied"),
+		state == "State_SwapCanceled" && m != "":
+		return failPeerRejected
+	}
+	return failOwn
+}
+
+// peer related failures block the peer, the rest only the channel,
+// caller holds autoSwapMutex
+func blockAutoSwap(peerId string, channelId uint64, class string) *AutoSwapBlock {
+	var b *AutoSwapBlock
+	switch class {
+	case failPeerRejected, failPremium, failPeerOffline:
+		if autoSwapPeerBlocks[peerId] == nil {
+			autoSwapPeerBlocks[peerId] = new(AutoSwapBlock)
+		}
+		b = autoSwapPeerBlocks[peerId]
+	default:
+		if autoSwapChannelBlocks[channelId] == nil {
+			autoSwapChannelBlocks[channelId] = new(AutoSwapBlock)
+		}
+		b = autoSwapChannelBlocks[channelId]
+	}
+
+	b.Failures++
+	b.Reason = class
+	backoff := AUTO_SWAP_MAX_BACKOFF
+	if b.Failures < 10 {
+		backoff = min(AUTO_SWAP_BACKOFF<<(b.Failures-1), AUTO_SWAP_MAX_BACKOFF)
+	}
+	b.Until = time.Now().Add(backoff).Unix()
+
+	db.Save("Swaps", "AutoSwapPeerBlocks", autoSwapPeerBlocks)
+	db.Save("Swaps", "AutoSwapChannelBlocks", autoSwapChannelBlocks)
+
+	return b
+}
+
+// consecutive failures of auto swaps in this direction, caller holds autoSwapMutex
+func autoSwapFailureStreak(direction string) int {
+	streak := 0
+	for i := len(autoSwapOutcomes) - 1; i >= 0; i-- {
+		if autoSwapOutcomes[i].Direction != direction {
+			continue
+		}
+		if autoSwapOutcomes[i].Result == "success" {
+			break
+		}
+		streak++
+	}
+	return streak
+}
+
+// records a finished auto swap
+func autoSwapFinished(direction string, swap *peerswaprpc.PrettyPrintSwap) {
+	outcome := AutoSwapOutcome{
+		SwapId:    swap.Id,
+		Direction: direction,
+		PeerId:    swap.PeerNodeId,
+		ChannelId: swap.LndChanId,
+		Amount:    swap.Amount,
+		Asset:     swap.Asset,
+		Result:    "success",
+		Message:   swap.CancelMessage,
+	}
+
+	if swap.State != "State_ClaimedPreimage" {
+		outcome.Result = classifySwapFailure(swap.State, swap.OpeningTxId, swap.CancelMessage)
+	}
+
+	outcome.Cost, _ = swapCost(swap)
+
+	addAutoSwapOutcome(outcome)
+}
+
+// records an auto swap that could not be initiated or followed
+func autoSwapError(direction string, candidate *AutoSwapParams, swapId string, err error) {
+	addAutoSwapOutcome(AutoSwapOutcome{
+		SwapId:    swapId,
+		Direction: direction,
+		PeerId:    candidate.PeerId,
+		ChannelId: candidate.ChannelId,
+		Amount:    candidate.Amount,
+		Asset:     candidate.Asset,
+		Result:    classifySwapFailure("", "", err.Error()),
+		Message:   err.Error(),
+	})
+}
+
+// logs and reports the outcome, blocks failed peer or channel
+// and disables the automation after AutoSwapMaxFailures in a row
+func addAutoSwapOutcome(outcome AutoSwapOutcome) {
+	outcome.TimeStamp = time.Now().Unix()
+
+	name := "Auto Swap-In"
+	if outcome.Direction == "out" {
+		name = "Auto Swap-Out"
+	}
+	alias := getNodeAlias(outcome.PeerId)
+
+	autoSwapMutex.Lock()
+
+	autoSwapOutcomes = append(autoSwapOutcomes, outcome)
+
+	// keep a week for the fee budget, but no less than AUTO_SWAP_OUTCOMES
+	weekAgo := time.Now().AddDate(0, 0, -7).Unix()
+	for len(autoSwapOutcomes) > AUTO_SWAP_OUTCOMES && autoSwapOutcomes[0].TimeStamp < weekAgo {
+		autoSwapOutcomes = autoSwapOutcomes[1:]
+	}
+	db.Save("Swaps", "AutoSwapOutcomes", autoSwapOutcomes)
+
+	if outcome.Result == "success" {
+		// success resets the backoff
+		delete(autoSwapPeerBlocks, outcome.PeerId)
+		delete(autoSwapChannelBlocks, outcome.ChannelId)
+		db.Save("Swaps", "AutoSwapPeerBlocks", autoSwapPeerBlocks)
+		db.Save("Swaps", "AutoSwapChannelBlocks", autoSwapChannelBlocks)
+		autoSwapMutex.Unlock()
+
+		log.Println(name + " complete, id: " + outcome.SwapId)
+		telegramSendMessage("🤖 " + name + " with " + alias + " for " + formatWithThousandSeparators(outcome.Amount) + " sats complete")
+		return
+	}
+
+	b := blockAutoSwap(outcome.PeerId, outcome.ChannelId, outcome.Result)
+	what := "channel " + fmt.Sprint(outcome.ChannelId)
+	if autoSwapPeerBlocks[outcome.PeerId] == b {
+		what = "peer " + alias
+	}
+	until := time.Unix(b.Until, 0).UTC().Format(time.RFC1123)
+	streak := autoSwapFailureStreak(outcome.Direction)
+
+	autoSwapMutex.Unlock()
+
+	log.Println(name+" failed:", outcome.Result+",", outcome.Message, "| skipping", what, "until", until)
+	t := "🤖 " + name + " with " + alias + " failed: " + outcome.Result
+	if outcome.Message != "" {
+		t += " (" + outcome.Message + ")"
+	}
+	t += ". Skipping " + what + " until " + until
+
+	if streak >= int(config.Config.AutoSwapMaxFailures) {
+		// to avoid paying more fees
+		if outcome.Direction == "out" {
+			config.Config.AutoSwapOutEnabled = false
+			log.Println("Automatic swap-outs Disabled")
+		} else {
+			config.Config.AutoSwapEnabled = false
+			log.Println("Automatic swap-ins Disabled")
+		}
+		config.Save()
+		t += fmt.Sprintf(". Disabled after %d consecutive failures", streak)
+	}
+
+	telegramSendMessage(t)
+}
+
+// copies of outcomes, oldest first, and of peer and channel blocks
+func autoSwapOutcomesSnapshot() ([]AutoSwapOutcome, map[string]AutoSwapBlock, map[uint64]AutoSwapBlock) {
+	autoSwapMutex.Lock()
+	defer autoSwapMutex.Unlock()
+
+	outcomes := make([]AutoSwapOutcome, len(autoSwapOutcomes))
+	copy(outcomes, autoSwapOutcomes)
+
+	peerBlocks := make(map[string]AutoSwapBlock, len(autoSwapPeerBlocks))
+	for k, b := range autoSwapPeerBlocks {
+		peerBlocks[k] = *b
+	}
+
+	channelBlocks := make(map[uint64]AutoSwapBlock, len(autoSwapChannelBlocks))
+	for k, b := range autoSwapChannelBlocks {
+		channelBlocks[k] = *b
+	}
+
+	return outcomes, peerBlocks, channelBlocks
+}
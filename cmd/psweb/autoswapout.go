@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/ln"
+	"peerswap-web/cmd/psweb/ps"
+
+	"github.com/elementsproject/peerswap/peerswaprpc"
+)
+
+// initiated auto swap-out, counted against the daily budget
+type AutoSwapOutRecord struct {
+	TimeStamp int64
+	SwapId    string
+	ChannelId uint64
+	Amount    uint64
+}
+
+var (
+	// when local balance of the channel went above AutoSwapOutThresholdPct,
+	// written by the timer only, both guarded by autoSwapMutex
+	autoSwapOutSince = make(map[uint64]int64)
+	// auto swap-outs of the last 24 hours
+	autoSwapOutHistory []AutoSwapOutRecord
+)
+
+func loadAutoSwapOut() {
+	db.Load("Swaps", "AutoSwapOutSince", &autoSwapOutSince)
+	db.Load("Swaps", "AutoSwapOutHistory", &autoSwapOutHistory)
+}
+
+// peer's premium for the asset and operation, false if not advertised
+func peerPremiumRate(peer *peerswaprpc.PeerSwapPeer, asset peerswaprpc.AssetType, operation peerswaprpc.OperationType) (int64, bool) {
+	if peer.PeerPremium == nil {
+		return 0, false
+	}
+	for _, rate := range peer.PeerPremium.Rates {
+		if rate.Asset == asset && rate.Operation == operation {
+			return rate.PremiumRatePpm, true
+		}
+	}
+	return 0, false
+}
+
+func swapAssetType(asset string) peerswaprpc.AssetType {
+	if asset == "btc" {
+		return peerswaprpc.AssetType_BTC
+	}
+	return peerswaprpc.AssetType_LBTC
+}
+
+// "L-BTC" or "BTC" for logs and messages
+func assetDisplayName(asset string) string {
+	if asset == "btc" {
+		return "BTC"
+	}
+	return "L-BTC"
+}
+
+// sats swapped out automatically during the last 24 hours
+func autoSwapOutSpent() uint64 {
+	autoSwapMutex.Lock()
+	defer autoSwapMutex.Unlock()
+
+	dayAgo := time.Now().Add(-24 * time.Hour).Unix()
+	spent := uint64(0)
+	for _, r := range autoSwapOutHistory {
+		if r.TimeStamp > dayAgo {
+			spent += r.Amount
+		}
+	}
+	return spent
+}
+
+// Finds a candidate for an automatic swap-out
+// The channel must hold local balance above threshold for AutoSwapOutHours
+// and its inbound flow must earn enough PPM
+func findSwapOutCandidate(candidate *AutoSwapParams) error {
+	ranks, err := rankSwapOutCandidates()
+	if err != nil {
+		return err
+	}
+	for _, r := range ranks {
+		if r.Selected {
+			*candidate = r.AutoSwapParams
+		}
+	}
+	return nil
+}
+
+// evaluates all channels for a swap-out with the rule that excluded each,
+// read-only, the timer keeps track of how long channels stay above threshold
+func rankSwapOutCandidates() ([]*AutoSwapRank, error) {
+	asset := config.Config.AutoSwapOutAsset
+
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	res, err := ps.ListPeers(client)
+	if err != nil {
+		return nil, err
+	}
+	peers := res.GetPeers()
+
+	res2, err := ps.ListSwaps(client)
+	if err != nil {
+		return nil, err
+	}
+	swaps := res2.GetSwaps()
+
+	// find last swap timestamps per channel
+	swapTimestamps := make(map[uint64]int64)
+	// true if initiated swap in or received swap out
+	lastWasSwapIn := make(map[uint64]bool)
+
+	// channels with a swap in progress
+	busy := make(map[uint64]bool)
+
+	for _, swap := range swaps {
+		state := simplifySwapState(swap.State)
+		if state == "pending" {
+			busy[swap.LndChanId] = true
+		}
+		if state == "success" && swapTimestamps[swap.LndChanId] < swap.CreatedAt {
+			swapTimestamps[swap.LndChanId] = swap.CreatedAt
+			lastWasSwapIn[swap.LndChanId] = swap.Type+swap.Role == "swap-insender" || swap.Type+swap.Role == "swap-outreceiver"
+		}
+	}
+
+	cl, clean, err := ln.GetClient()
+	if err != nil {
+		return nil, err
+	}
+	defer clean()
+
+	spendable, _, err := ln.FetchChannelLimits(cl)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	minSince := time.Now().Add(-time.Duration(config.Config.AutoSwapOutHours) * time.Hour).Unix()
+	aboveSince := autoSwapOutSinceSnapshot()
+	txCost := openingTxCost(asset)
+
+	ranks := []*AutoSwapRank{}
+
+	for _, peer := range peers {
+		premium, _ := peerPremiumRate(peer, swapAssetType(asset), peerswaprpc.OperationType_SWAP_OUT)
+
+		for _, channel := range peer.Channels {
+			rank := &AutoSwapRank{
+				AutoSwapParams: AutoSwapParams{
+					PeerAlias:      getNodeAlias(peer.NodeId),
+					PeerId:         peer.NodeId,
+					ChannelId:      channel.ChannelId,
+					PremiumRatePpm: premium,
+					Asset:          asset,
+				},
+				Direction: "out",
+			}
+			ranks = append(ranks, rank)
+
+			chanInfo := ln.GetChannelInfo(cl, channel.ChannelId, peer.NodeId)
+			if chanInfo.Capacity == 0 || channel.LocalBalance*100 <= chanInfo.Capacity*config.Config.AutoSwapOutThresholdPct {
+				rank.Excluded = fmt.Sprintf("local balance not above %d%%", config.Config.AutoSwapOutThresholdPct)
+				continue
+			}
+
+			rank.aboveThreshold = true
+			since, ok := aboveSince[channel.ChannelId]
+			if !ok {
+				// the timer starts the clock
+				since = now
+			}
+
+			switch {
+			case !peer.SwapsAllowed:
+				rank.Excluded = "swaps with peer disabled"
+			case !stringIsInSlice(asset, peer.SupportedAssets):
+				rank.Excluded = "peer does not support " + asset
+			case premium > config.Config.AutoSwapPremiumLimit:
+				rank.Excluded = fmt.Sprintf("%s premium %d above limit %d", asset, premium, config.Config.AutoSwapPremiumLimit)
+			case !channel.Active:
+				rank.Excluded = "channel inactive"
+			case busy[channel.ChannelId]:
+				rank.Excluded = "swap in progress"
+			case lastWasSwapIn[channel.ChannelId]:
+				// ignore if there was an opposite peerswap
+				rank.Excluded = "last swap was in"
+			case autoSwapBlocked(peer.NodeId, channel.ChannelId):
+				rank.Excluded = "on hold after failure"
+			case since > minSince:
+				rank.Excluded = fmt.Sprintf("above %d%% for %s of %dh", config.Config.AutoSwapOutThresholdPct, time.Duration(now-since)*time.Second, config.Config.AutoSwapOutHours)
+			}
+			if rank.Excluded != "" {
+				continue
+			}
+
+			lastSwapTimestamp := time.Now().AddDate(0, -6, 0).Unix()
+			if swapTimestamps[channel.ChannelId] > lastSwapTimestamp {
+				lastSwapTimestamp = swapTimestamps[channel.ChannelId]
+			}
+
+			stats := ln.GetChannelStats(channel.ChannelId, uint64(lastSwapTimestamp))
+			if stats.RoutedIn > 100_000 { // ignore insignificant volume
+				rank.RoutingPpm = stats.AssistedFeeSat * 1_000_000 / stats.RoutedIn
+			}
+
+			// only consider source channels (net routing > 1k)
+			if stats.RoutedIn <= stats.RoutedOut+1000 {
+				rank.Excluded = "not a source: RoutedIn-RoutedOut <= 1000"
+				continue
+			}
+
+			// bring balance down to target
+			targetBalance := chanInfo.Capacity * config.Config.AutoSwapOutTargetPct / 100
+			if targetBalance >= channel.LocalBalance {
+				rank.Excluded = fmt.Sprintf("local balance not above target %d%%", config.Config.AutoSwapOutTargetPct)
+				continue
+			}
+			swapAmount := channel.LocalBalance - targetBalance
+
+			// limit to our max HTLC setting, spendable balance and the limit
+			swapAmount = min(swapAmount, spendable[channel.ChannelId], config.Config.AutoSwapOutMaxAmount)
+			if chanInfo.OurMaxHtlc > 0 {
+				swapAmount = min(swapAmount, chanInfo.OurMaxHtlc)
+			}
+
+			// peer must be able to fund it, if known
+			balances := ln.LiquidBalances
+			if asset == "btc" {
+				balances = ln.BitcoinBalances
+			}
+			if ptr := balances[peer.NodeId]; ptr != nil {
+				swapAmount = min(swapAmount, ptr.Amount)
+			}
+
+			rank.Amount = swapAmount
+			rank.Cost = premium*int64(swapAmount)/1_000_000 + txCost
+
+			// peerswap minimum
+			if swapAmount < 100_000 {
+				rank.Excluded = "amount below minimum 100,000"
+			}
+		}
+	}
+
+	selectAutoSwap(ranks, config.Config.AutoSwapOutThresholdPPM)
+
+	return ranks, nil
+}
+
+// copy of the times channels went above threshold
+func autoSwapOutSinceSnapshot() map[uint64]int64 {
+	autoSwapMutex.Lock()
+	defer autoSwapMutex.Unlock()
+
+	result := make(map[uint64]int64, len(autoSwapOutSince))
+	for channelId, since := range autoSwapOutSince {
+		result[channelId] = since
+	}
+	return result
+}
+
+// keeps track of how long the balance stays above threshold,
+// forgets channels that went back below it or closed
+func trackAutoSwapOutSince(ranks []*AutoSwapRank) {
+	now := time.Now().Unix()
+	seen := make(map[uint64]bool)
+	changed := false
+
+	autoSwapMutex.Lock()
+	defer autoSwapMutex.Unlock()
+
+	for _, r := range ranks {
+		if !r.aboveThreshold {
+			continue
+		}
+		seen[r.ChannelId] = true
+		if _, ok := autoSwapOutSince[r.ChannelId]; !ok {
+			autoSwapOutSince[r.ChannelId] = now
+			changed = true
+		}
+	}
+
+	for channelId := range autoSwapOutSince {
+		if !seen[channelId] {
+			delete(autoSwapOutSince, channelId)
+			changed = true
+		}
+	}
+
+	if changed {
+		db.Save("Swaps", "AutoSwapOutSince", autoSwapOutSince)
+	}
+}
+
+// clears the clocks, when the automation is switched on
+func resetAutoSwapOutSince() {
+	autoSwapMutex.Lock()
+	defer autoSwapMutex.Unlock()
+
+	autoSwapOutSince = make(map[uint64]int64)
+	db.Save("Swaps", "AutoSwapOutSince", autoSwapOutSince)
+}
+
+func executeAutoSwapOut() {
+	ranks, err := rankSwapOutCandidates()
+	if err != nil {
+		return
+	}
+
+	// runs every minute to keep track of channel balances
+	trackAutoSwapOutSince(ranks)
+
+	var candidate AutoSwapParams
+	for _, r := range ranks {
+		if r.Selected {
+			candidate = r.AutoSwapParams
+		}
+	}
+
+	// no suitable candidates were found
+	if candidate.Amount == 0 {
+		return
+	}
+
+	spent := autoSwapOutSpent()
+	if spent >= config.Config.AutoSwapOutDailyBudget {
+		return
+	}
+
+	amount := min(candidate.Amount, config.Config.AutoSwapOutDailyBudget-spent, autoSwapAllowance())
+	if amount < 100_000 {
+		return
+	}
+	if amount < candidate.Amount {
+		candidate.Cost -= candidate.PremiumRatePpm * int64(candidate.Amount-amount) / 1_000_000
+		candidate.Amount = amount
+	}
+
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	swapId, err := ps.SwapOut(client, amount, candidate.ChannelId, candidate.Asset, false, config.Config.AutoSwapPremiumLimit)
+	if err != nil {
+		log.Println("Auto Swap-Out error:", err)
+		autoSwapError("out", &candidate, swapId, err)
+		return
+	}
+
+	addAutoSwapInFlight(swapId, "out", candidate)
+
+	autoSwapMutex.Lock()
+	// keep only the last 24 hours
+	dayAgo := time.Now().Add(-24 * time.Hour).Unix()
+	history := []AutoSwapOutRecord{}
+	for _, r := range autoSwapOutHistory {
+		if r.TimeStamp > dayAgo {
+			history = append(history, r)
+		}
+	}
+	autoSwapOutHistory = append(history, AutoSwapOutRecord{
+		TimeStamp: time.Now().Unix(),
+		SwapId:    swapId,
+		ChannelId: candidate.ChannelId,
+		Amount:    amount,
+	})
+	db.Save("Swaps", "AutoSwapOutHistory", autoSwapOutHistory)
+	autoSwapMutex.Unlock()
+
+	assetName := assetDisplayName(candidate.Asset)
+
+	log.Println("Initiated Auto Swap-Out, id: "+swapId+", Peer: "+candidate.PeerAlias+", "+assetName+" Amount: "+formatWithThousandSeparators(amount)+", Inbound PPM: ", formatWithThousandSeparators(candidate.RoutingPpm))
+
+	telegramSendMessage("🤖 Initiated Auto Swap-Out with " + candidate.PeerAlias + " for " + formatWithThousandSeparators(amount) + " " + assetName + " sats. Inbound PPM: " + formatWithThousandSeparators(candidate.RoutingPpm))
+}
@@ -30,7 +30,7 @@ type Configuration struct {
 	ElementsWallet          string
 	TelegramToken           string
 	TelegramChatId          int64
-	PeginClaimScript        string
+	PeginClaimScript        string // legacy single peg-in, moved to db records on startup
 	PeginTxId               string
 	PeginReplacedTxId       string
 	PeginAddress            string
@@ -48,6 +48,19 @@ type Configuration struct {
 	AutoSwapThresholdPPM    uint64
 	AutoSwapTargetPct       uint64
 	AutoSwapPremiumLimit    int64
+	AutoSwapOutEnabled      bool
+	AutoSwapOutAsset        string
+	AutoSwapOutThresholdPct uint64
+	AutoSwapOutTargetPct    uint64
+	AutoSwapOutHours        uint64
+	AutoSwapOutThresholdPPM uint64
+	AutoSwapOutMaxAmount    uint64
+	AutoSwapOutDailyBudget  uint64
+	AutoSwapMaxFailures     uint64
+	AutoSwapMaxInFlight     uint64
+	AutoSwapInFlightAmount  uint64
+	AutoSwapFeeBudgetDay    int64
+	AutoSwapFeeBudgetWeek   int64
 	SecureConnection        bool
 	ServerIPs               string
 	SecurePort              string
@@ -88,6 +101,16 @@ func Load(dataDir string, network string) {
 	Config.AutoSwapMaxAmount = 10_000_000
 	Config.AutoSwapThresholdPPM = 300
 	Config.AutoSwapTargetPct = 70
+	Config.AutoSwapOutAsset = "lbtc"
+	Config.AutoSwapOutThresholdPct = 80
+	Config.AutoSwapOutTargetPct = 50
+	Config.AutoSwapOutHours = 48
+	Config.AutoSwapOutThresholdPPM = 100
+	Config.AutoSwapOutMaxAmount = 5_000_000
+	Config.AutoSwapOutDailyBudget = 10_000_000
+	Config.AutoSwapMaxFailures = 3
+	Config.AutoSwapMaxInFlight = 1
+	Config.AutoSwapInFlightAmount = 20_000_000
 	Config.SecureConnection = false
 	Config.SecurePort = "1985"
 
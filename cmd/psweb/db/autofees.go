@@ -0,0 +1,182 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"go.etcd.io/bbolt"
+)
+
+// nested bucket per channel, one record per event
+const (
+	autoFeeLogBucket = "AutoFeeLog"
+	// simulated changes, never applied to channels
+	dryRunLogBucket = "AutoFeeDryRunLog"
+)
+
+type AutoFeeEvent struct {
+	TimeStamp int64
+	OldRate   int
+	NewRate   int
+	IsInbound bool
+	IsManual  bool
+	// schedule adjustment included in NewRate
+	SchedulePct int
+	// flow-aware adjustment included in NewRate
+	FlowPct int
+	// rate before the adjustments, zero in older events
+	BaseRate int
+}
+
+// AddAutoFeeEvent appends one event to the channel's fee log
+func AddAutoFeeEvent(channelId uint64, e *AutoFeeEvent) error {
+	return addEvent(autoFeeLogBucket, channelId, e)
+}
+
+// LoadAutoFeeLog returns events of all channels in the order of logging
+func LoadAutoFeeLog() (map[uint64][]*AutoFeeEvent, error) {
+	return loadEvents[AutoFeeEvent](autoFeeLogBucket)
+}
+
+// AddDryRunEvent appends one simulated event to the channel's dry run log
+func AddDryRunEvent(channelId uint64, e *AutoFeeEvent) error {
+	return addEvent(dryRunLogBucket, channelId, e)
+}
+
+// LoadDryRunLog returns simulated events of all channels
+func LoadDryRunLog() (map[uint64][]*AutoFeeEvent, error) {
+	return loadEvents[AutoFeeEvent](dryRunLogBucket)
+}
+
+// ClearDryRunLog starts a new simulation of fees and max HTLC
+func ClearDryRunLog() error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	return bdb.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range []string{dryRunLogBucket, maxHtlcDryRunLogBucket} {
+			if tx.Bucket([]byte(bucket)) == nil {
+				continue
+			}
+			if err := tx.DeleteBucket([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func addEvent[T any](bucket string, channelId uint64, e *T) error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	return bdb.Update(func(tx *bbolt.Tx) error {
+		return putEvent(tx, bucket, channelId, e)
+	})
+}
+
+func putEvent[T any](tx *bbolt.Tx, bucket string, channelId uint64, e *T) error {
+	root, err := tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	b, err := root.CreateBucketIfNotExists(itob(channelId))
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(seq), data)
+}
+
+func loadEvents[T any](bucket string) (map[uint64][]*T, error) {
+	result := make(map[uint64][]*T)
+	if bdb == nil {
+		return result, errNotOpen
+	}
+
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket([]byte(bucket))
+		if root == nil {
+			return nil
+		}
+		return root.ForEachBucket(func(k []byte) error {
+			channelId := btoi(k)
+			return root.Bucket(k).ForEach(func(_, v []byte) error {
+				e := new(T)
+				if err := json.Unmarshal(v, e); err != nil {
+					return err
+				}
+				result[channelId] = append(result[channelId], e)
+				return nil
+			})
+		})
+	})
+
+	return result, err
+}
+
+// version 1 stored a full copy of the rule per channel,
+// turn each into the fields that differ from the default rule
+func sparseAutoFeeRules(tx *bbolt.Tx) error {
+	b := tx.Bucket([]byte("AutoFees"))
+	if b == nil {
+		return nil
+	}
+	data := b.Get([]byte("AutoFee"))
+	if data == nil {
+		return nil
+	}
+
+	var rules map[uint64]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+
+	defaults := make(map[string]json.RawMessage)
+	if data := b.Get([]byte("AutoFeeDefaults")); data != nil {
+		if err := json.Unmarshal(data, &defaults); err != nil {
+			return err
+		}
+	}
+
+	overrides := make(map[uint64]map[string]json.RawMessage)
+	for channelId, rule := range rules {
+		// deleted rules were stored as null
+		if rule == nil {
+			continue
+		}
+		changed := make(map[string]json.RawMessage)
+		for field, value := range rule {
+			def, ok := defaults[field]
+			if ok && bytes.Equal(def, value) || !ok && isZeroJson(value) {
+				continue
+			}
+			changed[field] = value
+		}
+		if len(changed) > 0 {
+			overrides[channelId] = changed
+		}
+	}
+
+	if err := put(tx, "AutoFees", []byte("AutoFeeOverrides"), overrides); err != nil {
+		return err
+	}
+	return b.Delete([]byte("AutoFee"))
+}
+
+func isZeroJson(value json.RawMessage) bool {
+	switch string(value) {
+	case "0", "null", "false", `""`, "[]":
+		return true
+	}
+	return false
+}
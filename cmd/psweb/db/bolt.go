@@ -1,51 +1,89 @@
 package db
 
 import (
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"path"
+	"path/filepath"
 	"peerswap-web/cmd/psweb/config"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
 
-// Save saves any object to the Bolt database
-func Save(bucketName string, key string, value interface{}) {
-	// Open the Bolt database
-	db, err := bbolt.Open(path.Join(config.Config.DataDir, "psweb.db"), 0600, nil)
+var (
+	// long-lived handle, opened once at startup
+	bdb *bbolt.DB
+
+	errNotOpen = errors.New("database is not open")
+)
+
+// Open opens psweb.db in the data folder
+func Open() error {
+	if bdb != nil {
+		return nil
+	}
+
+	// do not hang forever if another instance holds the lock
+	handle, err := bbolt.Open(dbPath(), 0600, &bbolt.Options{Timeout: 5 * time.Second})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer db.Close()
 
-	err = db.Update(func(tx *bbolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
-		if err != nil {
-			return err
-		}
-		data, err := json.Marshal(value)
-		if err != nil {
-			return err
-		}
-		return b.Put([]byte(key), data)
+	if err = migrate(handle); err != nil {
+		handle.Close()
+		return err
+	}
+
+	bdb = handle
+	return nil
+}
+
+// IsOpen is false until Open succeeds
+func IsOpen() bool {
+	return bdb != nil
+}
+
+func dbPath() string {
+	return filepath.Join(config.Config.DataDir, "psweb.db")
+}
+
+// Close flushes and releases the database
+func Close() {
+	if bdb == nil {
+		return
+	}
+	if err := bdb.Close(); err != nil {
+		log.Println("Failed to close db:", err)
+	}
+	bdb = nil
+}
+
+// Save saves any object to the Bolt database
+func Save(bucketName string, key string, value interface{}) error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	err := bdb.Update(func(tx *bbolt.Tx) error {
+		return put(tx, bucketName, []byte(key), value)
 	})
 
 	if err != nil {
 		log.Printf("Failed to persist %s to db: %s", key, err)
 	}
+	return err
 }
 
 // Load loads any object from the Bolt database
-func Load(bucketName string, key string, result interface{}) {
-	// Open the Bolt database
-	db, err := bbolt.Open(path.Join(config.Config.DataDir, "psweb.db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
+func Load(bucketName string, key string, result interface{}) error {
+	if bdb == nil {
+		return errNotOpen
 	}
-	defer db.Close()
 
-	db.View(func(tx *bbolt.Tx) error {
+	return bdb.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(bucketName))
 		if b == nil {
 			return fmt.Errorf("bucket %s not found", bucketName)
@@ -57,3 +95,129 @@ func Load(bucketName string, key string, result interface{}) {
 		return json.Unmarshal(data, result)
 	})
 }
+
+// Delete removes a key from the Bolt database
+func Delete(bucketName string, key string) error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	return bdb.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func put(tx *bbolt.Tx, bucketName string, key []byte, value interface{}) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// replaces all records in a bucket
+func resetBucket(tx *bbolt.Tx, bucketName string) (*bbolt.Bucket, error) {
+	if tx.Bucket([]byte(bucketName)) != nil {
+		if err := tx.DeleteBucket([]byte(bucketName)); err != nil {
+			return nil, err
+		}
+	}
+	return tx.CreateBucket([]byte(bucketName))
+}
+
+// big endian keeps numeric keys sorted
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func btoi(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+// version 0 stored maps as single JSON values
+func splitLegacyBlobs(tx *bbolt.Tx) error {
+	if b := tx.Bucket([]byte("AutoFees")); b != nil {
+		if data := b.Get([]byte("AutoFeeLog")); data != nil {
+			var feeLog map[uint64][]*AutoFeeEvent
+			// pre-array format cannot be decoded and is dropped
+			if json.Unmarshal(data, &feeLog) == nil {
+				for channelId, events := range feeLog {
+					for _, e := range events {
+						if err := putEvent(tx, autoFeeLogBucket, channelId, e); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if err := b.Delete([]byte("AutoFeeLog")); err != nil {
+				return err
+			}
+		}
+	}
+
+	if b := tx.Bucket([]byte("Swaps")); b != nil {
+		for key, bucket := range map[string]string{
+			"SwapRebates": swapRebatesBucket,
+			"txFee":       txFeesBucket,
+		} {
+			data := b.Get([]byte(key))
+			if data == nil {
+				continue
+			}
+			var m map[string]int64
+			if err := json.Unmarshal(data, &m); err != nil {
+				return err
+			}
+			for k, v := range m {
+				if err := put(tx, bucket, []byte(k), v); err != nil {
+					return err
+				}
+			}
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if b := tx.Bucket([]byte("ClaimJoin")); b != nil {
+		if data := b.Get([]byte("ClaimParties")); data != nil {
+			var parties []ClaimParty
+			if err := json.Unmarshal(data, &parties); err != nil {
+				return err
+			}
+			if err := putClaimParties(tx, parties); err != nil {
+				return err
+			}
+			if err := b.Delete([]byte("ClaimParties")); err != nil {
+				return err
+			}
+		}
+
+		if data := b.Get([]byte("keyToNodeId")); data != nil {
+			var keys map[string]string
+			if err := json.Unmarshal(data, &keys); err != nil {
+				return err
+			}
+			for k, v := range keys {
+				if err := put(tx, claimKeysBucket, []byte(k), v); err != nil {
+					return err
+				}
+			}
+			if err := b.Delete([]byte("keyToNodeId")); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// keyed by position, initiator first
+const claimPartiesBucket = "ClaimParties"
+
+// learned public keys to node ids
+const claimKeysBucket = "ClaimJoinKeys"
+
+type ClaimParty struct {
+	// peg-in txid
+	TxId string
+	// peg-in vout
+	Vout uint
+	// peg-in claim script
+	ClaimScript string
+	// Liquid address to receive funds
+	Address string
+	// when can be claimed
+	ClaimBlockHeight uint32
+	// to be filled locally by initiator
+	RawTx      string
+	TxoutProof string
+	Amount     uint64
+	FeeShare   uint64
+	PubKey     string
+	SentCount  uint
+	SentTime   time.Time
+}
+
+// SaveClaimParties replaces the stored ClaimJoin group
+func SaveClaimParties(parties []ClaimParty) error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	return bdb.Update(func(tx *bbolt.Tx) error {
+		return putClaimParties(tx, parties)
+	})
+}
+
+func putClaimParties(tx *bbolt.Tx, parties []ClaimParty) error {
+	b, err := resetBucket(tx, claimPartiesBucket)
+	if err != nil {
+		return err
+	}
+	for i, party := range parties {
+		data, err := json.Marshal(party)
+		if err != nil {
+			return err
+		}
+		if err = b.Put(itob(uint64(i)), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadClaimParties returns the stored ClaimJoin group in order
+func LoadClaimParties() ([]ClaimParty, error) {
+	var result []ClaimParty
+	if bdb == nil {
+		return result, errNotOpen
+	}
+
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(claimPartiesBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var party ClaimParty
+			if err := json.Unmarshal(v, &party); err != nil {
+				return err
+			}
+			result = append(result, party)
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// SaveClaimJoinKey maps a public key to node id, empty node id forgets it
+func SaveClaimJoinKey(pubKey, nodeId string) error {
+	if nodeId == "" {
+		return Delete(claimKeysBucket, pubKey)
+	}
+	return Save(claimKeysBucket, pubKey, nodeId)
+}
+
+// LoadClaimJoinKeys returns all learned public keys
+func LoadClaimJoinKeys() (map[string]string, error) {
+	result := make(map[string]string)
+	if bdb == nil {
+		return result, errNotOpen
+	}
+
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(claimKeysBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var nodeId string
+			if err := json.Unmarshal(v, &nodeId); err != nil {
+				return err
+			}
+			result[string(k)] = nodeId
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// ClearClaimJoinKeys forgets all learned public keys
+func ClearClaimJoinKeys() error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	return bdb.Update(func(tx *bbolt.Tx) error {
+		_, err := resetBucket(tx, claimKeysBucket)
+		return err
+	})
+}
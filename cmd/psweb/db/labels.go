@@ -0,0 +1,48 @@
+package db
+
+import (
+	"encoding/json"
+
+	"go.etcd.io/bbolt"
+)
+
+// keyed by txid
+const txLabelsBucket = "TxLabels"
+
+// purpose of a wallet transaction for BIP-329 export
+type TxLabel struct {
+	TxId string
+	// "btc" or "lbtc"
+	Asset string
+	Label string
+}
+
+// SaveTxLabel adds or replaces the label of a transaction
+func SaveTxLabel(l *TxLabel) error {
+	return Save(txLabelsBucket, l.TxId, l)
+}
+
+// LoadTxLabels returns all labels by txid
+func LoadTxLabels() (map[string]*TxLabel, error) {
+	result := make(map[string]*TxLabel)
+	if bdb == nil {
+		return result, errNotOpen
+	}
+
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(txLabelsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			l := new(TxLabel)
+			if err := json.Unmarshal(v, l); err != nil {
+				return err
+			}
+			result[string(k)] = l
+			return nil
+		})
+	})
+
+	return result, err
+}
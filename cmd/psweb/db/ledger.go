@@ -0,0 +1,116 @@
+package db
+
+import (
+	"encoding/json"
+	"sort"
+
+	"go.etcd.io/bbolt"
+)
+
+// keyed by swap id
+const swapLedgerBucket = "SwapLedger"
+
+// profit and loss of one swap, costs in sats, negative are income
+type SwapLedgerEntry struct {
+	SwapId string
+	// swap creation time
+	TimeStamp int64
+	// "swap-in" or "swap-out"
+	Type string
+	// "sender" or "receiver"
+	Role string
+	// "success", "failed" or "pending"
+	State     string
+	Asset     string
+	Amount    uint64
+	ChannelId uint64
+	PeerId    string
+	Premium   int64
+	Rebate    int64
+	Opening   int64
+	Claim     int64
+	// sum of the above
+	Cost int64
+	// routing fees earned by the channel since the swap until the next one:
+	// outbound for added local balance, inbound for added remote
+	Revenue uint64
+	// the next swap on the channel closed the window, Revenue is final
+	Final bool
+}
+
+// SaveLedgerEntry adds or replaces the swap's record
+func SaveLedgerEntry(e *SwapLedgerEntry) error {
+	return Save(swapLedgerBucket, e.SwapId, e)
+}
+
+// LoadLedger returns all swap records, oldest first
+func LoadLedger() ([]*SwapLedgerEntry, error) {
+	var result []*SwapLedgerEntry
+	if bdb == nil {
+		return result, errNotOpen
+	}
+
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(swapLedgerBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			e := new(SwapLedgerEntry)
+			if err := json.Unmarshal(v, e); err != nil {
+				return err
+			}
+			result = append(result, e)
+			return nil
+		})
+	})
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].TimeStamp < result[j].TimeStamp
+	})
+
+	return result, err
+}
+
+// keyed by txid
+const onchainCostsBucket = "OnchainCosts"
+
+// fee of a wallet transaction not related to swaps
+type OnchainCost struct {
+	TxId      string
+	TimeStamp int64
+	// "pegin", "withdrawal" or "pegout"
+	Kind  string
+	Asset string
+	Fee   int64
+}
+
+// SaveOnchainCost records the fee of a transaction
+func SaveOnchainCost(c *OnchainCost) error {
+	return Save(onchainCostsBucket, c.TxId, c)
+}
+
+// LoadOnchainCosts returns all recorded fees by txid
+func LoadOnchainCosts() (map[string]*OnchainCost, error) {
+	result := make(map[string]*OnchainCost)
+	if bdb == nil {
+		return result, errNotOpen
+	}
+
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(onchainCostsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			c := new(OnchainCost)
+			if err := json.Unmarshal(v, c); err != nil {
+				return err
+			}
+			result[string(k)] = c
+			return nil
+		})
+	})
+
+	return result, err
+}
@@ -0,0 +1,35 @@
+package db
+
+// nested bucket per channel, one record per event
+const (
+	maxHtlcLogBucket = "MaxHtlcLog"
+	// simulated changes, cleared with the fee dry run log
+	maxHtlcDryRunLogBucket = "MaxHtlcDryRunLog"
+)
+
+type MaxHtlcEvent struct {
+	TimeStamp int64
+	// sats
+	OldMax uint64
+	NewMax uint64
+}
+
+// AddMaxHtlcEvent appends one event to the channel's max HTLC log
+func AddMaxHtlcEvent(channelId uint64, e *MaxHtlcEvent) error {
+	return addEvent(maxHtlcLogBucket, channelId, e)
+}
+
+// LoadMaxHtlcLog returns events of all channels in the order of logging
+func LoadMaxHtlcLog() (map[uint64][]*MaxHtlcEvent, error) {
+	return loadEvents[MaxHtlcEvent](maxHtlcLogBucket)
+}
+
+// AddMaxHtlcDryRunEvent appends one simulated event
+func AddMaxHtlcDryRunEvent(channelId uint64, e *MaxHtlcEvent) error {
+	return addEvent(maxHtlcDryRunLogBucket, channelId, e)
+}
+
+// LoadMaxHtlcDryRunLog returns simulated events of all channels
+func LoadMaxHtlcDryRunLog() (map[uint64][]*MaxHtlcEvent, error) {
+	return loadEvents[MaxHtlcEvent](maxHtlcDryRunLogBucket)
+}
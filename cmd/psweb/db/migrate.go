@@ -0,0 +1,162 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// holds SchemaVersion
+const metaBucket = "Meta"
+
+// ErrNewerSchema means the database was written by a later PSWeb version
+var ErrNewerSchema = errors.New("database was created by a newer PSWeb version")
+
+type migration struct {
+	// schema version after this migration
+	version     uint64
+	description string
+	apply       func(tx *bbolt.Tx) error
+}
+
+// append only, never edit or reorder released migrations
+var migrations = []migration{
+	{1, "split whole-map blobs into per-record keys", splitLegacyBlobs},
+	{2, "keep only changed fields of custom auto fee rules", sparseAutoFeeRules},
+}
+
+// SchemaVersion is what this binary reads and writes
+func SchemaVersion() uint64 {
+	return migrations[len(migrations)-1].version
+}
+
+func getVersion(tx *bbolt.Tx) (uint64, error) {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return 0, nil
+	}
+	data := b.Get([]byte("SchemaVersion"))
+	if data == nil {
+		return 0, nil
+	}
+	var version uint64
+	err := json.Unmarshal(data, &version)
+	return version, err
+}
+
+func setVersion(tx *bbolt.Tx, version uint64) error {
+	return put(tx, metaBucket, []byte("SchemaVersion"), version)
+}
+
+// new database has no buckets yet
+func isEmpty(tx *bbolt.Tx) bool {
+	empty := true
+	tx.ForEach(func(_ []byte, _ *bbolt.Bucket) error {
+		empty = false
+		return errors.New("stop")
+	})
+	return empty
+}
+
+// brings schema up to date, backing up the file first
+func migrate(handle *bbolt.DB) error {
+	var (
+		version uint64
+		empty   bool
+	)
+
+	err := handle.View(func(tx *bbolt.Tx) error {
+		var err error
+		version, err = getVersion(tx)
+		empty = isEmpty(tx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("cannot read schema version: %w", err)
+	}
+
+	latest := SchemaVersion()
+
+	if version > latest {
+		return fmt.Errorf("%w: schema %d, supported %d", ErrNewerSchema, version, latest)
+	}
+
+	if version == latest {
+		return nil
+	}
+
+	if empty {
+		// nothing to migrate
+		return handle.Update(func(tx *bbolt.Tx) error {
+			return setVersion(tx, latest)
+		})
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", dbPath(), version, time.Now().Format("20060102-150405"))
+	err = handle.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(backup, 0600)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot back up database before migration: %w", err)
+	}
+	log.Println("Database backed up to", backup)
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		// each step commits together with its version
+		err = handle.Update(func(tx *bbolt.Tx) error {
+			if err := m.apply(tx); err != nil {
+				return err
+			}
+			return setVersion(tx, m.version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration to schema %d failed, restore from %s: %w", m.version, backup, err)
+		}
+		log.Printf("Database migrated to schema %d: %s", m.version, m.description)
+	}
+
+	return nil
+}
+
+// Snapshot writes a consistent copy of the database
+func Snapshot(w io.Writer) error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	return bdb.View(func(tx *bbolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// CheckFile verifies that a database file can be opened by this binary
+func CheckFile(fileName string) error {
+	handle, err := bbolt.Open(fileName, 0600, &bbolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	var version uint64
+	err = handle.View(func(tx *bbolt.Tx) error {
+		version, err = getVersion(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if version > SchemaVersion() {
+		return fmt.Errorf("%w: schema %d, supported %d", ErrNewerSchema, version, SchemaVersion())
+	}
+	return nil
+}
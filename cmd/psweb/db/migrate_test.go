@@ -0,0 +1,223 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"peerswap-web/cmd/psweb/config"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// writes a psweb.db with the given buckets and keys into a fresh data folder
+func writeLegacyDb(t *testing.T, buckets map[string]map[string]any) {
+	t.Helper()
+	config.Config.DataDir = t.TempDir()
+
+	handle, err := bbolt.Open(dbPath(), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Close()
+
+	err = handle.Update(func(tx *bbolt.Tx) error {
+		for bucket, keys := range buckets {
+			for key, value := range keys {
+				if err := put(tx, bucket, []byte(key), value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		buckets map[string]map[string]any
+		wantErr error
+		backup  bool
+	}{
+		{
+			name: "empty file",
+		},
+		{
+			name: "current schema",
+			buckets: map[string]map[string]any{
+				metaBucket: {"SchemaVersion": SchemaVersion()},
+			},
+		},
+		{
+			name: "newer schema",
+			buckets: map[string]map[string]any{
+				metaBucket: {"SchemaVersion": SchemaVersion() + 1},
+			},
+			wantErr: ErrNewerSchema,
+		},
+		{
+			name: "legacy data",
+			buckets: map[string]map[string]any{
+				"Config": {"Key": "value"},
+			},
+			backup: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeLegacyDb(t, tt.buckets)
+
+			err := Open()
+			defer Close()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if IsOpen() {
+					t.Error("database is open after a failed migration")
+				}
+				return
+			}
+
+			var version uint64
+			if err := Load(metaBucket, "SchemaVersion", &version); err != nil {
+				t.Fatal(err)
+			}
+			if version != SchemaVersion() {
+				t.Errorf("schema = %d, want %d", version, SchemaVersion())
+			}
+
+			backups, _ := filepath.Glob(dbPath() + ".v*.bak")
+			if got := len(backups) > 0; got != tt.backup {
+				t.Errorf("backup made = %v, want %v", got, tt.backup)
+			}
+		})
+	}
+}
+
+func TestSplitLegacyBlobs(t *testing.T) {
+	events := map[uint64][]*AutoFeeEvent{
+		101: {{TimeStamp: 1, OldRate: 100, NewRate: 200}, {TimeStamp: 2, OldRate: 200, NewRate: 150}},
+		202: {{TimeStamp: 3, OldRate: 50, NewRate: -10, IsInbound: true}},
+	}
+	rebates := map[string]int64{"swap1": 120, "swap2": 0}
+	txFees := map[string]int64{"tx1": 450}
+	parties := []ClaimParty{{TxId: "a", Vout: 1, Amount: 100_000}, {TxId: "b", Amount: 200_000}}
+	keys := map[string]string{"pub1": "node1"}
+
+	writeLegacyDb(t, map[string]map[string]any{
+		"AutoFees":  {"AutoFeeLog": events},
+		"Swaps":     {"SwapRebates": rebates, "txFee": txFees},
+		"ClaimJoin": {"ClaimParties": parties, "keyToNodeId": keys},
+	})
+
+	if err := Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+
+	tests := []struct {
+		name string
+		load func() (any, error)
+		want any
+	}{
+		{"fee log", func() (any, error) { return LoadAutoFeeLog() }, events},
+		{"swap rebates", func() (any, error) { return LoadSwapRebates() }, rebates},
+		{"tx fees", func() (any, error) { return LoadTxFees() }, txFees},
+		{"claim parties", func() (any, error) { return LoadClaimParties() }, parties},
+		{"claim keys", func() (any, error) { return LoadClaimJoinKeys() }, keys},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// blobs are gone once split
+	for bucket, key := range map[string]string{
+		"AutoFees":  "AutoFeeLog",
+		"Swaps":     "txFee",
+		"ClaimJoin": "ClaimParties",
+	} {
+		var blob json.RawMessage
+		if err := Load(bucket, key, &blob); err == nil {
+			t.Errorf("%s/%s is still stored", bucket, key)
+		}
+	}
+}
+
+func TestSparseAutoFeeRules(t *testing.T) {
+	defaults := map[string]any{"NormalRate": 300, "LowLiqRate": 1000, "CoolOffHours": 12}
+
+	tests := []struct {
+		name  string
+		rules map[uint64]any
+		want  map[uint64]map[string]any
+	}{
+		{
+			name:  "same as default",
+			rules: map[uint64]any{1: map[string]any{"NormalRate": 300, "LowLiqRate": 1000, "CoolOffHours": 12}},
+			want:  map[uint64]map[string]any{},
+		},
+		{
+			name:  "changed fields only",
+			rules: map[uint64]any{1: map[string]any{"NormalRate": 500, "LowLiqRate": 1000, "CoolOffHours": 24}},
+			want:  map[uint64]map[string]any{1: {"NormalRate": 500.0, "CoolOffHours": 24.0}},
+		},
+		{
+			name:  "zero field unknown to default",
+			rules: map[uint64]any{1: map[string]any{"NormalRate": 300, "FloorPPM": 0, "Schedule": []any{}}},
+			want:  map[uint64]map[string]any{},
+		},
+		{
+			name:  "field unknown to default",
+			rules: map[uint64]any{1: map[string]any{"NormalRate": 300, "FloorPPM": 50}},
+			want:  map[uint64]map[string]any{1: {"FloorPPM": 50.0}},
+		},
+		{
+			name:  "deleted rule",
+			rules: map[uint64]any{1: nil, 2: map[string]any{"LowLiqRate": 900}},
+			want:  map[uint64]map[string]any{2: {"LowLiqRate": 900.0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeLegacyDb(t, map[string]map[string]any{
+				metaBucket: {"SchemaVersion": 1},
+				"AutoFees": {"AutoFee": tt.rules, "AutoFeeDefaults": defaults},
+			})
+
+			if err := Open(); err != nil {
+				t.Fatal(err)
+			}
+			defer Close()
+
+			var got map[uint64]map[string]any
+			if err := Load("AutoFees", "AutoFeeOverrides", &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("overrides = %v, want %v", got, tt.want)
+			}
+
+			var blob json.RawMessage
+			if err := Load("AutoFees", "AutoFee", &blob); err == nil {
+				t.Error("full rules are still stored")
+			}
+		})
+	}
+}
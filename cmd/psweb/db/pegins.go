@@ -0,0 +1,149 @@
+package db
+
+import (
+	"encoding/json"
+
+	"go.etcd.io/bbolt"
+)
+
+// keyed by id, oldest first
+const peginsBucket = "Pegins"
+
+// Liquid peg-in or BTC withdrawal
+type Pegin struct {
+	Id        uint64
+	TimeStamp int64
+	// "external" until funding txid is provided
+	TxId string
+	// RBF history, oldest first
+	ReplacedTxIds []string
+	// empty for BTC withdrawal
+	ClaimScript string
+	Address     string
+	Amount      int64
+	FeeRate     float64
+	ClaimJoin   bool
+	// last seen, -1 when not found
+	Confirmations int32
+	// Liquid claim txid
+	ClaimTxId string
+	// "pending", "claimed" (by ClaimJoin, to be notified), "complete", "failed" or "cancelled"
+	Status string
+}
+
+// IsPegin is false for ordinary BTC withdrawal
+func (p *Pegin) IsPegin() bool {
+	return p.ClaimScript != ""
+}
+
+// SavePegin adds or replaces the record, new ones get the next id.
+// A stale pending copy does not revert the claim made by ClaimJoin.
+func SavePegin(p *Pegin) error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	return bdb.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(peginsBucket))
+		if err != nil {
+			return err
+		}
+		if p.Id == 0 {
+			if p.Id, err = b.NextSequence(); err != nil {
+				return err
+			}
+		} else if data := b.Get(itob(p.Id)); data != nil && p.Status == "pending" {
+			var stored Pegin
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return err
+			}
+			if stored.Status == "claimed" {
+				p.Status = stored.Status
+				p.ClaimTxId = stored.ClaimTxId
+			}
+		}
+		return putPegin(b, p)
+	})
+}
+
+// UpdatePegin changes the stored record in one transaction, no-op if not found
+func UpdatePegin(id uint64, update func(p *Pegin)) error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	return bdb.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(peginsBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get(itob(id))
+		if data == nil {
+			return nil
+		}
+		var p Pegin
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		update(&p)
+		return putPegin(b, &p)
+	})
+}
+
+func putPegin(b *bbolt.Bucket, p *Pegin) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(p.Id), data)
+}
+
+// LoadPegin returns the record or nil if not found
+func LoadPegin(id uint64) (*Pegin, error) {
+	if bdb == nil {
+		return nil, errNotOpen
+	}
+
+	var result *Pegin
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(peginsBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get(itob(id))
+		if data == nil {
+			return nil
+		}
+		result = new(Pegin)
+		return json.Unmarshal(data, result)
+	})
+
+	return result, err
+}
+
+// LoadPegins returns records in the status, all if empty, oldest first
+func LoadPegins(status string) ([]*Pegin, error) {
+	var result []*Pegin
+	if bdb == nil {
+		return result, errNotOpen
+	}
+
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(peginsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			p := new(Pegin)
+			if err := json.Unmarshal(v, p); err != nil {
+				return err
+			}
+			if status == "" || p.Status == status {
+				result = append(result, p)
+			}
+			return nil
+		})
+	})
+
+	return result, err
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"encoding/json"
+
+	"go.etcd.io/bbolt"
+)
+
+// keyed by id, oldest first
+const pegoutsBucket = "Pegouts"
+
+// Liquid peg-out by sendtomainchain
+type Pegout struct {
+	Id        uint64
+	TimeStamp int64
+	// Liquid txid
+	TxId    string
+	Address string
+	Amount  int64
+	// pays to our lightning wallet
+	ToWallet bool
+	// last seen, -1 when not found
+	Confirmations int32
+	// federation's Bitcoin payout
+	PayoutTxId          string
+	PayoutConfirmations int32
+	// "pending", "complete" or "cancelled"
+	Status string
+}
+
+// SavePegout adds or replaces the record, new ones get the next id
+func SavePegout(p *Pegout) error {
+	if bdb == nil {
+		return errNotOpen
+	}
+
+	return bdb.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(pegoutsBucket))
+		if err != nil {
+			return err
+		}
+		if p.Id == 0 {
+			if p.Id, err = b.NextSequence(); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(p.Id), data)
+	})
+}
+
+// LoadPegout returns the record or nil if not found
+func LoadPegout(id uint64) (*Pegout, error) {
+	if bdb == nil {
+		return nil, errNotOpen
+	}
+
+	var result *Pegout
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(pegoutsBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get(itob(id))
+		if data == nil {
+			return nil
+		}
+		result = new(Pegout)
+		return json.Unmarshal(data, result)
+	})
+
+	return result, err
+}
+
+// LoadPegouts returns records in the status, all if empty, oldest first
+func LoadPegouts(status string) ([]*Pegout, error) {
+	var result []*Pegout
+	if bdb == nil {
+		return result, errNotOpen
+	}
+
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(pegoutsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			p := new(Pegout)
+			if err := json.Unmarshal(v, p); err != nil {
+				return err
+			}
+			if status == "" || p.Status == status {
+				result = append(result, p)
+			}
+			return nil
+		})
+	})
+
+	return result, err
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"encoding/json"
+
+	"go.etcd.io/bbolt"
+)
+
+// keyed by swap id
+const swapRebatesBucket = "SwapRebates"
+
+// keyed by txid
+const txFeesBucket = "TxFees"
+
+// SaveSwapRebate stores the rebate paid or received for a swap
+func SaveSwapRebate(swapId string, amount int64) error {
+	return Save(swapRebatesBucket, swapId, amount)
+}
+
+// LoadSwapRebates returns all rebates by swap id
+func LoadSwapRebates() (map[string]int64, error) {
+	return loadInt64s(swapRebatesBucket)
+}
+
+// SaveTxFee caches the onchain fee of a swap transaction
+func SaveTxFee(txId string, fee int64) error {
+	return Save(txFeesBucket, txId, fee)
+}
+
+// LoadTxFees returns all cached fees by txid
+func LoadTxFees() (map[string]int64, error) {
+	return loadInt64s(txFeesBucket)
+}
+
+func loadInt64s(bucketName string) (map[string]int64, error) {
+	result := make(map[string]int64)
+	if bdb == nil {
+		return result, errNotOpen
+	}
+
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var amount int64
+			if err := json.Unmarshal(v, &amount); err != nil {
+				return err
+			}
+			result[string(k)] = amount
+			return nil
+		})
+	})
+
+	return result, err
+}
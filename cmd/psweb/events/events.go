@@ -0,0 +1,130 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// event types
+const (
+	SWAP      = "swap"
+	FORWARD   = "forward"
+	FEE       = "fee"
+	PEGIN     = "pegin"
+	PEGOUT    = "pegout"
+	CLAIMJOIN = "claimjoin"
+)
+
+// buffered per subscriber, slow consumers lose events
+const queueSize = 100
+
+type Event struct {
+	Type      string
+	TimeStamp int64
+	Data      any
+}
+
+// swap state transition
+type Swap struct {
+	Id        string
+	Asset     string
+	Type      string
+	Role      string
+	PeerId    string
+	Amount    uint64
+	OldState  string
+	State     string
+	ChannelId uint64
+}
+
+// settled forward
+type Forward struct {
+	ChannelIn  uint64
+	ChannelOut uint64
+	AmountOut  uint64
+	FeeMsat    uint64
+	TimeStamp  int64
+}
+
+// fee rate change
+type Fee struct {
+	ChannelId uint64
+	OldRate   int
+	NewRate   int
+	IsInbound bool
+	IsManual  bool
+}
+
+// peg-in or BTC withdrawal progress
+type Pegin struct {
+	// peg-in record id
+	Id            uint64
+	TxId          string
+	Confirmations int32
+	IsPegin       bool
+	Status        string
+}
+
+// Liquid peg-out progress
+type Pegout struct {
+	// peg-out record id
+	Id     uint64
+	TxId   string
+	Status string
+}
+
+// ClaimJoin status change
+type ClaimJoin struct {
+	Status string
+	Role   string
+}
+
+var (
+	mu          sync.Mutex
+	subscribers = make(map[chan *Event]bool)
+)
+
+// Subscribe returns a channel of events and a function to unsubscribe
+func Subscribe() (<-chan *Event, func()) {
+	ch := make(chan *Event, queueSize)
+
+	mu.Lock()
+	subscribers[ch] = true
+	mu.Unlock()
+
+	return ch, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if subscribers[ch] {
+			delete(subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// HasSubscribers allows to skip polling when nobody listens
+func HasSubscribers() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(subscribers) > 0
+}
+
+// Publish sends event to all subscribers without blocking
+func Publish(eventType string, data any) {
+	e := &Event{
+		Type:      eventType,
+		TimeStamp: time.Now().Unix(),
+		Data:      data,
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+			// queue is full, drop
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -191,7 +192,7 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		BitcoinBalance:    uint64(btcBalance),
 		Filter:            nodeId != "" || state != "" || role != "",
 		AutoSwapEnabled:   config.Config.AutoSwapEnabled,
-		PeginPending:      config.Config.PeginTxId != "" && config.Config.PeginClaimScript != "",
+		PeginPending:      hasPendingPegin(),
 		ClaimJoinInvite:   ln.ClaimJoinHandler != "",
 		AdvertiseLiquid:   ln.AdvertiseLiquidBalance,
 		AdvertiseBitcoin:  ln.AdvertiseBitcoinBalance,
@@ -264,45 +265,7 @@ func peerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	swaps := res5.GetSwaps()
 
-	senderInProfit := int64(0)
-	senderOutProfit := int64(0)
-	receiverInProfit := int64(0)
-	receiverOutProfit := int64(0)
-	cost := int64(0)
-	new := false
-	persist := false // have new tx fees to persist
-
-	for _, swap := range swaps {
-		switch swap.Type + swap.Role {
-		case "swap-insender":
-			if swap.PeerNodeId == id {
-				cost, _, new = swapCost(swap)
-				senderInProfit -= cost
-			}
-		case "swap-outsender":
-			if swap.PeerNodeId == id {
-				cost, _, new = swapCost(swap)
-				senderOutProfit -= cost
-			}
-		case "swap-outreceiver":
-			if swap.InitiatorNodeId == id {
-				cost, _, new = swapCost(swap)
-				receiverOutProfit -= cost
-			}
-		case "swap-inreceiver":
-			if swap.InitiatorNodeId == id {
-				cost, _, new = swapCost(swap)
-				receiverInProfit -= cost
-			}
-		}
-
-		persist = persist || new
-	}
-
-	// save to db
-	if persist {
-		db.Save("Swaps", "txFee", txFee)
-	}
+	senderInProfit, senderOutProfit, receiverInProfit, receiverOutProfit := peerSwapProfits(swaps, id)
 
 	senderInProfitPPM := int64(0)
 	receiverInProfitPPM := int64(0)
@@ -819,6 +782,19 @@ func bitcoinHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer clean()
 
+	// pending peg-in or withdrawal as displayed
+	type PeginView struct {
+		*db.Pegin
+		IsExternal          bool
+		TargetConfirmations int32
+		Progress            int32
+		ETA                 string
+		SuggestedFeeRate    float64
+		MinBumpFeeRate      float64
+		CanBump             bool
+		ClaimJoinStatus     string
+	}
+
 	type Page struct {
 		Authenticated       bool
 		ErrorMessage        string
@@ -826,22 +802,11 @@ func bitcoinHandler(w http.ResponseWriter, r *http.Request) {
 		ColorScheme         string
 		BitcoinBalance      uint64
 		Outputs             *[]ln.UTXO
-		PeginTxId           string
-		IsPegin             bool // false for ordinary BTC withdrawal
-		IsExternal          bool
-		PeginAddress        string
-		PeginAmount         uint64
+		Pegins              []*PeginView
 		BitcoinApi          string
-		Confirmations       int32
-		TargetConfirmations int32
-		Progress            int32
-		ETA                 string
-		FeeRate             float64
 		LiquidFeeRate       float64
 		MempoolFeeRate      float64
 		SuggestedFeeRate    float64
-		MinBumpFeeRate      float64
-		CanBump             bool
 		CanRBF              bool
 		IsCLN               bool
 		BitcoinAddress      string
@@ -849,66 +814,101 @@ func bitcoinHandler(w http.ResponseWriter, r *http.Request) {
 		BitcoinSwaps        bool
 		HasDiscountedvSize  bool
 		CanClaimJoin        bool
-		IsClaimJoin         bool
-		ClaimJoinStatus     string
 		HasClaimJoinPending bool
 		ClaimJoinHours      int
 		ClaimJointTimeLimit string
 	}
 
 	btcBalance := ln.ConfirmedWalletBalance(cl)
-	fee := float64(mempoolFeeRate)
-	confs := int32(0)
-	canBump := false
-	canCPFP := false
 
 	var utxos []ln.UTXO
 	ln.ListUnspent(cl, &utxos, int32(1))
 
-	isExternal := config.Config.PeginTxId == "external"
+	pegins := activePegins()
+	if len(pegins) > 0 {
+		// update ClaimJoin status
+		checkPegin()
+		pegins = activePegins()
+	}
 
-	if config.Config.PeginTxId != "" {
-		if !isExternal {
-			confs, canCPFP = peginConfirmations(config.Config.PeginTxId)
-			// update ClaimJoin status
-			checkPegin()
-			if confs == 0 && config.Config.PeginFeeRate > 0 {
-				canBump = true
+	currentBlockHeight := int32(ln.GetBlockHeight())
+	cjHours := 34
+	cjTimeLimit := ""
+
+	if ln.MyRole == "none" && ln.ClaimJoinHandler != "" {
+		cjHours = int((int32(ln.JoinBlockHeight) - currentBlockHeight + int32(peginBlocks)) / 6)
+		cjTimeLimit = time.Now().Add(time.Duration(10*(ln.JoinBlockHeight-uint32(currentBlockHeight))) * time.Minute).Format("3:04 PM")
+	}
+
+	views := []*PeginView{}
+	for _, p := range pegins {
+		if p.Status != "pending" {
+			// claimed, about to complete
+			continue
+		}
+
+		v := &PeginView{
+			Pegin:      p,
+			IsExternal: p.TxId == "external",
+		}
+		views = append(views, v)
+
+		fee := float64(mempoolFeeRate)
+		confs := int32(0)
+		canCPFP := false
+
+		if !v.IsExternal {
+			confs, canCPFP = peginConfirmations(p.TxId)
+			if confs == 0 && p.FeeRate > 0 {
+				v.CanBump = true
 				if !ln.CanRBF() {
 					// can bump only if there is a change output
-					canBump = canCPFP
+					v.CanBump = canCPFP
 					if fee > 0 {
 						// for CPFP the fee must be 1.5x the market
 						fee = fee + fee/2
 					}
 				}
-				if fee < config.Config.PeginFeeRate+1 {
-					fee = config.Config.PeginFeeRate + 1 // min increment
+				if fee < p.FeeRate+1 {
+					fee = p.FeeRate + 1 // min increment
 				}
 			}
 		}
-	}
-
-	duration := time.Duration(10*(int32(peginBlocks)-confs)) * time.Minute
-	maxConfs := int32(peginBlocks)
-	cjHours := 34
-	cjTimeLimit := ""
+		p.Confirmations = confs
+
+		duration := time.Duration(10*(int32(peginBlocks)-confs)) * time.Minute
+		maxConfs := int32(peginBlocks)
+
+		if p.ClaimJoin {
+			switch {
+			case ln.ClaimPeginId == p.Id:
+				v.ClaimJoinStatus = ln.ClaimStatus
+				if ln.MyRole != "none" {
+					target := int32(ln.ClaimBlockHeight)
+					maxConfs = target - currentBlockHeight + confs
+					duration = time.Duration(10*(target-currentBlockHeight)) * time.Minute
+				}
+			case ln.ClaimPeginId != 0:
+				v.ClaimJoinStatus = "Another peg-in is in ClaimJoin, will join the next one"
+			case confs > 0:
+				v.ClaimJoinStatus = "Funding tx confirmed, awaiting ClaimJoin"
+			default:
+				v.ClaimJoinStatus = "Awaiting funding tx to confirm"
+			}
+		}
 
-	currentBlockHeight := int32(ln.GetBlockHeight())
-	if ln.MyRole != "none" {
-		target := int32(ln.ClaimBlockHeight)
-		maxConfs = target - currentBlockHeight + confs
-		duration = time.Duration(10*(target-currentBlockHeight)) * time.Minute
-	} else if ln.ClaimJoinHandler != "" {
-		cjHours = int((int32(ln.JoinBlockHeight) - currentBlockHeight + int32(peginBlocks)) / 6)
-		cjTimeLimit = time.Now().Add(time.Duration(10*(ln.JoinBlockHeight-uint32(currentBlockHeight))) * time.Minute).Format("3:04 PM")
-	}
+		if maxConfs > 0 {
+			v.Progress = confs * 100 / maxConfs
+		}
+		v.TargetConfirmations = maxConfs
 
-	progress := confs * 100 / int32(maxConfs)
+		v.ETA = time.Now().Add(duration).Format("3:04 PM")
+		if duration < 0 {
+			v.ETA = "Past due"
+		}
 
-	eta := time.Now().Add(duration).Format("3:04 PM")
-	if duration < 0 {
-		eta = "Past due"
+		v.SuggestedFeeRate = math.Ceil(fee*100) / 100
+		v.MinBumpFeeRate = math.Ceil((p.FeeRate+1)*100) / 100
 	}
 
 	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
@@ -933,30 +933,17 @@ func bitcoinHandler(w http.ResponseWriter, r *http.Request) {
 		ColorScheme:         config.Config.ColorScheme,
 		BitcoinBalance:      uint64(btcBalance),
 		Outputs:             &utxos,
-		PeginTxId:           config.Config.PeginTxId,
-		IsPegin:             config.Config.PeginClaimScript != "",
-		IsExternal:          isExternal,
-		PeginAddress:        config.Config.PeginAddress,
-		PeginAmount:         uint64(config.Config.PeginAmount),
+		Pegins:              views,
 		BitcoinApi:          config.Config.BitcoinApi,
-		Confirmations:       confs,
-		TargetConfirmations: maxConfs,
-		Progress:            progress,
-		ETA:                 eta,
-		FeeRate:             config.Config.PeginFeeRate,
 		MempoolFeeRate:      mempoolFeeRate,
 		LiquidFeeRate:       liquid.EstimateFee(),
-		SuggestedFeeRate:    math.Ceil(fee*100) / 100,
-		MinBumpFeeRate:      math.Ceil((config.Config.PeginFeeRate+1)*100) / 100,
-		CanBump:             canBump,
+		SuggestedFeeRate:    math.Ceil(float64(mempoolFeeRate)*100) / 100,
 		CanRBF:              ln.CanRBF(),
 		IsCLN:               ln.IMPLEMENTATION == "CLN",
 		BitcoinAddress:      addr,
 		AdvertiseEnabled:    ln.AdvertiseBitcoinBalance,
 		BitcoinSwaps:        config.Config.BitcoinSwaps,
 		CanClaimJoin:        hasDiscountedvSize && len(peers) > 0,
-		IsClaimJoin:         config.Config.PeginClaimJoin,
-		ClaimJoinStatus:     ln.ClaimStatus,
 		HasClaimJoinPending: ln.ClaimJoinHandler != "",
 		ClaimJointTimeLimit: cjTimeLimit,
 		ClaimJoinHours:      cjHours,
@@ -990,6 +977,12 @@ func peginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !db.IsOpen() {
+			// the record must persist to follow the transaction
+			redirectWithError(w, r, "/bitcoin?", errors.New("database is not open"))
+			return
+		}
+
 		isPegin := r.FormValue("isPegin") == "true"
 		isExternal := r.FormValue("externalButton") != ""
 
@@ -1069,7 +1062,7 @@ func peginHandler(w http.ResponseWriter, r *http.Request) {
 
 		address := ""
 		claimScript := ""
-		config.Config.PeginClaimJoin = false
+		claimJoin := false
 
 		if isPegin {
 			// check that elements is fully synced
@@ -1151,8 +1144,8 @@ func peginHandler(w http.ResponseWriter, r *http.Request) {
 			claimScript = addr.ClaimScript
 
 			if hasDiscountedvSize {
-				config.Config.PeginClaimJoin = r.FormValue("claimJoin") == "on"
-				if config.Config.PeginClaimJoin {
+				claimJoin = r.FormValue("claimJoin") == "on"
+				if claimJoin && ln.ClaimPeginId == 0 && ln.MyRole == "none" {
 					ln.ClaimStatus = "Awaiting funding tx to confirm"
 					db.Save("ClaimJoin", "ClaimStatus", ln.ClaimStatus)
 				}
@@ -1162,14 +1155,36 @@ func peginHandler(w http.ResponseWriter, r *http.Request) {
 			claimScript = ""
 		}
 
+		pegin := &db.Pegin{
+			TimeStamp:     time.Now().Unix(),
+			ClaimScript:   claimScript,
+			Address:       address,
+			ClaimJoin:     claimJoin,
+			Confirmations: -1,
+			Status:        "pending",
+		}
+
+		if isExternal {
+			log.Println("Peg-in address for external funding:", address, "Claim script:", claimScript)
+			pegin.TxId = "external"
+		}
+
+		// persist the claim script before any coins are sent
+		if err := db.SavePegin(pegin); err != nil {
+			redirectWithError(w, r, "/bitcoin?", err)
+			return
+		}
+
 		if !isExternal {
-			label := "Liquid Pegin"
+			label := "Liquid Peg-in"
 			if !isPegin {
 				label = "BTC Withdrawal"
 			}
 
 			res, err := ln.SendCoinsWithUtxos(&selectedOutputs, address, amount, fee, subtractFeeFromAmount, label)
 			if err != nil {
+				pegin.Status = "cancelled"
+				db.SavePegin(pegin)
 				redirectWithError(w, r, "/bitcoin?", err)
 				return
 			}
@@ -1181,21 +1196,17 @@ func peginHandler(w http.ResponseWriter, r *http.Request) {
 				log.Println("BTC withdrawal pending, TxId:", res.TxId, "RawHex:", res.RawHex)
 				telegramSendMessage(fmt.Sprintf("⛓️ BTC withdrawal pending: %s sats, fee rate: %0.2f s/vb, TxId: `%s`", formatWithThousandSeparators(uint64(res.AmountSat)), res.ExactSatVb, res.TxId))
 			}
-			config.Config.PeginAmount = res.AmountSat
-			config.Config.PeginTxId = res.TxId
-			config.Config.PeginFeeRate = res.ExactSatVb
-		} else {
-			log.Println("Peg-in address for external funding:", address, "Claim script:", claimScript)
-			config.Config.PeginTxId = "external"
-		}
-
-		config.Config.PeginClaimScript = claimScript
-		config.Config.PeginAddress = address
-		config.Config.PeginReplacedTxId = ""
-
-		if err := config.Save(); err != nil {
-			redirectWithError(w, r, "/bitcoin?", err)
-			return
+			pegin.Amount = res.AmountSat
+			pegin.TxId = res.TxId
+			pegin.FeeRate = res.ExactSatVb
+			labelTx("btc", res.TxId, label)
+
+			if err := db.SavePegin(pegin); err != nil {
+				// the record stays without txid, it can be provided on the bitcoin page
+				log.Println("Cannot save peg-in txid", res.TxId, "of record", pegin.Id, ":", err)
+				redirectWithError(w, r, "/bitcoin?", err)
+				return
+			}
 		}
 
 		// Redirect to bitcoin page to follow the peg-in progress
@@ -1219,12 +1230,22 @@ func bumpfeeHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if config.Config.PeginTxId == "" || config.Config.PeginTxId == "external" {
+		// followPegin must not save its older copy over the bump
+		peginMutex.Lock()
+		defer peginMutex.Unlock()
+
+		pegin, err := pendingPeginFromForm(r)
+		if err != nil {
+			redirectWithError(w, r, "/bitcoin?", err)
+			return
+		}
+
+		if pegin.TxId == "external" {
 			redirectWithError(w, r, "/bitcoin?", errors.New("no pending peg-in"))
 			return
 		}
 
-		confs, _ := peginConfirmations(config.Config.PeginTxId)
+		confs, _ := peginConfirmations(pegin.TxId)
 		if confs > 0 {
 			// transaction has been confirmed already
 			http.Redirect(w, r, "/bitcoin", http.StatusSeeOther)
@@ -1232,11 +1253,11 @@ func bumpfeeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		label := "Liquid Peg-in"
-		if config.Config.PeginClaimScript == "" {
+		if !pegin.IsPegin() {
 			label = "BTC Withdrawal"
 		}
 
-		res, err := ln.BumpPeginFee(fee, label)
+		res, err := ln.BumpPeginFee(pegin, fee, label)
 		if err != nil {
 			redirectWithError(w, r, "/bitcoin?", err)
 			return
@@ -1244,18 +1265,19 @@ func bumpfeeHandler(w http.ResponseWriter, r *http.Request) {
 
 		if ln.CanRBF() {
 			log.Println("RBF TxId:", res.TxId, "RawHex:", res.RawHex)
-			config.Config.PeginReplacedTxId = config.Config.PeginTxId
-			config.Config.PeginAmount = res.AmountSat
-			config.Config.PeginTxId = res.TxId
+			pegin.ReplacedTxIds = append(pegin.ReplacedTxIds, pegin.TxId)
+			pegin.Amount = res.AmountSat
+			pegin.TxId = res.TxId
+			labelTx("btc", res.TxId, label)
 		} else {
 			// txid not available, let's hope LND broadcasted it fine
 			log.Println("CPFP initiated")
 		}
 
 		// save the new rate, so the next bump cannot be lower
-		config.Config.PeginFeeRate = res.ExactSatVb
+		pegin.FeeRate = res.ExactSatVb
 
-		if err := config.Save(); err != nil {
+		if err := db.SavePegin(pegin); err != nil {
 			redirectWithError(w, r, "/bitcoin?", err)
 			return
 		}
@@ -1277,6 +1299,128 @@ type FeeLog struct {
 	NewRate   int64
 	IsInbound bool
 	IsManual  bool
+	IsDryRun  bool
+	// schedule and flow adjustments included in NewRate
+	SchedulePct int
+	FlowPct     int
+}
+
+func (f FeeLog) AdjustPct() int {
+	return f.SchedulePct + f.FlowPct
+}
+
+type AutoSwapOutcomeView struct {
+	AutoSwapOutcome
+	PeerAlias string
+	TimeUTC   string
+	TimeAgo   string
+}
+
+type AutoSwapBlockView struct {
+	AutoSwapBlock
+	What     string
+	UntilUTC string
+}
+
+type MaxHtlcLog struct {
+	ln.MaxHtlcEvent
+	TimeUTC  string
+	TimeAgo  string
+	IsDryRun bool
+}
+
+// reads AutoFeeParams from the AF page form
+func parseAutoFeeParams(r *http.Request) (ln.AutoFeeParams, error) {
+	var params ln.AutoFeeParams
+
+	type field struct {
+		name  string
+		value *int
+	}
+
+	fields := []field{
+		{"failBump", &params.FailedBumpPPM},
+		{"failedMoveThreshold", &params.FailedMoveThreshold},
+		{"lowLiqPct", &params.LowLiqPct},
+		{"lowLiqRate", &params.LowLiqRate},
+		{"normalRate", &params.NormalRate},
+		{"excessPct", &params.ExcessPct},
+		{"excessRate", &params.ExcessRate},
+		{"inactivityDays", &params.InactivityDays},
+		{"inactivityDropPPM", &params.InactivityDropPPM},
+		{"inactivityDropPct", &params.InactivityDropPct},
+		{"coolOffHours", &params.CoolOffHours},
+		{"peerFeeMode", &params.PeerFeeMode},
+		{"peerFeeMargin", &params.PeerFeeMargin},
+		{"peerFeeCapPct", &params.PeerFeeCapPct},
+		{"maxStepPPM", &params.MaxStepPPM},
+		{"maxChangesPerDay", &params.MaxChangesPerDay},
+		{"ceilingPPM", &params.CeilingPPM},
+		{"floorPPM", &params.FloorPPM},
+		{"flowMaxPct", &params.FlowMaxPct},
+		{"flowDrainDays", &params.FlowDrainDays},
+		{"flowFillDays", &params.FlowFillDays},
+		{"maxHtlcPct", &params.MaxHtlcPct},
+	}
+
+	if ln.HasInboundFees() {
+		fields = append(fields, field{"lowLiqDiscount", &params.LowLiqDiscount})
+	}
+
+	for _, f := range fields {
+		v, err := strconv.Atoi(r.FormValue(f.name))
+		if err != nil {
+			return params, fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.value = v
+	}
+
+	if params.PeerFeeMode < 0 || params.PeerFeeMode >= len(ln.PeerFeeModes) {
+		return params, errors.New("invalid peer fee mode")
+	}
+	if params.PeerFeeCapPct < 0 {
+		return params, errors.New("peer fee cap % cannot be negative")
+	}
+	if params.MaxStepPPM < 0 || params.MaxChangesPerDay < 0 || params.CeilingPPM < 0 || params.FloorPPM < 0 {
+		return params, errors.New("limits cannot be negative")
+	}
+	if params.CeilingPPM > 0 && params.FloorPPM > params.CeilingPPM {
+		return params, errors.New("floor cannot be above ceiling")
+	}
+	if params.FlowMaxPct < 0 || params.FlowDrainDays < 0 || params.FlowFillDays < 0 {
+		return params, errors.New("flow settings cannot be negative")
+	}
+	if params.MaxHtlcPct < 0 || params.MaxHtlcPct > 100 {
+		return params, errors.New("max HTLC % must be between 0 and 100")
+	}
+	if params.LowLiqDiscount > 0 {
+		// same as setChannelFeeRate
+		return params, errors.New("inbound fee rate cannot be positive")
+	}
+
+	schedule, err := ln.ParseSchedule(r.FormValue("schedule"))
+	if err != nil {
+		return params, err
+	}
+	params.Schedule = schedule
+
+	if ln.HasInboundFees() {
+		bands, err := ln.ParseInboundBands(r.FormValue("inboundBands"))
+		if err != nil {
+			return params, err
+		}
+		params.InboundBands = bands
+	}
+
+	return params, nil
+}
+
+// for messages about the channel's base rule
+func groupOrDefault(channelId uint64) string {
+	if _, group := ln.AutoFeeBaseRule(channelId); group != "" {
+		return "group " + group
+	}
+	return "default rule"
 }
 
 func afHandler(w http.ResponseWriter, r *http.Request) {
@@ -1293,6 +1437,15 @@ func afHandler(w http.ResponseWriter, r *http.Request) {
 
 	rule, isCustom := ln.AutoFeeRule(channelId)
 
+	// editing a group rule
+	group := r.URL.Query().Get("group")
+	if channelId == 0 && ln.AutoFeeGroups[group] != nil {
+		rule = ln.AutoFeeGroups[group]
+		peerName = "Group: " + group
+	} else {
+		group = ""
+	}
+
 	// Get Lightning client
 	cl, clean, er := ln.GetClient()
 	if er != nil {
@@ -1349,12 +1502,16 @@ func afHandler(w http.ResponseWriter, r *http.Request) {
 				LocalPct:    ch.LocalBalance * 100 / (ch.LocalBalance + ch.RemoteBalance),
 				Rule:        rule,
 				Custom:      custom,
+				Group:       ln.AutoFeeGroupOf[ch.ChannelId],
 				AutoFee:     af,
 				FeeRate:     outboundFeeRates[ch.ChannelId],
 				InboundRate: inboundFeeRates[ch.ChannelId],
 				ChannelId:   ch.ChannelId,
 				DaysNoFlow:  daysNoFlow,
 				Active:      ch.Active,
+
+				ProposedRate:    ln.ProposedFeeRate(ch.ChannelId, outboundFeeRates[ch.ChannelId], false),
+				ProposedInbound: ln.ProposedFeeRate(ch.ChannelId, inboundFeeRates[ch.ChannelId], true),
 			})
 
 			if ch.ChannelId == channelId {
@@ -1380,6 +1537,54 @@ func afHandler(w http.ResponseWriter, r *http.Request) {
 		channelId = 0
 	}
 
+	// the channel's group and the fields it overrides
+	if channelId > 0 {
+		_, group = ln.AutoFeeBaseRule(channelId)
+	}
+	var overridden []string
+	for name := range ln.AutoFeeOverrides[channelId] {
+		overridden = append(overridden, name)
+	}
+	sort.Strings(overridden)
+
+	type RuleGroup struct {
+		Name    string
+		Members int
+	}
+	var groups []RuleGroup
+	for _, name := range ln.AutoFeeGroupNames() {
+		groups = append(groups, RuleGroup{
+			Name:    name,
+			Members: ln.GroupMembers(name),
+		})
+	}
+
+	// references for peer-aware modes
+	peerFeeRate := int64(-1)
+	marketFeeRate := int64(-1)
+	maxHtlc := uint64(0)
+	// when drained or filled at the measured pace
+	flowForecast := ""
+	if channelId > 0 {
+		if days, draining := ln.FlowForecast(channelId, int(localPct), capacity); days >= 0 {
+			flowForecast = "Fills"
+			if draining {
+				flowForecast = "Drains"
+			}
+			if days < 1 {
+				flowForecast += fmt.Sprintf(" in %.0fh", days*24)
+			} else {
+				flowForecast += fmt.Sprintf(" in %.1fd", days)
+			}
+		}
+		info := ln.GetChannelInfo(cl, channelId, peerId)
+		peerFeeRate = info.PeerFeeRate
+		maxHtlc = info.OurMaxHtlc
+		if rate, ok := ln.MarketFeeRate(peerId); ok {
+			marketFeeRate = int64(rate)
+		}
+	}
+
 	// sort by LocalPct ascending
 	sort.Slice(channelList, func(i, j int) bool {
 		return channelList[i].LocalPct < channelList[j].LocalPct
@@ -1431,6 +1636,36 @@ func afHandler(w http.ResponseWriter, r *http.Request) {
 						NewRate:   int64(event.NewRate),
 						IsInbound: event.IsInbound,
 						IsManual:  event.IsManual,
+
+						SchedulePct: event.SchedulePct,
+						FlowPct:     event.FlowPct,
+					})
+				}
+			}
+		}
+	}
+
+	// simulated changes are shown while dry run is on
+	if ln.AutoFeeDryRun {
+		for id := range ln.AutoFeeDryRunLog {
+			if channelId > 0 && channelId != id {
+				continue
+			}
+			for _, event := range ln.AutoFeeDryRunLog[id] {
+				if event.TimeStamp > startTS {
+					feeLog = append(feeLog, FeeLog{
+						TimeStamp: event.TimeStamp,
+						TimeUTC:   time.Unix(event.TimeStamp, 0).UTC().Format(time.RFC1123),
+						TimeAgo:   timePassedAgo(time.Unix(event.TimeStamp, 0)),
+						Alias:     getNodeAlias(peerNodeId[id]),
+						ChannelId: id,
+						OldRate:   int64(event.OldRate),
+						NewRate:   int64(event.NewRate),
+						IsInbound: event.IsInbound,
+						IsDryRun:  true,
+
+						SchedulePct: event.SchedulePct,
+						FlowPct:     event.FlowPct,
 					})
 				}
 			}
@@ -1442,6 +1677,38 @@ func afHandler(w http.ResponseWriter, r *http.Request) {
 		return feeLog[i].TimeStamp > feeLog[j].TimeStamp
 	})
 
+	var maxHtlcLog []MaxHtlcLog
+
+	// 30 days max HTLC log for a single channel
+	if channelId > 0 {
+		for _, event := range ln.MaxHtlcLog[channelId] {
+			if event.TimeStamp > startTS {
+				maxHtlcLog = append(maxHtlcLog, MaxHtlcLog{
+					TimeUTC:      time.Unix(event.TimeStamp, 0).UTC().Format(time.RFC1123),
+					TimeAgo:      timePassedAgo(time.Unix(event.TimeStamp, 0)),
+					MaxHtlcEvent: *event,
+				})
+			}
+		}
+		if ln.AutoFeeDryRun {
+			for _, event := range ln.MaxHtlcDryRunLog[channelId] {
+				if event.TimeStamp > startTS {
+					maxHtlcLog = append(maxHtlcLog, MaxHtlcLog{
+						TimeUTC:      time.Unix(event.TimeStamp, 0).UTC().Format(time.RFC1123),
+						TimeAgo:      timePassedAgo(time.Unix(event.TimeStamp, 0)),
+						IsDryRun:     true,
+						MaxHtlcEvent: *event,
+					})
+				}
+			}
+		}
+	}
+
+	// sort by TimeStamp descending
+	sort.Slice(maxHtlcLog, func(i, j int) bool {
+		return maxHtlcLog[i].TimeStamp > maxHtlcLog[j].TimeStamp
+	})
+
 	forwardsLog := ln.ForwardsLog(channelId, startTS)
 
 	for i, f := range *forwardsLog {
@@ -1466,15 +1733,26 @@ func afHandler(w http.ResponseWriter, r *http.Request) {
 		LocalPct       uint64
 		FeeRate        int64
 		InboundRate    int64
+		ProposedRate   int64
+		PeerFeeRate    int64
+		MarketFeeRate  int64
+		FlowForecast   string
+		PeerFeeModes   []string
 		GlobalEnabled  bool
+		DryRun         bool
 		ChannelList    []*ln.AutoFeeStatus
 		Params         *ln.AutoFeeParams
 		CustomRule     bool
+		Overridden     []string
+		Group          string
+		Groups         []RuleGroup
 		Enabled        bool // for the displayed channel
 		AnyEnabled     bool // for any channel
 		HasInboundFees bool
 		Chart          *[]ln.DataPoint
 		FeeLog         []FeeLog
+		MaxHtlc        uint64
+		MaxHtlcLog     []MaxHtlcLog
 		ForwardsLog    *[]ln.DataPoint
 		RedColor       string
 		GreenColor     string
@@ -1494,21 +1772,32 @@ func afHandler(w http.ResponseWriter, r *http.Request) {
 		MempoolFeeRate: mempoolFeeRate,
 		ColorScheme:    config.Config.ColorScheme,
 		GlobalEnabled:  ln.AutoFeeEnabledAll,
+		DryRun:         ln.AutoFeeDryRun,
 		PeerName:       peerName,
 		PeerId:         peerId,
 		Capacity:       capacity,
 		LocalPct:       localPct,
 		FeeRate:        feeRate,
 		InboundRate:    inboundRate,
+		ProposedRate:   ln.ProposedFeeRate(channelId, feeRate, false),
+		PeerFeeRate:    peerFeeRate,
+		MarketFeeRate:  marketFeeRate,
+		FlowForecast:   flowForecast,
+		PeerFeeModes:   ln.PeerFeeModes,
 		ChannelId:      channelId,
 		ChannelList:    channelList,
 		Params:         rule,
 		CustomRule:     isCustom,
+		Overridden:     overridden,
+		Group:          group,
+		Groups:         groups,
 		Enabled:        ln.AutoFeeEnabled[channelId],
 		AnyEnabled:     anyEnabled,
 		HasInboundFees: ln.HasInboundFees(),
 		Chart:          chart,
 		FeeLog:         feeLog,
+		MaxHtlc:        maxHtlc,
+		MaxHtlcLog:     maxHtlcLog,
 		ForwardsLog:    forwardsLog,
 		RedColor:       redColor,
 		GreenColor:     greenColor,
@@ -1518,6 +1807,163 @@ func afHandler(w http.ResponseWriter, r *http.Request) {
 	executeTemplate(w, "af", data)
 }
 
+// replays forwards through a candidate rule
+// ?id=0 tests the default rule on all channels that use it
+func backtestHandler(w http.ResponseWriter, r *http.Request) {
+	channelId, _ := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	nextPage := "/af?id=" + strconv.FormatUint(channelId, 10) + "&"
+
+	// the engine may change the rules meanwhile
+	rules := ln.AutoFeeRulesSnapshot()
+
+	// replaying a group rule on its members
+	group := r.FormValue("group")
+	if channelId > 0 || rules.Groups[group] == nil {
+		group = ""
+	} else {
+		nextPage = "/af?group=" + url.QueryEscape(group) + "&"
+	}
+
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days < 1 {
+		days = 30
+	}
+
+	// candidate rule comes from AF page form, otherwise test the current one
+	params, _, _ := rules.Rule(channelId)
+	if group != "" {
+		params = rules.Groups[group]
+	}
+	candidate := r.FormValue("normalRate") != ""
+	if candidate {
+		newRule, err := parseAutoFeeParams(r)
+		if err != nil {
+			redirectWithError(w, r, nextPage, err)
+			return
+		}
+		params = &newRule
+	}
+
+	cl, clean, er := ln.GetClient()
+	if er != nil {
+		redirectWithError(w, r, "/config?", er)
+		return
+	}
+	defer clean()
+
+	res, err := ln.ListPeers(cl, "", nil)
+	if err != nil {
+		redirectWithError(w, r, nextPage, err)
+		return
+	}
+
+	outboundFeeRates := make(map[uint64]int64)
+	inboundFeeRates := make(map[uint64]int64)
+	ln.FeeReport(cl, outboundFeeRates, inboundFeeRates)
+
+	type Row struct {
+		Alias string
+		*ln.BacktestResult
+	}
+
+	var rows []Row
+	totals := &ln.BacktestResult{Days: days}
+	peerName := "Default Rule"
+	if group != "" {
+		peerName = "Group: " + group
+	}
+
+	for _, peer := range res.GetPeers() {
+		for _, ch := range peer.Channels {
+			if channelId > 0 && ch.ChannelId != channelId {
+				continue
+			}
+			if channelId == 0 {
+				if _, g, isCustom := rules.Rule(ch.ChannelId); isCustom || g != group {
+					// not governed by the group or default rule
+					continue
+				}
+			}
+
+			alias := getNodeAlias(peer.NodeId)
+			if channelId > 0 {
+				peerName = alias
+			}
+
+			peerRate := int64(-1)
+			if params.PeerFeeMode == ln.PEER_FEE_PEER {
+				peerRate = ln.GetChannelInfo(cl, ch.ChannelId, peer.NodeId).PeerFeeRate
+			}
+			peerFeeRef := ln.PeerFeeRef(params, peer.NodeId, peerRate)
+
+			result := ln.Backtest(ch.ChannelId, params, days, ch.LocalBalance, ch.LocalBalance+ch.RemoteBalance, outboundFeeRates[ch.ChannelId], peerFeeRef)
+			rows = append(rows, Row{
+				Alias:          alias,
+				BacktestResult: result,
+			})
+
+			totals.Days = result.Days
+			totals.Forwards += result.Forwards
+			totals.Changes += result.Changes
+			totals.ActualChanges += result.ActualChanges
+			totals.ActualRevenue += result.ActualRevenue
+			totals.EstimatedRevenue += result.EstimatedRevenue
+		}
+	}
+
+	if channelId > 0 && len(rows) == 0 {
+		redirectWithError(w, r, "/af?", errors.New("channel not found"))
+		return
+	}
+
+	// biggest difference first
+	sort.Slice(rows, func(i, j int) bool {
+		return math.Abs(float64(rows[i].RevenueDiff())) > math.Abs(float64(rows[j].RevenueDiff()))
+	})
+
+	var timeline []ln.BacktestPoint
+	if channelId > 0 {
+		timeline = rows[0].Timeline
+	}
+
+	type Page struct {
+		Authenticated  bool
+		ErrorMessage   string
+		PopUpMessage   string
+		MempoolFeeRate float64
+		ColorScheme    string
+		ChannelId      uint64
+		Group          string
+		PeerName       string
+		Days           int
+		MaxDays        int
+		Params         *ln.AutoFeeParams
+		Candidate      bool
+		Rows           []Row
+		Totals         *ln.BacktestResult
+		Timeline       []ln.BacktestPoint
+	}
+
+	data := Page{
+		Authenticated:  config.Config.SecureConnection && config.Config.Password != "",
+		MempoolFeeRate: mempoolFeeRate,
+		ColorScheme:    config.Config.ColorScheme,
+		ChannelId:      channelId,
+		Group:          group,
+		PeerName:       peerName,
+		Days:           totals.Days,
+		MaxDays:        ln.BACKTEST_MAX_DAYS,
+		Params:         params,
+		Candidate:      candidate,
+		Rows:           rows,
+		Totals:         totals,
+		Timeline:       timeline,
+	}
+
+	// executing template named "backtest"
+	executeTemplate(w, "backtest", data)
+}
+
 func swapHandler(w http.ResponseWriter, r *http.Request) {
 	keys, ok := r.URL.Query()["id"]
 	if !ok || len(keys[0]) < 1 {
@@ -1687,7 +2133,7 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 	swapData += `<tr><td style="text-align: right">LndChanId:</td><td>`
 	swapData += strconv.FormatUint(uint64(swap.LndChanId), 10)
 
-	cost, breakdown, persist := swapCost(swap)
+	cost, breakdown := swapCost(swap)
 	if cost != 0 {
 		ppm := cost * 1_000_000 / int64(swap.Amount)
 
@@ -1710,11 +2156,6 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 			swapData += `<tr><td style="text-align: right">PPM:</td><td>`
 			swapData += formatSigned(ppm)
 		}
-
-		// save to db
-		if persist {
-			db.Save("Swaps", "txFee", txFee)
-		}
 	}
 
 	swapData += `</td></tr>
@@ -1730,6 +2171,11 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func configHandler(w http.ResponseWriter, r *http.Request) {
+	renderConfig(w, r, "")
+}
+
+// newToken is shown once after creation
+func renderConfig(w http.ResponseWriter, r *http.Request, newToken string) {
 	//check for error message to display
 	errorMessage := ""
 	keys, ok := r.URL.Query()["err"]
@@ -1760,6 +2206,9 @@ func configHandler(w http.ResponseWriter, r *http.Request) {
 		Implementation  string
 		HTTPS           string
 		IsPossibleHTTPS bool // disabled on Umbrel
+		ApiTokens       []*ApiToken
+		NewToken        string
+		Scopes          []string
 	}
 
 	data := Page{
@@ -1774,6 +2223,9 @@ func configHandler(w http.ResponseWriter, r *http.Request) {
 		Implementation:  ln.IMPLEMENTATION,
 		HTTPS:           "https://" + hostname + ".local:" + config.Config.SecurePort,
 		IsPossibleHTTPS: os.Getenv("NO_HTTPS") == "",
+		ApiTokens:       listApiTokens(),
+		NewToken:        newToken,
+		Scopes:          scopeNames,
 	}
 
 	// executing template named "config"
@@ -1928,12 +2380,65 @@ func liquidHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var swapOutCandidate AutoSwapParams
+
+	if err := findSwapOutCandidate(&swapOutCandidate); err != nil {
+		log.Printf("unable findSwapOutCandidate: %v", err)
+		redirectWithError(w, r, "/liquid?", err)
+		return
+	}
+
 	walletInfo, err := liquid.GetWalletInfo()
 	if err != nil {
 		redirectWithError(w, r, "/?", err)
 		return
 	}
 
+	recent, peerBlocks, channelBlocks := autoSwapOutcomesSnapshot()
+
+	// most recent first
+	var outcomes []AutoSwapOutcomeView
+	for i := len(recent) - 1; i >= 0 && len(outcomes) < 10; i-- {
+		o := recent[i]
+		outcomes = append(outcomes, AutoSwapOutcomeView{
+			AutoSwapOutcome: o,
+			PeerAlias:       getNodeAlias(o.PeerId),
+			TimeUTC:         time.Unix(o.TimeStamp, 0).UTC().Format(time.RFC1123),
+			TimeAgo:         timePassedAgo(time.Unix(o.TimeStamp, 0)),
+		})
+	}
+
+	var blocks []AutoSwapBlockView
+	now := time.Now().Unix()
+	for peerId, b := range peerBlocks {
+		if b.Until > now {
+			blocks = append(blocks, AutoSwapBlockView{
+				AutoSwapBlock: b,
+				What:          "Peer " + getNodeAlias(peerId),
+				UntilUTC:      time.Unix(b.Until, 0).UTC().Format(time.RFC1123),
+			})
+		}
+	}
+	for channelId, b := range channelBlocks {
+		if b.Until > now {
+			blocks = append(blocks, AutoSwapBlockView{
+				AutoSwapBlock: b,
+				What:          "Channel " + strconv.FormatUint(channelId, 10),
+				UntilUTC:      time.Unix(b.Until, 0).UTC().Format(time.RFC1123),
+			})
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Until < blocks[j].Until
+	})
+
+	inFlight := autoSwapsInFlightSnapshot()
+	inFlightAmount := uint64(0)
+	for _, s := range inFlight {
+		inFlightAmount += s.Amount
+	}
+	_, pausedReason := autoSwapRoom()
+
 	type Page struct {
 		Authenticated           bool
 		ErrorMessage            string
@@ -1952,6 +2457,29 @@ func liquidHandler(w http.ResponseWriter, r *http.Request) {
 		AutoSwapCandidate       *AutoSwapParams
 		AutoSwapTargetPct       uint64
 		AutoSwapPremiumLimit    int64
+		AutoSwapMaxFailures     uint64
+		AutoSwapMaxInFlight     uint64
+		AutoSwapInFlightAmount  uint64
+		AutoSwapFeeBudgetDay    int64
+		AutoSwapFeeBudgetWeek   int64
+		AutoSwapsInFlight       int
+		AutoSwapsInFlightAmount uint64
+		AutoSwapFeesDay         int64
+		AutoSwapFeesWeek        int64
+		AutoSwapPausedReason    string
+		AutoSwapOutcomes        []AutoSwapOutcomeView
+		AutoSwapBlocks          []AutoSwapBlockView
+		AutoSwapOutEnabled      bool
+		AutoSwapOutAsset        string
+		AutoSwapOutThresholdPct uint64
+		AutoSwapOutTargetPct    uint64
+		AutoSwapOutHours        uint64
+		AutoSwapOutThresholdPPM uint64
+		AutoSwapOutMaxAmount    uint64
+		AutoSwapOutDailyBudget  uint64
+		AutoSwapOutSpent        uint64
+		AutoSwapOutCandidate    *AutoSwapParams
+		BitcoinSwaps            bool
 		AdvertiseEnabled        bool
 		DescriptorsWallet       bool
 	}
@@ -1974,6 +2502,29 @@ func liquidHandler(w http.ResponseWriter, r *http.Request) {
 		AutoSwapTargetPct:       config.Config.AutoSwapTargetPct,
 		AutoSwapPremiumLimit:    config.Config.AutoSwapPremiumLimit,
 		AutoSwapCandidate:       &candidate,
+		AutoSwapMaxFailures:     config.Config.AutoSwapMaxFailures,
+		AutoSwapMaxInFlight:     config.Config.AutoSwapMaxInFlight,
+		AutoSwapInFlightAmount:  config.Config.AutoSwapInFlightAmount,
+		AutoSwapFeeBudgetDay:    config.Config.AutoSwapFeeBudgetDay,
+		AutoSwapFeeBudgetWeek:   config.Config.AutoSwapFeeBudgetWeek,
+		AutoSwapsInFlight:       len(inFlight),
+		AutoSwapsInFlightAmount: inFlightAmount,
+		AutoSwapFeesDay:         autoSwapFeesSpent(time.Now().Add(-24 * time.Hour).Unix()),
+		AutoSwapFeesWeek:        autoSwapFeesSpent(time.Now().AddDate(0, 0, -7).Unix()),
+		AutoSwapPausedReason:    pausedReason,
+		AutoSwapOutcomes:        outcomes,
+		AutoSwapBlocks:          blocks,
+		AutoSwapOutEnabled:      config.Config.AutoSwapOutEnabled,
+		AutoSwapOutAsset:        config.Config.AutoSwapOutAsset,
+		AutoSwapOutThresholdPct: config.Config.AutoSwapOutThresholdPct,
+		AutoSwapOutTargetPct:    config.Config.AutoSwapOutTargetPct,
+		AutoSwapOutHours:        config.Config.AutoSwapOutHours,
+		AutoSwapOutThresholdPPM: config.Config.AutoSwapOutThresholdPPM,
+		AutoSwapOutMaxAmount:    config.Config.AutoSwapOutMaxAmount,
+		AutoSwapOutDailyBudget:  config.Config.AutoSwapOutDailyBudget,
+		AutoSwapOutSpent:        autoSwapOutSpent(),
+		AutoSwapOutCandidate:    &swapOutCandidate,
+		BitcoinSwaps:            config.Config.BitcoinSwaps,
 		AdvertiseEnabled:        ln.AdvertiseLiquidBalance,
 		DescriptorsWallet:       walletInfo.Descriptors,
 	}
@@ -1982,6 +2533,344 @@ func liquidHandler(w http.ResponseWriter, r *http.Request) {
 	executeTemplate(w, "liquid", data)
 }
 
+// lists all channels considered by auto swaps with the rule that excluded each
+func autoSwapsHandler(w http.ResponseWriter, r *http.Request) {
+	swapIns, err := rankSwapInCandidates(true)
+	if err != nil {
+		redirectWithError(w, r, "/liquid?", err)
+		return
+	}
+
+	swapOuts, err := rankSwapOutCandidates()
+	if err != nil {
+		redirectWithError(w, r, "/liquid?", err)
+		return
+	}
+
+	sortAutoSwapRanks(swapIns)
+	sortAutoSwapRanks(swapOuts)
+
+	type Section struct {
+		Title   string
+		Enabled bool
+		// what PPM means for this direction
+		Score string
+		Ranks []*AutoSwapRank
+	}
+
+	type Page struct {
+		Authenticated  bool
+		ErrorMessage   string
+		PopUpMessage   string
+		MempoolFeeRate float64
+		ColorScheme    string
+		PausedReason   string
+		Sections       []Section
+	}
+
+	_, pausedReason := autoSwapRoom()
+
+	data := Page{
+		Authenticated:  config.Config.SecureConnection && config.Config.Password != "",
+		MempoolFeeRate: mempoolFeeRate,
+		ColorScheme:    config.Config.ColorScheme,
+		PausedReason:   pausedReason,
+		Sections: []Section{
+			{
+				Title:   "Swap In Candidates",
+				Enabled: config.Config.AutoSwapEnabled,
+				Score:   "Channel's realized PPM from the previous swap or the last 6 months",
+				Ranks:   swapIns,
+			},
+			{
+				Title:   "Swap Out Candidates",
+				Enabled: config.Config.AutoSwapOutEnabled,
+				Score:   "Fees earned by the channel's inbound flow since the previous swap or in the last 6 months",
+				Ranks:   swapOuts,
+			},
+		},
+	}
+
+	// executing template named "autoswaps"
+	executeTemplate(w, "autoswaps", data)
+}
+
+// swap P&L ledger with totals, exports as CSV or JSON
+func ledgerHandler(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if _, err := time.Parse("2006-01", month); month != "" && err != nil {
+		redirectWithError(w, r, "/ledger?", errors.New("month must be like 2006-01"))
+		return
+	}
+	entries := ledgerMonth(sortedLedger(), month)
+
+	fileName := "swap-ledger"
+	if month != "" {
+		fileName += "-" + month
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", fileName))
+		if err := writeLedgerCsv(w, entries); err != nil {
+			log.Println("Ledger CSV:", err)
+		}
+		return
+	case "json":
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.json", fileName))
+		writeJson(w, http.StatusOK, entries)
+		return
+	}
+
+	type Row struct {
+		*db.SwapLedgerEntry
+		PeerAlias string
+		Date      string
+		Net       int64
+	}
+
+	type Page struct {
+		Authenticated  bool
+		ErrorMessage   string
+		PopUpMessage   string
+		MempoolFeeRate float64
+		ColorScheme    string
+		Month          string
+		Rows           []Row
+		ByPeer         []*LedgerTotal
+		ByMonth        []*LedgerTotal
+		Total          *LedgerTotal
+	}
+
+	data := Page{
+		Authenticated:  config.Config.SecureConnection && config.Config.Password != "",
+		ErrorMessage:   r.URL.Query().Get("err"),
+		MempoolFeeRate: mempoolFeeRate,
+		ColorScheme:    config.Config.ColorScheme,
+		Month:          month,
+		ByPeer:         ledgerByPeer(entries),
+		// all months to choose from
+		ByMonth: ledgerByMonth(sortedLedger()),
+		Total:   &LedgerTotal{},
+	}
+
+	if t := ledgerTotals(entries, func(*db.SwapLedgerEntry) (string, string) { return "", "" }); len(t) > 0 {
+		data.Total = t[0]
+	}
+
+	// newest first
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		data.Rows = append(data.Rows, Row{
+			SwapLedgerEntry: e,
+			PeerAlias:       getNodeAlias(e.PeerId),
+			Date:            time.Unix(e.TimeStamp, 0).UTC().Format("2006-01-02 15:04"),
+			Net:             int64(e.Revenue) - e.Cost,
+		})
+	}
+
+	// executing template named "ledger"
+	executeTemplate(w, "ledger", data)
+}
+
+// reads from and to dates like 2006-01-02, last 30 days by default
+func accountingPeriod(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -30)
+
+	var err error
+	if d := r.URL.Query().Get("from"); d != "" {
+		if from, err = time.Parse(time.DateOnly, d); err != nil {
+			return from, to, errors.New("from date must be like 2006-01-02")
+		}
+	}
+	if d := r.URL.Query().Get("to"); d != "" {
+		if to, err = time.Parse(time.DateOnly, d); err != nil {
+			return from, to, errors.New("to date must be like 2006-01-02")
+		}
+	}
+	if to.Before(from) {
+		return from, to, errors.New("to date is before from date")
+	}
+
+	return from, to, nil
+}
+
+// node profit and loss for a date range, exports as CSV or JSON
+func accountingHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := accountingPeriod(r)
+	if err != nil {
+		redirectWithError(w, r, "/accounting?", err)
+		return
+	}
+
+	// whole days, UTC
+	report, err := accountingReport(from.Unix(), to.AddDate(0, 0, 1).Unix()-1)
+	if err != nil {
+		redirectWithError(w, r, "/ledger?", err)
+		return
+	}
+
+	fileName := "accounting-" + from.Format(time.DateOnly) + "-" + to.Format(time.DateOnly)
+
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", fileName))
+		if err := writeAccountingCsv(w, report); err != nil {
+			log.Println("Accounting CSV:", err)
+		}
+		return
+	case "json":
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.json", fileName))
+		writeJson(w, http.StatusOK, report)
+		return
+	}
+
+	type Page struct {
+		Authenticated  bool
+		ErrorMessage   string
+		PopUpMessage   string
+		MempoolFeeRate float64
+		ColorScheme    string
+		From           string
+		To             string
+		Report         *AccountingReport
+	}
+
+	data := Page{
+		Authenticated:  config.Config.SecureConnection && config.Config.Password != "",
+		ErrorMessage:   r.URL.Query().Get("err"),
+		MempoolFeeRate: mempoolFeeRate,
+		ColorScheme:    config.Config.ColorScheme,
+		From:           from.Format(time.DateOnly),
+		To:             to.Format(time.DateOnly),
+		Report:         report,
+	}
+
+	// executing template named "accounting"
+	executeTemplate(w, "accounting", data)
+}
+
+// BIP-329 labels of the bitcoin or liquid wallet transactions
+func labelsHandler(w http.ResponseWriter, r *http.Request) {
+	wallet := r.URL.Query().Get("wallet")
+	asset := ""
+	switch wallet {
+	case "bitcoin":
+		asset = "btc"
+	case "liquid":
+		asset = "lbtc"
+	default:
+		redirectWithError(w, r, "/bitcoin?", errors.New("wallet must be bitcoin or liquid"))
+		return
+	}
+
+	labels, err := walletLabels(asset)
+	if err != nil {
+		redirectWithError(w, r, "/"+wallet+"?", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=psweb-%s-labels.jsonl", wallet))
+	if err := writeBip329(w, labels); err != nil {
+		log.Println("BIP-329 export:", err)
+	}
+}
+
+// Liquid peg-out form, progress and route costs
+func pegoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form data", http.StatusBadRequest)
+			return
+		}
+
+		amount, err := strconv.ParseInt(r.FormValue("amount"), 10, 64)
+		if err != nil {
+			redirectWithError(w, r, "/pegout?", err)
+			return
+		}
+
+		address := strings.TrimSpace(r.FormValue("address"))
+		subtractFeeFromAmount := r.FormValue("subtractfee") == "on"
+
+		if _, err := startPegout(address, amount, subtractFeeFromAmount); err != nil {
+			redirectWithError(w, r, "/pegout?amount="+fmt.Sprint(amount)+"&", err)
+			return
+		}
+
+		http.Redirect(w, r, "/pegout?msg=Peg-out transaction broadcasted", http.StatusSeeOther)
+		return
+	}
+
+	type PegoutView struct {
+		*db.Pegout
+		StageText string
+		// percent
+		Progress int
+	}
+
+	type Page struct {
+		Authenticated  bool
+		ErrorMessage   string
+		PopUpMessage   string
+		MempoolFeeRate float64
+		ColorScheme    string
+		LiquidBalance  uint64
+		Amount         int64
+		Address        string
+		Comparison     *PegoutComparison
+		Pegouts        []*PegoutView
+		// reason the form is disabled
+		PegoutDisabled string
+		BitcoinApi     string
+		LiquidApi      string
+	}
+
+	views := []*PegoutView{}
+	for _, p := range activePegouts() {
+		v := &PegoutView{
+			Pegout:    p,
+			StageText: pegoutStageText(p),
+		}
+		switch pegoutStage(p) {
+		case "payout":
+			v.Progress = 33
+		case "confirming":
+			v.Progress = 67
+		}
+		views = append(views, v)
+	}
+
+	data := Page{
+		Authenticated:  config.Config.SecureConnection && config.Config.Password != "",
+		ErrorMessage:   r.URL.Query().Get("err"),
+		PopUpMessage:   r.URL.Query().Get("msg"),
+		MempoolFeeRate: liquid.EstimateFee(),
+		ColorScheme:    config.Config.ColorScheme,
+		LiquidBalance:  getUnlockedLbtcBalance(),
+		Address:        r.URL.Query().Get("address"),
+		Pegouts:        views,
+		PegoutDisabled: pegoutDisabled(),
+		BitcoinApi:     config.Config.BitcoinApi,
+		LiquidApi:      config.Config.LiquidApi,
+	}
+
+	if amount, err := strconv.ParseInt(r.URL.Query().Get("amount"), 10, 64); err == nil && amount > 0 {
+		data.Amount = amount
+		data.Comparison, err = comparePegout(amount)
+		if err != nil {
+			data.ErrorMessage = err.Error()
+		}
+	}
+
+	// executing template named "pegout"
+	executeTemplate(w, "pegout", data)
+}
+
 func submitHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		// Parse the form data
@@ -2061,9 +2950,17 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 			return
 
 		case "externalPeginTxId":
+			peginMutex.Lock()
+			defer peginMutex.Unlock()
+
+			pegin, err := pendingPeginFromForm(r)
+			if err != nil {
+				redirectWithError(w, r, "/bitcoin?", err)
+				return
+			}
+
 			if r.FormValue("externalPeginCancel") != "" {
-				config.Config.PeginTxId = ""
-				config.Config.PeginClaimJoin = false
+				err = cancelPegin(pegin)
 			} else {
 				txid := r.FormValue("peginTxId")
 				if txid == "" {
@@ -2072,36 +2969,34 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 				}
 
 				// find the funding output
-				var tx bitcoin.Transaction
-				_, err := bitcoin.GetRawTransaction(txid, &tx)
+				var amount int64
+				amount, err = peginOutputAmount(txid, pegin.Address)
 				if err != nil {
 					redirectWithError(w, r, "/bitcoin?", err)
 					return
 				}
 
-				found := false
-				for _, out := range tx.Vout {
-					if out.ScriptPubKey.Address == config.Config.PeginAddress {
-						found = true
-						config.Config.PeginAmount = int64(toSats(out.Value))
-						break
-					}
+				if pegin.TxId != "external" && pegin.TxId != "" && pegin.TxId != txid {
+					// fee bumped externally
+					pegin.ReplacedTxIds = append(pegin.ReplacedTxIds, pegin.TxId)
 				}
 
-				if !found {
-					redirectWithError(w, r, "/bitcoin?", errors.New("the tx fails to pay the pegin address"))
-					return
+				pegin.Amount = amount
+				pegin.TxId = txid
+				pegin.FeeRate = 0
+				if pegin.ClaimJoin && ln.ClaimPeginId == 0 && ln.MyRole == "none" {
+					ln.ClaimStatus = "Awaiting funding tx to confirm"
 				}
 
-				config.Config.PeginTxId = txid
-				config.Config.PeginFeeRate = 0
-				ln.ClaimStatus = "Awaiting funding tx to confirm"
-
 				log.Println("External Funding TxId:", txid)
-				telegramSendMessage("⏰ Started peg in " + formatWithThousandSeparators(uint64(config.Config.PeginAmount)) + " sats. External funding TxId: `" + txid + "`")
+				telegramSendMessage("⏰ Started peg in " + formatWithThousandSeparators(uint64(amount)) + " sats. External funding TxId: `" + txid + "`")
+				err = db.SavePegin(pegin)
 			}
 
-			config.Save()
+			if err != nil {
+				redirectWithError(w, r, "/bitcoin?", err)
+				return
+			}
 
 			// all done, display tx confirmations
 			http.Redirect(w, r, "/bitcoin", http.StatusSeeOther)
@@ -2151,100 +3046,55 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/bitcoin?msg="+msg, http.StatusSeeOther)
 			return
 
-		case "deleteTxId":
-			// acknowledges BTC withdrawal
-			config.Config.PeginTxId = ""
-			if err := config.Save(); err != nil {
-				redirectWithError(w, r, "/bitcoin?", err)
-				return
-			}
-
-			// all done
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
-		case "saveAutoFee":
-			channelId, err := strconv.ParseUint(r.FormValue("channelId"), 10, 64)
-			if err != nil {
-				redirectWithError(w, r, "/af?", err)
-				return
-			}
-
-			var newRule ln.AutoFeeParams
-
-			newRule.FailedBumpPPM, err = strconv.Atoi(r.FormValue("failBump"))
+		case "cancelPegout":
+			// stops following the peg-out
+			pegout, err := pendingPegoutFromForm(r)
 			if err != nil {
-				redirectWithError(w, r, "/af?", err)
+				redirectWithError(w, r, "/pegout?", err)
 				return
 			}
 
-			newRule.FailedMoveThreshold, err = strconv.Atoi(r.FormValue("failedMoveThreshold"))
-			if err != nil {
-				redirectWithError(w, r, "/af?", err)
+			pegout.Status = "cancelled"
+			if err := db.SavePegout(pegout); err != nil {
+				redirectWithError(w, r, "/pegout?", err)
 				return
 			}
 
-			newRule.LowLiqPct, err = strconv.Atoi(r.FormValue("lowLiqPct"))
-			if err != nil {
-				redirectWithError(w, r, "/af?", err)
-				return
-			}
-
-			newRule.LowLiqRate, err = strconv.Atoi(r.FormValue("lowLiqRate"))
-			if err != nil {
-				redirectWithError(w, r, "/af?", err)
-				return
-			}
-
-			newRule.NormalRate, err = strconv.Atoi(r.FormValue("normalRate"))
-			if err != nil {
-				redirectWithError(w, r, "/af?", err)
-				return
-			}
-
-			newRule.ExcessPct, err = strconv.Atoi(r.FormValue("excessPct"))
-			if err != nil {
-				redirectWithError(w, r, "/af?", err)
-				return
-			}
-
-			newRule.ExcessRate, err = strconv.Atoi(r.FormValue("excessRate"))
-			if err != nil {
-				redirectWithError(w, r, "/af?", err)
-				return
-			}
+			http.Redirect(w, r, "/pegout", http.StatusSeeOther)
+			return
+		case "deleteTxId":
+			// acknowledges BTC withdrawal
+			peginMutex.Lock()
+			defer peginMutex.Unlock()
 
-			newRule.InactivityDays, err = strconv.Atoi(r.FormValue("inactivityDays"))
+			pegin, err := pendingPeginFromForm(r)
 			if err != nil {
-				redirectWithError(w, r, "/af?", err)
+				redirectWithError(w, r, "/bitcoin?", err)
 				return
 			}
 
-			newRule.InactivityDropPPM, err = strconv.Atoi(r.FormValue("inactivityDropPPM"))
-			if err != nil {
-				redirectWithError(w, r, "/af?", err)
+			if err := cancelPegin(pegin); err != nil {
+				redirectWithError(w, r, "/bitcoin?", err)
 				return
 			}
 
-			newRule.InactivityDropPct, err = strconv.Atoi(r.FormValue("inactivityDropPct"))
+			// all done
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		case "saveAutoFee":
+			channelId, err := strconv.ParseUint(r.FormValue("channelId"), 10, 64)
 			if err != nil {
 				redirectWithError(w, r, "/af?", err)
 				return
 			}
 
-			newRule.CoolOffHours, err = strconv.Atoi(r.FormValue("coolOffHours"))
+			newRule, err := parseAutoFeeParams(r)
 			if err != nil {
 				redirectWithError(w, r, "/af?", err)
 				return
 			}
 
-			if ln.HasInboundFees() {
-				newRule.LowLiqDiscount, err = strconv.Atoi(r.FormValue("lowLiqDiscount"))
-				if err != nil {
-					redirectWithError(w, r, "/af?", err)
-					return
-				}
-			}
-			rule := &ln.AutoFeeDefaults
+			group := r.FormValue("group")
 			msg := ""
 			updateAll := false
 
@@ -2254,76 +3104,118 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 
 			if updateAll {
 				msg = "All custom rules updated:"
-				old := reflect.ValueOf(*ln.AutoFee[channelId])
+				rule, _ := ln.AutoFeeRule(channelId)
+				old := reflect.ValueOf(*rule)
 				new := reflect.ValueOf(newRule)
 
 				// find what will be updated
 				for i := 0; i < old.NumField(); i++ {
-					if old.Field(i).Int() != new.Field(i).Int() {
+					if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
 						msg += fmt.Sprintf(" %s=%v", new.Type().Field(i).Name, new.Field(i).Interface())
 					}
 				}
 
-				for _, rulePtr := range ln.AutoFee {
-					if rulePtr == nil {
-						continue
-					}
-					// Use Elem() to get the underlying struct from the pointer
-					current := reflect.ValueOf(rulePtr).Elem()
+				for id := range ln.AutoFeeOverrides {
+					params, _ := ln.AutoFeeRule(id)
+					custom := *params
+					current := reflect.ValueOf(&custom).Elem()
 
 					for i := 0; i < old.NumField(); i++ {
-						if old.Field(i).Int() != new.Field(i).Int() {
-							if current.Field(i).CanSet() {
-								current.Field(i).SetInt(new.Field(i).Int())
-							} else {
-								redirectWithError(w, r, "/af?", errors.New("unable to set the value of "+current.Type().Field(i).Name))
-								return
-							}
+						if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+							current.Field(i).Set(new.Field(i))
 						}
 					}
-				}
 
-				// persist to db
-				db.Save("AutoFees", "AutoFee", ln.AutoFee)
+					// persists only the fields that differ from the group or default
+					if err := ln.SetAutoFeeRule(id, &custom); err != nil {
+						redirectWithError(w, r, "/af?", err)
+						return
+					}
+				}
 
 			} else if r.FormValue("update_button") != "" {
-				// channelId == 0 means default rule
-				msg = "Default rule updated"
-
-				if channelId > 0 {
-					// custom rule
+				switch {
+				case channelId > 0:
 					msg = "Custom rule updated"
-					if ln.AutoFee[channelId] == nil {
-						// add new
-						ln.AutoFee[channelId] = new(ln.AutoFeeParams)
+					if _, isCustom := ln.AutoFeeRule(channelId); !isCustom {
 						msg = "Custom rule added"
 					}
-					rule = ln.AutoFee[channelId]
+					err = ln.SetAutoFeeRule(channelId, &newRule)
+					if _, isCustom := ln.AutoFeeRule(channelId); !isCustom {
+						msg = "Rule is the same as " + groupOrDefault(channelId)
+					}
+				case group != "":
+					msg = "Group " + group + " updated"
+					err = ln.SaveAutoFeeGroup(group, &newRule)
+				default:
+					// channelId == 0 means default rule
+					msg = "Default rule updated"
+					err = ln.SetAutoFeeDefaults(&newRule)
 				}
-
-				// clone the new data
-				*rule = newRule
-
-				// persist to db
-				if channelId > 0 {
-					db.Save("AutoFees", "AutoFee", ln.AutoFee)
-				} else {
-					db.Save("AutoFees", "AutoFeeDefaults", ln.AutoFeeDefaults)
+				if err != nil {
+					redirectWithError(w, r, "/af?", err)
+					return
 				}
 			} else if r.FormValue("delete_button") != "" {
-				if ln.AutoFee[channelId] != nil {
+				if group != "" && channelId == 0 {
+					if err = ln.DeleteAutoFeeGroup(group); err != nil {
+						redirectWithError(w, r, "/af?", err)
+						return
+					}
+					// members follow the default rule now
+					http.Redirect(w, r, "/af?msg=Group "+url.QueryEscape(group)+" deleted", http.StatusSeeOther)
+					return
+				}
+				if _, isCustom := ln.AutoFeeRule(channelId); isCustom {
 					// delete custom rule
-					ln.AutoFee[channelId] = nil
+					if err = ln.ResetAutoFeeRule(channelId); err != nil {
+						redirectWithError(w, r, "/af?", err)
+						return
+					}
 					msg = "Custom rule deleted"
-					// persist to db
-					db.Save("AutoFees", "AutoFee", ln.AutoFee)
 				}
 			}
 
+			if group != "" && channelId == 0 {
+				http.Redirect(w, r, "/af?group="+url.QueryEscape(group)+"&msg="+msg, http.StatusSeeOther)
+				return
+			}
+
 			// all done, display confirmation
 			http.Redirect(w, r, "/af?id="+r.FormValue("channelId")+"&msg="+msg, http.StatusSeeOther)
 			return
 
+		case "addAutoFeeGroup":
+			group := strings.TrimSpace(r.FormValue("group"))
+			if ln.AutoFeeGroups[group] != nil {
+				redirectWithError(w, r, "/af?", errors.New("group "+group+" already exists"))
+				return
+			}
+
+			// start from the default rule
+			if err := ln.SaveAutoFeeGroup(group, &ln.AutoFeeDefaults); err != nil {
+				redirectWithError(w, r, "/af?", err)
+				return
+			}
+
+			http.Redirect(w, r, "/af?group="+url.QueryEscape(group)+"&msg=Group "+url.QueryEscape(group)+" added", http.StatusSeeOther)
+			return
+
+		case "setAutoFeeGroup":
+			channelId, err := strconv.ParseUint(r.FormValue("channelId"), 10, 64)
+			if err != nil {
+				redirectWithError(w, r, "/af?", err)
+				return
+			}
+
+			if err = ln.SetAutoFeeGroup(channelId, r.FormValue("group")); err != nil {
+				redirectWithError(w, r, "/af?id="+r.FormValue("channelId")+"&", err)
+				return
+			}
+
+			http.Redirect(w, r, "/af?id="+r.FormValue("channelId")+"&msg=Channel now follows "+url.QueryEscape(groupOrDefault(channelId)), http.StatusSeeOther)
+			return
+
 		case "toggleAutoFee":
 			channelId, err := strconv.ParseInt(r.FormValue("channelId"), 10, 64)
 			if err != nil {
@@ -2355,18 +3247,18 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 				msg = "Global AutoFees "
 			} else if channelId == -1 {
 				// toggle for all channels
+				var channelIds []uint64
 				for _, peer := range res.GetPeers() {
 					for _, ch := range peer.Channels {
-						ln.AutoFeeEnabled[ch.ChannelId] = isEnabled
+						channelIds = append(channelIds, ch.ChannelId)
 					}
 				}
-				db.Save("AutoFees", "AutoFeeEnabled", ln.AutoFeeEnabled)
+				ln.SetAutoFeeEnabled(isEnabled, channelIds...)
 				msg = "All per-channel AutoFees "
 
 			} else {
 				// toggle for a single channel
-				ln.AutoFeeEnabled[uint64(channelId)] = isEnabled
-				db.Save("AutoFees", "AutoFeeEnabled", ln.AutoFeeEnabled)
+				ln.SetAutoFeeEnabled(isEnabled, uint64(channelId))
 
 			outerLoop:
 				for _, peer := range res.GetPeers() {
@@ -2389,6 +3281,21 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/af?id="+r.FormValue("nextId")+"&msg="+msg, http.StatusSeeOther)
 			return
 
+		case "toggleDryRun":
+			isEnabled := r.FormValue("enabled") == "on"
+			ln.SetAutoFeeDryRun(isEnabled)
+
+			msg := "AutoFees dry run "
+			if isEnabled {
+				msg += "Enabled, channel policies will not be changed"
+			} else {
+				msg += "Disabled"
+			}
+
+			// all done, display confirmation
+			http.Redirect(w, r, "/af?id="+r.FormValue("nextId")+"&msg="+msg, http.StatusSeeOther)
+			return
+
 		case "setFee":
 			nextPage := r.FormValue("nextPage")
 
@@ -2406,34 +3313,12 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 
 			inbound := r.FormValue("direction") == "inbound"
 
-			if inbound {
-				if !ln.HasInboundFees() {
-					// CLN and LND < 0.18 cannot set inbound fees
-					redirectWithError(w, r, nextPage, errors.New("inbound fees are not allowed by your LN backend"))
-					return
-				}
-
-				if feeRate > 0 {
-					// Only discounts are allowed for now
-					redirectWithError(w, r, nextPage, errors.New("inbound fee rate cannot be positive"))
-					return
-				}
-			} else {
-				if feeRate < 0 {
-					redirectWithError(w, r, nextPage, errors.New("outbound fee rate cannot be negative"))
-					return
-				}
-			}
-
-			oldRate, err := ln.SetFeeRate(r.FormValue("peerNodeId"), channelId, feeRate, inbound, false)
+			err = setChannelFeeRate(r.FormValue("peerNodeId"), channelId, feeRate, inbound)
 			if err != nil {
 				redirectWithError(w, r, nextPage, err)
 				return
 			}
 
-			// log change
-			ln.LogFee(channelId, oldRate, int(feeRate), inbound, true)
-
 			// all good, display confirmation
 			msg := strings.Title(r.FormValue("direction")) + " fee rate updated to " + formatSigned(feeRate)
 			http.Redirect(w, r, nextPage+"msg="+msg, http.StatusSeeOther)
@@ -2594,6 +3479,122 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/liquid?msg="+msg, http.StatusSeeOther)
 			return
 
+		case "setAutoSwapLimits":
+			maxFailures, err := strconv.ParseUint(r.FormValue("maxFailures"), 10, 64)
+			if err != nil || maxFailures == 0 {
+				redirectWithError(w, r, "/liquid?", errors.New("max failures must be a positive number"))
+				return
+			}
+
+			maxInFlight, err := strconv.ParseUint(r.FormValue("maxInFlight"), 10, 64)
+			if err != nil || maxInFlight == 0 {
+				redirectWithError(w, r, "/liquid?", errors.New("max swaps in flight must be a positive number"))
+				return
+			}
+
+			inFlightAmount, err := strconv.ParseUint(r.FormValue("inFlightAmount"), 10, 64)
+			if err != nil {
+				redirectWithError(w, r, "/liquid?", err)
+				return
+			}
+
+			budgetDay, err := strconv.ParseInt(r.FormValue("feeBudgetDay"), 10, 64)
+			if err != nil {
+				redirectWithError(w, r, "/liquid?", err)
+				return
+			}
+
+			budgetWeek, err := strconv.ParseInt(r.FormValue("feeBudgetWeek"), 10, 64)
+			if err != nil {
+				redirectWithError(w, r, "/liquid?", err)
+				return
+			}
+
+			config.Config.AutoSwapMaxFailures = maxFailures
+			config.Config.AutoSwapMaxInFlight = maxInFlight
+			config.Config.AutoSwapInFlightAmount = inFlightAmount
+			config.Config.AutoSwapFeeBudgetDay = max(budgetDay, 0)
+			config.Config.AutoSwapFeeBudgetWeek = max(budgetWeek, 0)
+
+			// Save config
+			if err := config.Save(); err != nil {
+				redirectWithError(w, r, "/liquid?", err)
+				return
+			}
+
+			// Reload liquid page with pop-up
+			http.Redirect(w, r, "/liquid?msg=Auto swap limits saved", http.StatusSeeOther)
+			return
+
+		case "setAutoSwapOut":
+			var values [6]uint64
+			for i, name := range []string{"thresholdPct", "targetPct", "hours", "thresholdPPM", "maxAmount", "dailyBudget"} {
+				values[i], err = strconv.ParseUint(r.FormValue(name), 10, 64)
+				if err != nil {
+					redirectWithError(w, r, "/liquid?", err)
+					return
+				}
+			}
+			newThresholdPct, newPct, newHours, newPPM, maxAmount, dailyBudget := values[0], values[1], values[2], values[3], values[4], values[5]
+
+			if newPct >= newThresholdPct || newThresholdPct > 100 {
+				redirectWithError(w, r, "/liquid?", errors.New("target balance must be below the threshold"))
+				return
+			}
+
+			asset := r.FormValue("asset")
+			if asset != "btc" {
+				asset = "lbtc"
+			}
+
+			nowEnabled := r.FormValue("autoSwapOutEnabled") == "on"
+			t := "Automatic swap-outs "
+			msg := ""
+
+			if nowEnabled && !config.Config.AutoSwapOutEnabled {
+				// measure the time above threshold from now on
+				resetAutoSwapOutSince()
+			}
+
+			// Log only if something changed
+			if nowEnabled && (!config.Config.AutoSwapOutEnabled ||
+				config.Config.AutoSwapOutAsset != asset ||
+				config.Config.AutoSwapOutThresholdPct != newThresholdPct ||
+				config.Config.AutoSwapOutTargetPct != newPct ||
+				config.Config.AutoSwapOutHours != newHours ||
+				config.Config.AutoSwapOutThresholdPPM != newPPM ||
+				config.Config.AutoSwapOutMaxAmount != maxAmount ||
+				config.Config.AutoSwapOutDailyBudget != dailyBudget) {
+				t += "Enabled"
+				msg = t
+				log.Println(t)
+			}
+
+			if config.Config.AutoSwapOutEnabled && !nowEnabled {
+				t += "Disabled"
+				msg = t
+				log.Println(t)
+			}
+
+			config.Config.AutoSwapOutEnabled = nowEnabled
+			config.Config.AutoSwapOutAsset = asset
+			config.Config.AutoSwapOutThresholdPct = newThresholdPct
+			config.Config.AutoSwapOutTargetPct = newPct
+			config.Config.AutoSwapOutHours = newHours
+			config.Config.AutoSwapOutThresholdPPM = newPPM
+			config.Config.AutoSwapOutMaxAmount = maxAmount
+			config.Config.AutoSwapOutDailyBudget = dailyBudget
+
+			// Save config
+			if err := config.Save(); err != nil {
+				redirectWithError(w, r, "/liquid?", err)
+				return
+			}
+
+			// Reload liquid page with pop-up
+			http.Redirect(w, r, "/liquid?msg="+msg, http.StatusSeeOther)
+			return
+
 		case "newBitcoinAddress":
 			addr, err := ln.NewAddress()
 			if err != nil {
@@ -2689,77 +3690,52 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/peer?id="+nodeId, http.StatusSeeOther)
 			return
 
-		case "doSwap":
-			nodeId := r.FormValue("nodeId")
-			swapAmount, err := strconv.ParseUint(r.FormValue("swapAmount"), 10, 64)
+		case "addApiToken":
+			scope, err := strconv.Atoi(r.FormValue("scope"))
 			if err != nil {
-				redirectWithError(w, r, "/peer?id="+nodeId+"&", err)
+				redirectWithError(w, r, "/config?", err)
 				return
 			}
 
-			channelId, err := strconv.ParseUint(r.FormValue("channelId"), 10, 64)
+			token, err := newApiToken(r.FormValue("name"), scope)
 			if err != nil {
-				redirectWithError(w, r, "/peer?id="+nodeId+"&", err)
+				redirectWithError(w, r, "/config?", err)
 				return
 			}
 
-			premiumLimit, _ := strconv.ParseInt(r.FormValue("premiumLimit"), 10, 64)
+			// render directly so that the token never appears in a URL
+			renderConfig(w, r, token)
+			return
 
-			var id string
-			asset := r.FormValue("from")
-			direction := "in"
-			if asset == "ln" {
-				asset = r.FormValue("to")
-				direction = "out"
-			}
-			if asset == "ln" || r.FormValue("from") != "ln" && r.FormValue("to") != "ln" {
-				redirectWithError(w, r, "/peer?id="+nodeId+"&", errors.New("invalid combination of assets"))
+		case "revokeApiToken":
+			if err := revokeApiToken(r.FormValue("id")); err != nil {
+				redirectWithError(w, r, "/config?", err)
 				return
 			}
 
-			switch direction {
-			case "in":
-				id, err = ps.SwapIn(client, swapAmount, channelId, asset, false, premiumLimit)
-			case "out":
-				id, err = ps.SwapOut(client, swapAmount, channelId, asset, false, premiumLimit)
+			http.Redirect(w, r, "/config", http.StatusSeeOther)
+			return
+
+		case "doSwap":
+			nodeId := r.FormValue("nodeId")
+			swapAmount, err := strconv.ParseUint(r.FormValue("swapAmount"), 10, 64)
+			if err != nil {
+				redirectWithError(w, r, "/peer?id="+nodeId+"&", err)
+				return
 			}
 
+			channelId, err := strconv.ParseUint(r.FormValue("channelId"), 10, 64)
 			if err != nil {
-				e := err.Error()
-				if e == "Request timed out" || strings.Contains(e, "rpc timeout reached") {
-					// sometimes the swap is pending anyway
-					res, er := ps.ListActiveSwaps(client)
-					if er != nil {
-						log.Println("ListActiveSwaps:", er)
-						redirectWithError(w, r, "/peer?id="+nodeId+"&", er)
-						return
-					}
-					activeSwaps := res.GetSwaps()
-					if len(activeSwaps) == 1 {
-						// follow this id
-						id = activeSwaps[0].Id
-					} else {
-						// display the original error
-						log.Println("doSwap:", err)
-						redirectWithError(w, r, "/peer?id="+nodeId+"&", err)
-						return
-					}
-				} else {
-					log.Println("doSwap:", err)
-					redirectWithError(w, r, "/peer?id="+nodeId+"&", err)
-					return
-				}
+				redirectWithError(w, r, "/peer?id="+nodeId+"&", err)
+				return
 			}
 
-			// delete peer balance information
-			if asset == "btc" {
-				if ln.BitcoinBalances != nil {
-					delete(ln.BitcoinBalances, nodeId)
-				}
-			} else {
-				if ln.LiquidBalances != nil {
-					delete(ln.LiquidBalances, nodeId)
-				}
+			premiumLimit, _ := strconv.ParseInt(r.FormValue("premiumLimit"), 10, 64)
+
+			id, err := startSwap(nodeId, channelId, swapAmount, r.FormValue("from"), r.FormValue("to"), premiumLimit)
+			if err != nil {
+				redirectWithError(w, r, "/peer?id="+nodeId+"&", err)
+				return
 			}
 
 			// Redirect to swap page to follow the swap
@@ -2931,6 +3907,7 @@ func stopHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Stop requested")
 	go func() {
 		ps.Stop()
+		db.Close()
 		os.Exit(0) // Exit the program
 	}()
 }
@@ -2970,6 +3947,21 @@ func loadingHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func backupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("state") == "true" {
+		// full PSWeb state for moving to a new host
+		fileName, err := exportState()
+		if err != nil {
+			redirectWithError(w, r, "/config?", err)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+		http.ServeFile(w, r, filepath.Join(config.Config.DataDir, fileName))
+		if err = os.Remove(filepath.Join(config.Config.DataDir, fileName)); err != nil {
+			log.Println("Error deleting zip file:", err)
+		}
+		return
+	}
+
 	// returns .bak with the name of the wallet
 	if fileName, err := liquid.BackupAndZip(); err == nil {
 		// Set the Content-Disposition header to suggest a filename
@@ -2986,6 +3978,58 @@ func backupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// restores state exported from another host
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/config", http.StatusSeeOther)
+		return
+	}
+
+	// limit upload to 100 MB
+	if err := r.ParseMultipartForm(100 << 20); err != nil {
+		redirectWithError(w, r, "/config?", err)
+		return
+	}
+
+	file, _, err := r.FormFile("archive")
+	if err != nil {
+		redirectWithError(w, r, "/config?", err)
+		return
+	}
+	defer file.Close()
+
+	upload, err := os.CreateTemp(config.Config.DataDir, "upload-*.zip")
+	if err != nil {
+		redirectWithError(w, r, "/config?", err)
+		return
+	}
+	defer os.Remove(upload.Name())
+
+	_, err = io.Copy(upload, file)
+	upload.Close()
+	if err != nil {
+		redirectWithError(w, r, "/config?", err)
+		return
+	}
+
+	cfg, err := importState(upload.Name(), r.FormValue("password"))
+	if err != nil {
+		log.Println("State import:", err)
+		redirectWithError(w, r, "/config?", err)
+		return
+	}
+
+	log.Println("State imported, restarting")
+
+	// HTTPS settings are applied by the restart screen
+	restored := *cfg
+	restored.SecureConnection = config.Config.SecureConnection
+	restored.Password = config.Config.Password
+	config.Config = restored
+
+	showRestartScreen(w, r, cfg.SecureConnection, cfg.Password, true)
+}
+
 func downloadCaHandler(w http.ResponseWriter, r *http.Request) {
 	fileName := "CA.crt"
 	// Set the Content-Disposition header to suggest a filename
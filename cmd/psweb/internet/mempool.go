@@ -103,15 +103,23 @@ func GetFeeRate() float64 {
 	return 0
 }
 
-// fetch transaction block height from mempool.space
-func GetTxHeight(txid string) int32 {
+type txStatus struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int32  `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+	BlockTime   int64  `json:"block_time"`
+}
+
+// fetch transaction status from mempool.space
+func getTxStatus(txid string) *txStatus {
+	var status txStatus
 	if config.Config.BitcoinApi != "" {
 		api := config.Config.BitcoinApi + "/api/tx/" + txid + "/status"
 		req, err := http.NewRequest("GET", api, nil)
 		if err == nil {
 			cl := GetHttpClient(config.Config.BitcoinApi != config.Config.LocalMempool)
 			if cl == nil {
-				return 0
+				return &status
 			}
 			resp, err2 := cl.Do(req)
 			if err2 == nil {
@@ -119,28 +127,25 @@ func GetTxHeight(txid string) int32 {
 				buf := new(bytes.Buffer)
 				_, _ = buf.ReadFrom(resp.Body)
 
-				// Define a struct to match the JSON structure
-				type Status struct {
-					Confirmed   bool   `json:"confirmed"`
-					BlockHeight int32  `json:"block_height"`
-					BlockHash   string `json:"block_hash"`
-					BlockTime   uint64 `json:"block_time"`
-				}
-
-				// Create an instance of the struct to store the parsed data
-				var status Status
-
 				// Unmarshal the JSON string into the struct
 				if err := json.Unmarshal(buf.Bytes(), &status); err != nil {
-					log.Println("Mempool GetTxHeight:", err)
-					return 0
+					log.Println("Mempool getTxStatus:", err)
+					return &txStatus{}
 				}
-
-				return status.BlockHeight
 			}
 		}
 	}
-	return 0
+	return &status
+}
+
+// fetch transaction block height from mempool.space
+func GetTxHeight(txid string) int32 {
+	return getTxStatus(txid).BlockHeight
+}
+
+// fetch transaction block unix time from mempool.space, 0 if unconfirmed
+func GetTxBlockTime(txid string) int64 {
+	return getTxStatus(txid).BlockTime
 }
 
 // broadcast new transaction to mempool.space
@@ -204,3 +209,54 @@ func GetBitcoinTxFee(txid string) int64 {
 	}
 	return 0
 }
+
+// find the latest transaction paying to address, returns txid and block height (0 if unconfirmed)
+func GetAddressPayment(address string) (string, int32) {
+	if config.Config.BitcoinApi != "" {
+		api := config.Config.BitcoinApi + "/api/address/" + address + "/txs"
+		req, err := http.NewRequest("GET", api, nil)
+		if err == nil {
+			cl := GetHttpClient(config.Config.BitcoinApi != config.Config.LocalMempool)
+			if cl == nil {
+				return "", 0
+			}
+			resp, err2 := cl.Do(req)
+			if err2 == nil && resp.StatusCode == http.StatusOK {
+				defer resp.Body.Close()
+				buf := new(bytes.Buffer)
+				_, _ = buf.ReadFrom(resp.Body)
+
+				// Define a struct to match the JSON structure
+				type Tx struct {
+					TxId string `json:"txid"`
+					Vout []struct {
+						Address string `json:"scriptpubkey_address"`
+					} `json:"vout"`
+					Status struct {
+						Confirmed   bool  `json:"confirmed"`
+						BlockHeight int32 `json:"block_height"`
+					} `json:"status"`
+				}
+
+				// Create an instance of the struct to store the parsed data
+				var txs []Tx
+
+				// Unmarshal the JSON string into the struct
+				if err := json.Unmarshal(buf.Bytes(), &txs); err != nil {
+					log.Println("Mempool GetAddressPayment:", err)
+					return "", 0
+				}
+
+				// newest first
+				for _, tx := range txs {
+					for _, out := range tx.Vout {
+						if out.Address == address {
+							return tx.TxId, tx.Status.BlockHeight
+						}
+					}
+				}
+			}
+		}
+	}
+	return "", 0
+}
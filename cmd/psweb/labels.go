@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sort"
+
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/ps"
+)
+
+// labels of peg-in, claim and withdrawal transactions by txid
+var txLabels = make(map[string]*db.TxLabel)
+
+func loadTxLabels() {
+	var err error
+	txLabels, err = db.LoadTxLabels()
+	if err != nil {
+		log.Println("Failed to load tx labels:", err)
+	}
+}
+
+// remembers the purpose of a wallet transaction
+func labelTx(asset, txId, label string) {
+	if txId == "" || txId == "external" {
+		return
+	}
+	if l := txLabels[txId]; l != nil && l.Label == label {
+		return
+	}
+
+	l := &db.TxLabel{
+		TxId:  txId,
+		Asset: asset,
+		Label: label,
+	}
+	txLabels[txId] = l
+	db.SaveTxLabel(l)
+}
+
+// BIP-329 record
+type Bip329Label struct {
+	Type  string `json:"type"`
+	Ref   string `json:"ref"`
+	Label string `json:"label"`
+}
+
+// "Swap-Out opening, peer Alias, id 1a2b..."
+func swapTxLabel(swapType, tx, peerId, swapId string) string {
+	name := "Swap-In"
+	if swapType == "swap-out" {
+		name = "Swap-Out"
+	}
+	return name + " " + tx + ", peer " + getNodeAlias(peerId) + ", id " + swapId
+}
+
+// labels of swap, peg-in and withdrawal transactions of the asset's wallet
+func walletLabels(asset string) ([]Bip329Label, error) {
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	res, err := ps.ListSwaps(client)
+	if err != nil {
+		return nil, err
+	}
+
+	swaps := res.GetSwaps()
+	sort.SliceStable(swaps, func(i, j int) bool {
+		return swaps[i].CreatedAt < swaps[j].CreatedAt
+	})
+
+	labels := []Bip329Label{}
+	seen := make(map[string]bool)
+
+	add := func(txId, label string) {
+		if txId == "" || seen[txId] {
+			return
+		}
+		seen[txId] = true
+		labels = append(labels, Bip329Label{
+			Type:  "tx",
+			Ref:   txId,
+			Label: label,
+		})
+	}
+
+	for _, swap := range swaps {
+		if swap.Asset != asset {
+			continue
+		}
+		add(swap.OpeningTxId, swapTxLabel(swap.Type, "opening", swap.PeerNodeId, swap.Id))
+		add(swap.ClaimTxId, swapTxLabel(swap.Type, "claim", swap.PeerNodeId, swap.Id))
+	}
+
+	// pending peg-ins and withdrawals
+	for _, p := range activePegins() {
+		if asset != "btc" || p.TxId == "external" {
+			continue
+		}
+		label := "Liquid Peg-in"
+		if !p.IsPegin() {
+			label = "BTC Withdrawal"
+		}
+		add(p.TxId, label)
+	}
+
+	stored := []*db.TxLabel{}
+	for _, l := range txLabels {
+		if l.Asset == asset {
+			stored = append(stored, l)
+		}
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].TxId < stored[j].TxId
+	})
+	for _, l := range stored {
+		add(l.TxId, l.Label)
+	}
+
+	return labels, nil
+}
+
+// one JSON record per line
+func writeBip329(w io.Writer, labels []Bip329Label) error {
+	enc := json.NewEncoder(w)
+	for _, l := range labels {
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/ln"
+	"peerswap-web/cmd/psweb/ps"
+
+	"github.com/elementsproject/peerswap/peerswaprpc"
+)
+
+// how often the revenue of swaps with open windows is refreshed
+const LEDGER_REFRESH = 10 * time.Minute
+
+var (
+	// swap P&L records by swap id, guarded by ledgerMutex
+	swapLedger  = make(map[string]*db.SwapLedgerEntry)
+	ledgerMutex sync.Mutex
+	// last refresh of the open windows, timer only
+	ledgerRefreshed time.Time
+)
+
+func loadSwapLedger() {
+	entries, err := db.LoadLedger()
+	if err != nil {
+		log.Println("Failed to load swap ledger:", err)
+	}
+
+	ledgerMutex.Lock()
+	defer ledgerMutex.Unlock()
+
+	for _, e := range entries {
+		swapLedger[e.SwapId] = e
+	}
+}
+
+// records new and finished swaps and the routing revenue of their channels
+func updateSwapLedger() {
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	res, err := ps.ListSwaps(client)
+	if err != nil {
+		return
+	}
+
+	// work on copies, the pages read the ledger meanwhile
+	byId := make(map[string]*db.SwapLedgerEntry)
+	for _, e := range sortedLedger() {
+		byId[e.SwapId] = e
+	}
+
+	changed := make(map[string]bool)
+	for _, swap := range res.GetSwaps() {
+		state := simplifySwapState(swap.State)
+		e, ok := byId[swap.Id]
+		if !ok || e.State != state {
+			byId[swap.Id] = newLedgerEntry(swap, state)
+			changed[swap.Id] = true
+		}
+	}
+
+	entries := make([]*db.SwapLedgerEntry, 0, len(byId))
+	for _, e := range byId {
+		entries = append(entries, e)
+	}
+	sortLedger(entries)
+
+	// the next successful swap on the channel closes the window
+	windowEnd := make(map[string]int64)
+	lastSwap := make(map[uint64]string)
+	for _, e := range entries {
+		if e.State != "success" {
+			continue
+		}
+		if id, ok := lastSwap[e.ChannelId]; ok {
+			windowEnd[id] = e.TimeStamp
+		}
+		lastSwap[e.ChannelId] = e.SwapId
+	}
+
+	refresh := time.Since(ledgerRefreshed) > LEDGER_REFRESH
+	if refresh {
+		ledgerRefreshed = time.Now()
+	}
+
+	for id, e := range byId {
+		if e.State != "success" || e.Final {
+			continue
+		}
+		if end, ok := windowEnd[id]; ok {
+			// fees since the next swap are credited to it
+			e.Revenue = ledgerRevenue(e, end)
+			e.Final = true
+			changed[id] = true
+		} else if refresh || changed[id] {
+			// forwarding history may be trimmed, never reduce
+			if revenue := ledgerRevenue(e, 0); revenue > e.Revenue {
+				e.Revenue = revenue
+				changed[id] = true
+			}
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	ledgerMutex.Lock()
+	defer ledgerMutex.Unlock()
+
+	for id := range changed {
+		swapLedger[id] = byId[id]
+		db.SaveLedgerEntry(byId[id])
+	}
+}
+
+func newLedgerEntry(swap *peerswaprpc.PrettyPrintSwap, state string) *db.SwapLedgerEntry {
+	e := &db.SwapLedgerEntry{}
+	if state != "pending" {
+		// on-chain fees are final
+		e = swapCostItems(swap)
+	}
+	e.SwapId = swap.Id
+	e.TimeStamp = swap.CreatedAt
+	e.Type = swap.Type
+	e.Role = swap.Role
+	e.State = state
+	e.Asset = swap.Asset
+	e.Amount = swap.Amount
+	e.ChannelId = swap.LndChanId
+	e.PeerId = swap.PeerNodeId
+	return e
+}
+
+// routing fees earned by the liquidity the swap added
+// until the unix time, zero is now
+func ledgerRevenue(e *db.SwapLedgerEntry, to int64) uint64 {
+	stats := ln.GetChannelStatsBetween(e.ChannelId, uint64(e.TimeStamp), uint64(to))
+	switch e.Type + e.Role {
+	case "swap-insender", "swap-outreceiver":
+		// local balance increased
+		return stats.FeeSat
+	}
+	return stats.AssistedFeeSat
+}
+
+// copies of the ledger entries, oldest first
+func sortedLedger() []*db.SwapLedgerEntry {
+	ledgerMutex.Lock()
+	entries := make([]*db.SwapLedgerEntry, 0, len(swapLedger))
+	for _, e := range swapLedger {
+		c := *e
+		entries = append(entries, &c)
+	}
+	ledgerMutex.Unlock()
+
+	sortLedger(entries)
+	return entries
+}
+
+func sortLedger(entries []*db.SwapLedgerEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].TimeStamp < entries[j].TimeStamp
+	})
+}
+
+// sums of the ledger grouped by peer or month
+type LedgerTotal struct {
+	// peer id or "2006-01"
+	Key string
+	// peer alias or month
+	Label   string
+	Swaps   int
+	Amount  uint64
+	Cost    int64
+	Revenue uint64
+	// revenue less cost
+	Net int64
+}
+
+// totals by key, sorted by it
+func ledgerTotals(entries []*db.SwapLedgerEntry, key func(*db.SwapLedgerEntry) (string, string)) []*LedgerTotal {
+	totals := make(map[string]*LedgerTotal)
+	for _, e := range entries {
+		k, label := key(e)
+		t, ok := totals[k]
+		if !ok {
+			t = &LedgerTotal{Key: k, Label: label}
+			totals[k] = t
+		}
+		t.Swaps++
+		if e.State == "success" {
+			t.Amount += e.Amount
+		}
+		t.Cost += e.Cost
+		t.Revenue += e.Revenue
+		t.Net = int64(t.Revenue) - t.Cost
+	}
+
+	result := make([]*LedgerTotal, 0, len(totals))
+	for _, t := range totals {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func ledgerByPeer(entries []*db.SwapLedgerEntry) []*LedgerTotal {
+	return ledgerTotals(entries, func(e *db.SwapLedgerEntry) (string, string) {
+		return e.PeerId, getNodeAlias(e.PeerId)
+	})
+}
+
+func ledgerByMonth(entries []*db.SwapLedgerEntry) []*LedgerTotal {
+	return ledgerTotals(entries, func(e *db.SwapLedgerEntry) (string, string) {
+		month := time.Unix(e.TimeStamp, 0).UTC().Format("2006-01")
+		return month, month
+	})
+}
+
+// entries created in the month "2006-01", all if empty
+func ledgerMonth(entries []*db.SwapLedgerEntry, month string) []*db.SwapLedgerEntry {
+	if month == "" {
+		return entries
+	}
+	result := []*db.SwapLedgerEntry{}
+	for _, e := range entries {
+		if time.Unix(e.TimeStamp, 0).UTC().Format("2006-01") == month {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// one row per swap for accounting software
+func writeLedgerCsv(w io.Writer, entries []*db.SwapLedgerEntry) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"Date", "Swap Id", "Type", "Role", "State", "Asset", "Amount", "Channel Id", "Peer Id", "Peer Alias", "Premium", "Rebate", "Opening Fee", "Claim Fee", "Cost", "Revenue", "Net"})
+	for _, e := range entries {
+		c.Write([]string{
+			time.Unix(e.TimeStamp, 0).UTC().Format(time.RFC3339),
+			e.SwapId,
+			e.Type,
+			e.Role,
+			e.State,
+			e.Asset,
+			fmt.Sprint(e.Amount),
+			fmt.Sprint(e.ChannelId),
+			e.PeerId,
+			getNodeAlias(e.PeerId),
+			fmt.Sprint(e.Premium),
+			fmt.Sprint(e.Rebate),
+			fmt.Sprint(e.Opening),
+			fmt.Sprint(e.Claim),
+			fmt.Sprint(e.Cost),
+			fmt.Sprint(e.Revenue),
+			fmt.Sprint(int64(e.Revenue) - e.Cost),
+		})
+	}
+	c.Flush()
+	return c.Error()
+}
@@ -279,47 +279,84 @@ func BackupAndZip() (string, error) {
 	password := config.Config.ElementsPass
 	sourceFile := filepath.Join(config.Config.ElementsDirMapped, fileName)
 
-	// Open the file
-	file, err := os.Open(sourceFile)
+	// Read the file into the byte slice
+	contents, err := os.ReadFile(sourceFile)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
 
-	// Get the file size
-	fileInfo, err := file.Stat()
+	err = ZipEncrypted(filepath.Join(config.Config.DataDir, destinationZip), password, map[string][]byte{fileName: contents})
 	if err != nil {
 		return "", err
 	}
-	fileSize := fileInfo.Size()
 
-	// Create a byte slice with the size of the file
-	contents := make([]byte, fileSize)
+	return destinationZip, nil
+}
 
-	// Read the file into the byte slice
-	_, err = io.ReadFull(file, contents)
+// ZipEncrypted writes files into an AES encrypted zip archive
+func ZipEncrypted(destination, password string, files map[string][]byte) error {
+	fzip, err := os.Create(destination)
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer fzip.Close()
 
-	fzip, err := os.Create(filepath.Join(config.Config.DataDir, destinationZip))
-	if err != nil {
-		return "", err
-	}
 	zipw := zip.NewWriter(fzip)
-	defer zipw.Close()
 
-	w, err := zipw.Encrypt(fileName, password)
-	if err != nil {
-		return "", err
+	for name, contents := range files {
+		w, err := zipw.Encrypt(name, password)
+		if err != nil {
+			zipw.Close()
+			return err
+		}
+		_, err = io.Copy(w, bytes.NewReader(contents))
+		if err != nil {
+			zipw.Close()
+			return err
+		}
+	}
+
+	// writes the central directory
+	if err = zipw.Close(); err != nil {
+		return err
 	}
-	_, err = io.Copy(w, bytes.NewReader(contents))
+	return fzip.Close()
+}
+
+// limit of the decompressed contents of an archive
+const UNZIP_MAX_SIZE = 1 << 30
+
+// UnzipEncrypted reads all files from an encrypted zip archive
+func UnzipEncrypted(source, password string) (map[string][]byte, error) {
+	zipr, err := zip.OpenReader(source)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	zipw.Flush()
+	defer zipr.Close()
 
-	return destinationZip, nil
+	files := make(map[string][]byte)
+	remaining := int64(UNZIP_MAX_SIZE)
+	for _, f := range zipr.File {
+		if f.IsEncrypted() {
+			f.SetPassword(password)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		// the header's size cannot be trusted
+		contents, err := io.ReadAll(io.LimitReader(rc, remaining+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s, wrong password? %w", f.Name, err)
+		}
+		remaining -= int64(len(contents))
+		if remaining < 0 {
+			return nil, errors.New("archive exceeds 1 GiB when decompressed")
+		}
+		files[f.Name] = contents
+	}
+	return files, nil
 }
 
 type PeginAddress struct {
@@ -371,6 +408,57 @@ func ClaimPegin(rawTx, proof, claimScript string) (string, error) {
 	return txid, nil
 }
 
+// sends L-BTC to a mainchain address through the federation, returns Liquid txid;
+// chains enforcing PAK take an empty address and a wallet set by initpegoutwallet
+func SendToMainchain(address string, amountSats uint64, subtractFeeFromAmount bool) (string, error) {
+	client := ElementsClient()
+	service := &Elements{client}
+	params := []interface{}{address, ToBitcoin(amountSats), subtractFeeFromAmount}
+	wallet := config.Config.ElementsWallet
+
+	r, err := service.client.call("sendtomainchain", params, "/wallet/"+wallet)
+	if err = handleError(err, &r); err != nil {
+		log.Printf("sendtomainchain: %v", err)
+		return "", err
+	}
+
+	txid := ""
+	err = json.Unmarshal([]byte(r.Result), &txid)
+	if err != nil {
+		log.Printf("sendtomainchain unmarshall: %v", err)
+		return "", err
+	}
+	return txid, nil
+}
+
+type WalletTransaction struct {
+	TxId          string             `json:"txid"`
+	Confirmations int32              `json:"confirmations"`
+	Fee           map[string]float64 `json:"fee"`
+	BlockTime     int64              `json:"blocktime,omitempty"`
+}
+
+// wallet transaction with confirmations, negative if conflicted
+func GetTransaction(txid string) (*WalletTransaction, error) {
+	client := ElementsClient()
+	service := &Elements{client}
+	params := []interface{}{txid}
+	wallet := config.Config.ElementsWallet
+
+	r, err := service.client.call("gettransaction", params, "/wallet/"+wallet)
+	if err = handleError(err, &r); err != nil {
+		return nil, err
+	}
+
+	var tx WalletTransaction
+	err = json.Unmarshal([]byte(r.Result), &tx)
+	if err != nil {
+		log.Printf("gettransaction unmarshall: %v", err)
+		return nil, err
+	}
+	return &tx, nil
+}
+
 func ToBitcoin(amountSats uint64) float64 {
 	return float64(amountSats) / float64(100_000_000)
 }
@@ -877,6 +965,35 @@ func GetBlockchainInfo() (*BlockchainInfo, error) {
 	return &response, nil
 }
 
+type SidechainInfo struct {
+	PeggedAsset string `json:"pegged_asset"`
+	// peg-out authorization keys, only federation members can peg out
+	EnforcePak             bool `json:"enforce_pak"`
+	PeginConfirmationDepth int  `json:"pegin_confirmation_depth"`
+}
+
+func GetSidechainInfo() (*SidechainInfo, error) {
+	client := ElementsClient()
+	service := &Elements{client}
+	params := &[]interface{}{}
+
+	r, err := service.client.call("getsidechaininfo", params, "")
+	if err = handleError(err, &r); err != nil {
+		log.Printf("GetSidechainInfo: %v", err)
+		return nil, err
+	}
+
+	var response SidechainInfo
+
+	err = json.Unmarshal([]byte(r.Result), &response)
+	if err != nil {
+		log.Printf("GetSidechainInfo unmarshall: %v", err)
+		return nil, err
+	}
+
+	return &response, nil
+}
+
 type WalletInfo struct {
 	WalletName            string      `json:"walletname"`
 	WalletVersion         int         `json:"walletversion"`
@@ -0,0 +1,241 @@
+package ln
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// forwards are cached for 6 months
+const BACKTEST_MAX_DAYS = 180
+
+// forwards of the channel since the unix time, replaced in tests
+var backtestForwards = ForwardsLog
+
+// one step of the replayed fee rate
+type BacktestPoint struct {
+	TS int64
+	// rate the rule would have set
+	PPM int
+	// rate the channel actually had
+	ActualPPM int
+	LocalPct  int
+}
+
+type BacktestResult struct {
+	ChannelId uint64
+	Days      int
+	Forwards  int
+	// fee changes the rule would have made
+	Changes int
+	// fee changes found in the log, auto or manual
+	ActualChanges int
+	Timeline      []BacktestPoint
+	// outbound forwards priced at the actual ppm rate, base fee excluded
+	ActualRevenue int64
+	// same forwards priced at the replayed rate
+	EstimatedRevenue int64
+}
+
+func (r *BacktestResult) RevenueDiff() int64 {
+	return r.EstimatedRevenue - r.ActualRevenue
+}
+
+// Backtest replays the last days of forwards through the auto fee rule.
+// Local balance is rebuilt backwards from the current one using forwards only,
+// so payments, swaps and rebalances are not accounted for.
+// Revenue estimate assumes the same forwards would have happened at the replayed rate.
+// Peer-aware modes use today's reference rate, -1 if none.
+func Backtest(channelId uint64, params *AutoFeeParams, days int, localBalance, capacity uint64, currentRate int64, peerFeeRef int) *BacktestResult {
+	days = min(max(days, 1), BACKTEST_MAX_DAYS)
+
+	result := &BacktestResult{
+		ChannelId: channelId,
+		Days:      days,
+	}
+
+	if capacity == 0 {
+		return result
+	}
+
+	now := time.Now()
+	startTS := now.AddDate(0, 0, -days).Unix()
+
+	// look further back to know the last outbound forward before the start
+	// and the flow of 30 days before it
+	lookBack := time.Unix(startTS, 0).AddDate(0, 0, -max(params.InactivityDays, 30)).Unix()
+	forwards := *backtestForwards(channelId, lookBack)
+
+	// ascending
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].TS < forwards[j].TS
+	})
+
+	// rebuild the balance at the start, going back from the current one
+	balance := int64(localBalance)
+	lastForward := int64(0)
+	first := len(forwards)
+	for i := len(forwards) - 1; i >= 0; i-- {
+		f := forwards[i]
+		if int64(f.TS) < startTS {
+			if f.ChanIdOut == channelId && lastForward == 0 {
+				lastForward = int64(f.TS)
+			}
+			continue
+		}
+		first = i
+		if f.ChanIdOut == channelId {
+			balance += int64(f.Amount)
+		} else {
+			balance -= int64(f.Amount) + int64(f.Fee)
+		}
+	}
+
+	// actual outbound rate changes in the period
+	var changes []*AutoFeeEvent
+	lastUpdate := int64(0)
+	for _, e := range AutoFeeLogSince(channelId, 0) {
+		if e.IsInbound {
+			continue
+		}
+		if e.TimeStamp < startTS {
+			lastUpdate = e.TimeStamp
+		} else {
+			changes = append(changes, e)
+		}
+	}
+	result.ActualChanges = len(changes)
+
+	actualPPM := int(currentRate)
+	if len(changes) > 0 {
+		actualPPM = changes[0].OldRate
+	}
+
+	simPPM := actualPPM
+	// before schedule adjustment
+	simBase := actualPPM
+	// for MaxChangesPerDay
+	var changeTimes []int64
+	// trailing flow for the flow-aware mode
+	var flow7d, flow30d flowWindow
+	actualRevenue := float64(0)
+	estimatedRevenue := float64(0)
+	liqPct := balancePct(balance, capacity)
+
+	result.Timeline = append(result.Timeline, BacktestPoint{
+		TS:        startTS,
+		PPM:       simPPM,
+		ActualPPM: actualPPM,
+		LocalPct:  liqPct,
+	})
+
+	// the engine runs every minute, hourly steps are enough for CoolOffHours
+	next := first
+	for ts := startTS; ts <= now.Unix(); ts += 3600 {
+		changed := false
+		for ; next < len(forwards) && int64(forwards[next].TS) <= ts; next++ {
+			f := forwards[next]
+			for len(changes) > 0 && changes[0].TimeStamp <= int64(f.TS) {
+				actualPPM = changes[0].NewRate
+				changes = changes[1:]
+				changed = true
+			}
+			if f.ChanIdOut == channelId {
+				balance -= int64(f.Amount)
+				lastForward = int64(f.TS)
+				result.Forwards++
+				// f.Fee includes the base fee the estimate knows nothing about
+				actualRevenue += float64(f.Amount) * float64(actualPPM) / 1_000_000
+				estimatedRevenue += float64(f.Amount) * float64(simPPM) / 1_000_000
+			} else {
+				balance += int64(f.Amount) + int64(f.Fee)
+			}
+		}
+
+		for len(changes) > 0 && changes[0].TimeStamp <= ts {
+			actualPPM = changes[0].NewRate
+			changes = changes[1:]
+			changed = true
+		}
+
+		liqPct = balancePct(balance, capacity)
+		t := time.Unix(ts, 0)
+		// like the engine, the base moves only with a change
+		base := autoFeeRate(params, liqPct, simBase, lastUpdate, lastForward, t)
+		base = peerFeeBounds(params, base, peerFeeRef, peerFeeRef >= 0)
+
+		adj := feeAdjust{Schedule: params.SchedulePct(t)}
+		if params.FlowMaxPct > 0 {
+			flow7d.slide(forwards, channelId, ts, 7)
+			flow30d.slide(forwards, channelId, ts, 30)
+			rate := flowRate(flow7d.out, flow7d.in, flow30d.out, flow30d.in)
+			adj.Flow = flowPct(params, rate, liqPct, capacity)
+		}
+
+		for len(changeTimes) > 0 && changeTimes[0] <= ts-86_400 {
+			changeTimes = changeTimes[1:]
+		}
+		usedUp := params.MaxChangesPerDay > 0 && len(changeTimes) >= params.MaxChangesPerDay
+		newPPM := limitRate(params, simPPM, withSchedule(base, adj.pct()), usedUp)
+
+		if newPPM != simPPM {
+			simPPM = newPPM
+			simBase = base
+			lastUpdate = ts
+			changeTimes = append(changeTimes, ts)
+			result.Changes++
+			changed = true
+		}
+
+		if changed {
+			result.Timeline = append(result.Timeline, BacktestPoint{
+				TS:        ts,
+				PPM:       simPPM,
+				ActualPPM: actualPPM,
+				LocalPct:  liqPct,
+			})
+		}
+	}
+
+	result.Timeline = append(result.Timeline, BacktestPoint{
+		TS:        now.Unix(),
+		PPM:       simPPM,
+		ActualPPM: actualPPM,
+		LocalPct:  liqPct,
+	})
+
+	result.ActualRevenue = int64(math.Round(actualRevenue))
+	result.EstimatedRevenue = int64(math.Round(estimatedRevenue))
+
+	return result
+}
+
+// local balance as % of capacity, clamped since the rebuilt balance is approximate
+func balancePct(balance int64, capacity uint64) int {
+	pct := balance * 100 / int64(capacity)
+	return int(min(max(pct, 0), 100))
+}
+
+// sums of forwards in a trailing window, moved forward in time
+type flowWindow struct {
+	head, tail int
+	out, in    float64
+}
+
+func (w *flowWindow) slide(forwards []DataPoint, channelId uint64, ts int64, days int) {
+	for ; w.head < len(forwards) && int64(forwards[w.head].TS) <= ts; w.head++ {
+		w.add(forwards[w.head], channelId, 1)
+	}
+	from := ts - int64(days)*86_400
+	for ; w.tail < w.head && int64(forwards[w.tail].TS) <= from; w.tail++ {
+		w.add(forwards[w.tail], channelId, -1)
+	}
+}
+
+func (w *flowWindow) add(f DataPoint, channelId uint64, sign float64) {
+	if f.ChanIdOut == channelId {
+		w.out += sign * float64(f.Amount)
+	} else {
+		w.in += sign * float64(f.Amount)
+	}
+}
@@ -0,0 +1,136 @@
+package ln
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBacktest(t *testing.T) {
+	const channelId = 7
+
+	rule := AutoFeeParams{
+		LowLiqPct:      10,
+		LowLiqRate:     1000,
+		ExcessPct:      75,
+		NormalRate:     300,
+		ExcessRate:     100,
+		InactivityDays: 7,
+		CoolOffHours:   24,
+	}
+	with := func(change func(p *AutoFeeParams)) *AutoFeeParams {
+		p := rule
+		change(&p)
+		return &p
+	}
+
+	now := time.Now().Unix()
+	// 1M sats out at 500 ppm plus 1 sat base fee
+	outbound := func(hoursAgo int64) DataPoint {
+		return DataPoint{TS: uint64(now - hoursAgo*3600), Amount: 1_000_000, Fee: 501, ChanIdOut: channelId}
+	}
+
+	tests := []struct {
+		name      string
+		params    *AutoFeeParams
+		days      int
+		capacity  uint64
+		forwards  []DataPoint
+		feeLog    []*AutoFeeEvent
+		want      BacktestResult
+		wantFinal int
+	}{
+		{
+			name:     "no capacity",
+			params:   &rule,
+			days:     3,
+			capacity: 0,
+			want:     BacktestResult{Days: 3},
+		},
+		{
+			name:      "rate holds, base fee excluded",
+			params:    &rule,
+			days:      3,
+			capacity:  10_000_000,
+			forwards:  []DataPoint{outbound(50), outbound(20), outbound(1)},
+			want:      BacktestResult{Days: 3, Forwards: 3, ActualRevenue: 1500, EstimatedRevenue: 1500},
+			wantFinal: 500,
+		},
+		{
+			name:     "actual rate changed",
+			params:   &rule,
+			days:     3,
+			capacity: 10_000_000,
+			forwards: []DataPoint{outbound(50), outbound(20), outbound(1)},
+			feeLog: []*AutoFeeEvent{
+				{TimeStamp: now - 30*3600, OldRate: 400, NewRate: 500},
+				{TimeStamp: now - 25*3600, OldRate: 300, NewRate: 200, IsInbound: true},
+			},
+			// the rule holds the rate it started with
+			want:      BacktestResult{Days: 3, Forwards: 3, ActualChanges: 1, ActualRevenue: 1400, EstimatedRevenue: 1200},
+			wantFinal: 400,
+		},
+		{
+			name: "inactivity drops after cool off",
+			params: with(func(p *AutoFeeParams) {
+				p.InactivityDropPct = 10
+			}),
+			days:      3,
+			capacity:  10_000_000,
+			want:      BacktestResult{Days: 3, Changes: 3},
+			wantFinal: 364,
+		},
+		{
+			name: "ceiling beats max step",
+			params: with(func(p *AutoFeeParams) {
+				p.MaxStepPPM = 50
+				p.CeilingPPM = 300
+			}),
+			days:      1,
+			capacity:  10_000_000,
+			want:      BacktestResult{Days: 1, Changes: 1},
+			wantFinal: 300,
+		},
+		{
+			name: "daily changes used up",
+			params: with(func(p *AutoFeeParams) {
+				p.InactivityDropPct = 10
+				p.CoolOffHours = 0
+				p.MaxChangesPerDay = 1
+			}),
+			days:      1,
+			capacity:  10_000_000,
+			want:      BacktestResult{Days: 1, Changes: 2},
+			wantFinal: 405,
+		},
+	}
+
+	defer func() {
+		backtestForwards = ForwardsLog
+		delete(AutoFeeLog, channelId)
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backtestForwards = func(uint64, int64) *[]DataPoint {
+				forwards := append([]DataPoint(nil), tt.forwards...)
+				return &forwards
+			}
+			AutoFeeLog[channelId] = tt.feeLog
+
+			got := Backtest(channelId, tt.params, tt.days, tt.capacity/2, tt.capacity, 500, -1)
+
+			if got.Days != tt.want.Days || got.Forwards != tt.want.Forwards ||
+				got.Changes != tt.want.Changes || got.ActualChanges != tt.want.ActualChanges ||
+				got.ActualRevenue != tt.want.ActualRevenue || got.EstimatedRevenue != tt.want.EstimatedRevenue {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+
+			if tt.capacity == 0 {
+				return
+			}
+			if final := got.Timeline[len(got.Timeline)-1].PPM; final != tt.wantFinal {
+				t.Errorf("final rate = %d, want %d", final, tt.wantFinal)
+			}
+		})
+	}
+}
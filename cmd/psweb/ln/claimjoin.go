@@ -48,6 +48,8 @@ var (
 	ClaimStatus = "No ClaimJoin peg-in is pending"
 	// none, initiator or joiner
 	MyRole = "none"
+	// peg-in record taking part in ClaimJoin, 0 if none
+	ClaimPeginId uint64
 	// array of initiator + joiners, for initiator only
 	ClaimParties []ClaimParty
 	// PSET to be blinded and signed by all parties
@@ -69,26 +71,7 @@ type Coordination struct {
 	PSET []byte
 }
 
-type ClaimParty struct {
-	// peg-in txid
-	TxId string
-	// peg-in vout
-	Vout uint
-	// peg-in claim script
-	ClaimScript string
-	// Liquid address to receive funds
-	Address string
-	// when can be claimed
-	ClaimBlockHeight uint32
-	// to be filled locally by initiator
-	RawTx      string
-	TxoutProof string
-	Amount     uint64
-	FeeShare   uint64
-	PubKey     string
-	SentCount  uint
-	SentTime   time.Time
-}
+type ClaimParty = db.ClaimParty
 
 // runs after restart, to continue if peg-in is ongoing
 func loadClaimJoinDB() {
@@ -99,11 +82,12 @@ func loadClaimJoinDB() {
 	db.Load("ClaimJoin", "ClaimStatus", &ClaimStatus)
 
 	db.Load("ClaimJoin", "MyRole", &MyRole)
-	db.Load("ClaimJoin", "keyToNodeId", &keyToNodeId)
-	db.Load("ClaimJoin", "ClaimParties", &ClaimParties)
+	db.Load("ClaimJoin", "PeginId", &ClaimPeginId)
+	keyToNodeId, _ = db.LoadClaimJoinKeys()
+	ClaimParties, _ = db.LoadClaimParties()
 
 	if MyRole != "none" {
-		if config.Config.PeginTxId == "" {
+		if p := claimPegin(); p == nil || p.Status != "pending" {
 			// was claimed already
 			resetClaimJoin()
 			return
@@ -124,7 +108,7 @@ func loadClaimJoinDB() {
 
 // runs every block
 func OnBlock(blockHeight uint32) {
-	if !config.Config.PeginClaimJoin || MyRole != "initiator" || blockHeight < ClaimBlockHeight {
+	if ClaimPeginId == 0 || MyRole != "initiator" || blockHeight < ClaimBlockHeight {
 		return
 	}
 
@@ -419,7 +403,7 @@ func Broadcast(fromNodeId string, message *Message) bool {
 	if keyToNodeId[message.Sender] == "" {
 		// store path for relaying further encrypted messages
 		keyToNodeId[message.Sender] = fromNodeId
-		db.Save("ClaimJoin", "keyToNodeId", keyToNodeId)
+		db.SaveClaimJoinKey(message.Sender, fromNodeId)
 	}
 
 	// react to received broadcast
@@ -437,7 +421,7 @@ func Broadcast(fromNodeId string, message *Message) bool {
 					Amount:    uint64(JoinBlockHeight),
 					Sender:    MyPublicKey(),
 					TimeStamp: ClaimJoinHandlerTS,
-					Payload:   []byte(config.Config.PeginTxId),
+					Payload:   []byte(ClaimParties[0].TxId),
 				})
 				return false
 			} else {
@@ -488,7 +472,7 @@ func Broadcast(fromNodeId string, message *Message) bool {
 		// only trust the message from the original handler
 		if ClaimJoinHandler == message.Sender {
 			txId := string(message.Payload)
-			if MyRole == "joiner" && txId != "" && config.Config.PeginClaimScript != "done" && len(ClaimParties) == 1 {
+			if MyRole == "joiner" && txId != "" && ClaimPeginId != 0 && len(ClaimParties) == 1 {
 				var decoded liquid.Transaction
 				var err error
 
@@ -530,8 +514,7 @@ func Broadcast(fromNodeId string, message *Message) bool {
 				if ok {
 					ClaimStatus = "ClaimJoin pegin complete! Liquid TxId: " + txId
 					// signal to telegram bot
-					config.Config.PeginTxId = txId
-					config.Config.PeginClaimScript = "done"
+					peginClaimed(txId)
 				} else {
 					ClaimStatus = "My liquid address not found in the posted transaction"
 				}
@@ -543,7 +526,7 @@ func Broadcast(fromNodeId string, message *Message) bool {
 		} else {
 			// forget the route only
 			keyToNodeId[message.Sender] = ""
-			db.Save("ClaimJoin", "keyToNodeId", keyToNodeId)
+			db.SaveClaimJoinKey(message.Sender, "")
 		}
 	}
 
@@ -622,7 +605,7 @@ func Process(message *Message, senderNodeId string) {
 		// save source key map
 		keyToNodeId[message.Sender] = senderNodeId
 		// persist to db
-		db.Save("ClaimJoin", "keyToNodeId", keyToNodeId)
+		db.SaveClaimJoinKey(message.Sender, senderNodeId)
 	}
 
 	if message.Destination == MyPublicKey() {
@@ -635,7 +618,7 @@ func Process(message *Message, senderNodeId string) {
 			}
 		}
 
-		if config.Config.PeginClaimJoin && len(ClaimParties) > 0 {
+		if ClaimPeginId != 0 && len(ClaimParties) > 0 {
 			// Decrypt the message using my private key
 			plaintext, err := eciesDecrypt(myPrivateKey, message.Payload)
 			if err != nil {
@@ -896,11 +879,11 @@ func forgetPubKey(destination string) {
 		db.Save("ClaimJoin", "ClaimJoinHandler", ClaimJoinHandler)
 	}
 	keyToNodeId[destination] = ""
-	db.Save("ClaimJoin", "keyToNodeId", keyToNodeId)
+	db.SaveClaimJoinKey(destination, "")
 }
 
 // called for claim join initiator after his pegin funding tx confirms
-func InitiateClaimJoin(claimBlockHeight uint32) bool {
+func InitiateClaimJoin(pegin *db.Pegin, claimBlockHeight uint32) bool {
 	if myPrivateKey == nil {
 		myPrivateKey = generatePrivateKey()
 		if myPrivateKey != nil {
@@ -914,16 +897,17 @@ func InitiateClaimJoin(claimBlockHeight uint32) bool {
 	// original invitation timestamp
 	ts := ClaimJoinHandlerTS
 	if MyRole == "none" && len(ClaimParties) != 1 || ClaimParties[0].PubKey != MyPublicKey() {
-		party := createClaimParty(claimBlockHeight)
+		party := createClaimParty(pegin, claimBlockHeight)
 		if party != nil {
 			// initiate array of claim parties
 			ClaimParties = nil
 			ClaimParties = append(ClaimParties, *party)
+			setClaimPegin(pegin.Id)
 			ClaimBlockHeight = claimBlockHeight
 			JoinBlockHeight = claimBlockHeight - 1
 			db.Save("ClaimJoin", "ClaimBlockHeight", ClaimBlockHeight)
 			db.Save("ClaimJoin", "JoinBlockHeight", JoinBlockHeight)
-			db.Save("ClaimJoin", "ClaimParties", ClaimParties)
+			db.SaveClaimParties(ClaimParties)
 			// new invitation timestamp
 			ts = uint64(time.Now().Unix())
 		} else {
@@ -938,7 +922,7 @@ func InitiateClaimJoin(claimBlockHeight uint32) bool {
 		Amount:    uint64(JoinBlockHeight),
 		Sender:    MyPublicKey(),
 		TimeStamp: ts,
-		Payload:   []byte(config.Config.PeginTxId),
+		Payload:   []byte(pegin.TxId),
 	}) {
 		// at least one peer received it
 		if len(ClaimParties) == 1 {
@@ -969,8 +953,7 @@ func EndClaimJoin(txId string, status string) bool {
 	if txId != "" {
 		log.Println("ClaimJoin peg-in complete! Liquid TxId:", txId)
 		// signal to telegram bot
-		config.Config.PeginTxId = txId
-		config.Config.PeginClaimScript = "done"
+		peginClaimed(txId)
 	}
 
 	resetClaimJoin()
@@ -983,22 +966,77 @@ func resetClaimJoin() {
 	JoinBlockHeight = 0
 	ClaimParties = nil
 	MyRole = "none"
+	ClaimPeginId = 0
 	ClaimJoinHandler = ""
 	ClaimStatus = "No ClaimJoin peg-in is pending"
 	keyToNodeId = make(map[string]string)
 
 	// persist to db
-	db.Save("ClaimJoin", "ClaimParties", ClaimParties)
+	db.SaveClaimParties(ClaimParties)
 	db.Save("ClaimJoin", "ClaimJoinHandler", ClaimJoinHandler)
 	db.Save("ClaimJoin", "ClaimBlockHeight", ClaimBlockHeight)
 	db.Save("ClaimJoin", "JoinBlockHeight", JoinBlockHeight)
 	db.Save("ClaimJoin", "ClaimStatus", ClaimStatus)
 	db.Save("ClaimJoin", "MyRole", MyRole)
-	db.Save("ClaimJoin", "keyToNodeId", keyToNodeId)
+	db.Save("ClaimJoin", "PeginId", ClaimPeginId)
+	db.ClearClaimJoinKeys()
+}
+
+// the peg-in record taking part in ClaimJoin, nil if none
+func claimPegin() *db.Pegin {
+	if ClaimPeginId == 0 {
+		return nil
+	}
+	p, err := db.LoadPegin(ClaimPeginId)
+	if err != nil {
+		log.Println("Cannot load ClaimJoin peg-in:", err)
+	}
+	return p
+}
+
+// marks the ClaimJoin peg-in as claimed by txId
+func peginClaimed(txId string) {
+	if ClaimPeginId == 0 {
+		return
+	}
+	// re-read under the db lock, followPegin may hold an older copy
+	err := db.UpdatePegin(ClaimPeginId, func(p *db.Pegin) {
+		if p.Status == "pending" {
+			p.ClaimTxId = txId
+			p.Status = "claimed"
+		}
+	})
+	if err != nil {
+		log.Println("Cannot save ClaimJoin peg-in:", err)
+	}
+}
+
+// takes the peg-in into ClaimJoin
+func setClaimPegin(id uint64) {
+	if ClaimPeginId != id {
+		ClaimPeginId = id
+		db.Save("ClaimJoin", "PeginId", ClaimPeginId)
+	}
+}
+
+// ForgetClaimPegin frees ClaimJoin for other peg-ins
+// when the peg-in was claimed individually or cancelled
+func ForgetClaimPegin(id uint64) {
+	if ClaimPeginId != id {
+		return
+	}
+	if MyRole != "none" {
+		resetClaimJoin()
+		return
+	}
+	ClaimPeginId = 0
+	ClaimParties = nil
+	db.Save("ClaimJoin", "PeginId", ClaimPeginId)
+	db.SaveClaimParties(ClaimParties)
 }
 
 // called for ClaimJoin joiner candidate after his pegin funding tx confirms
-func JoinClaimJoin(claimBlockHeight uint32) bool {
+func JoinClaimJoin(pegin *db.Pegin, claimBlockHeight uint32) bool {
 	if ClaimJoinHandler == "" {
 		return false
 	}
@@ -1048,15 +1086,16 @@ func JoinClaimJoin(claimBlockHeight uint32) bool {
 	if len(ClaimParties) != 1 || ClaimParties[0].PubKey != MyPublicKey() {
 		// initiate array of claim parties for single entry
 		ClaimParties = nil
-		cp := createClaimParty(claimBlockHeight)
+		cp := createClaimParty(pegin, claimBlockHeight)
 		if cp == nil {
 			// something went wrong
 			return false
 		}
 		ClaimParties = append(ClaimParties, *cp)
 		ClaimBlockHeight = claimBlockHeight
+		setClaimPegin(pegin.Id)
 		db.Save("ClaimJoin", "ClaimBlockHeight", ClaimBlockHeight)
-		db.Save("ClaimJoin", "ClaimParties", ClaimParties)
+		db.SaveClaimParties(ClaimParties)
 	}
 
 	if SendCoordination(ClaimJoinHandler, &Coordination{
@@ -1094,31 +1133,31 @@ func shareInvite(nodeId string) {
 	}
 }
 
-func createClaimParty(claimBlockHeight uint32) *ClaimParty {
+func createClaimParty(pegin *db.Pegin, claimBlockHeight uint32) *ClaimParty {
 	party := new(ClaimParty)
-	party.TxId = config.Config.PeginTxId
-	party.ClaimScript = config.Config.PeginClaimScript
+	party.TxId = pegin.TxId
+	party.ClaimScript = pegin.ClaimScript
 	party.ClaimBlockHeight = claimBlockHeight
-	party.Amount = uint64(config.Config.PeginAmount)
+	party.Amount = uint64(pegin.Amount)
 
 	var err error
-	party.RawTx, err = bitcoin.GetRawTransaction(config.Config.PeginTxId, nil)
+	party.RawTx, err = bitcoin.GetRawTransaction(pegin.TxId, nil)
 	if err != nil {
 		log.Println("Cannot create ClaimParty: GetRawTransaction:", err)
 		return nil
 	}
 
-	party.Vout, err = bitcoin.FindVout(party.RawTx, uint64(config.Config.PeginAmount))
+	party.Vout, err = bitcoin.FindVout(party.RawTx, uint64(pegin.Amount))
 	if err != nil {
 		log.Println("Cannot create ClaimParty: FindVout:", err)
 		return nil
 	}
 
-	party.TxoutProof, err = bitcoin.GetTxOutProof(config.Config.PeginTxId)
+	party.TxoutProof, err = bitcoin.GetTxOutProof(pegin.TxId)
 	if err != nil {
 		// try again
 		time.Sleep(2 * time.Second)
-		party.TxoutProof, err = bitcoin.GetTxOutProof(config.Config.PeginTxId)
+		party.TxoutProof, err = bitcoin.GetTxOutProof(pegin.TxId)
 	}
 	if err != nil {
 		log.Println("Cannot create ClaimParty: GetTxOutProof:", err)
@@ -1171,7 +1210,7 @@ func addClaimParty(newParty *ClaimParty) (bool, string) {
 	ClaimParties = append(ClaimParties, *newParty)
 
 	// persist to db
-	db.Save("ClaimJoin", "ClaimParties", ClaimParties)
+	db.SaveClaimParties(ClaimParties)
 
 	return true, "Successfully joined, total participants: " + strconv.Itoa(len(ClaimParties))
 }
@@ -1204,7 +1243,7 @@ func removeClaimParty(pubKey string) bool {
 	ClaimParties = newClaimParties
 
 	// persist to db
-	db.Save("ClaimJoin", "ClaimParties", ClaimParties)
+	db.SaveClaimParties(ClaimParties)
 
 	return true
 }
@@ -19,6 +19,8 @@ import (
 
 	"peerswap-web/cmd/psweb/bitcoin"
 	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/events"
 	"peerswap-web/cmd/psweb/safemap"
 
 	"github.com/elementsproject/glightning/glightning"
@@ -270,7 +272,7 @@ type UnreserveInputsResponse struct {
 	Reservations []Reservation `json:"reservations"`
 }
 
-func BumpPeginFee(newFeeRate float64, label string) (*SentResult, error) {
+func BumpPeginFee(pegin *db.Pegin, newFeeRate float64, label string) (*SentResult, error) {
 
 	client, clean, err := GetClient()
 	if err != nil {
@@ -279,7 +281,7 @@ func BumpPeginFee(newFeeRate float64, label string) (*SentResult, error) {
 	}
 	defer clean()
 
-	tx, err := getTransaction(client, config.Config.PeginTxId)
+	tx, err := getTransaction(client, pegin.TxId)
 	if err != nil {
 		return nil, err
 	}
@@ -324,8 +326,8 @@ func BumpPeginFee(newFeeRate float64, label string) (*SentResult, error) {
 
 	return SendCoinsWithUtxos(
 		&utxos,
-		config.Config.PeginAddress,
-		config.Config.PeginAmount,
+		pegin.Address,
+		pegin.Amount,
 		newFeeRate,
 		sendAll,
 		label)
@@ -627,6 +629,8 @@ func cacheForwards(client *glightning.Lightning) int {
 	}
 
 	totalForwards := 0
+	// do not publish the initial download
+	live := forwardsLastIndex > 0
 
 	for {
 		// get incremental history
@@ -666,6 +670,16 @@ func cacheForwards(client *glightning.Lightning) int {
 					LastForwardTS.Write(chOut, int64(f.ResolvedTime))
 					// forget last failed attempt
 					failedForwardTS.Write(chOut, 0)
+
+					if live {
+						events.Publish(events.FORWARD, &events.Forward{
+							ChannelIn:  chIn,
+							ChannelOut: chOut,
+							AmountOut:  f.OutMsat / 1000,
+							FeeMsat:    f.FeeMsat,
+							TimeStamp:  int64(f.ResolvedTime),
+						})
+					}
 				} else {
 					// catch not enough balance error
 					if f.FailCode == 4103 {
@@ -836,8 +850,56 @@ type ListHtlcsResponse struct {
 	HTLCs []HTLC `json:"htlcs"`
 }
 
-// flow stats for a channel since timestamp
-func GetChannelStats(lndChannelId uint64, timeStamp uint64) *ChannelStats {
+// channels with cached forwards, closed ones included
+func ForwardedChannels() []uint64 {
+	seen := make(map[uint64]bool)
+	collect := func(channelId uint64, _ []Forwarding) {
+		seen[channelId] = true
+	}
+	forwardsOut.Iterate(collect)
+	forwardsIn.Iterate(collect)
+
+	var result []uint64
+	for channelId := range seen {
+		result = append(result, channelId)
+	}
+	return result
+}
+
+type ListClosedChannelsRequest struct{}
+
+func (r *ListClosedChannelsRequest) Name() string {
+	return "listclosedchannels"
+}
+
+type ClosedChannel struct {
+	PeerId         string `json:"peer_id"`
+	ShortChannelId string `json:"short_channel_id"`
+}
+
+// peer node ids of closed channels by channel id
+func ClosedChannelPeers(client *glightning.Lightning) map[uint64]string {
+	result := make(map[uint64]string)
+
+	var res struct {
+		ClosedChannels []ClosedChannel `json:"closedchannels"`
+	}
+	if err := client.Request(&ListClosedChannelsRequest{}, &res); err != nil {
+		log.Println("listclosedchannels:", err)
+		return result
+	}
+
+	for _, ch := range res.ClosedChannels {
+		if ch.ShortChannelId != "" {
+			// never confirmed otherwise
+			result[ConvertClnToLndChannelId(ch.ShortChannelId)] = ch.PeerId
+		}
+	}
+	return result
+}
+
+// flow stats for a channel after from until to, zero to is now
+func GetChannelStatsBetween(lndChannelId uint64, from, to uint64) *ChannelStats {
 	var (
 		result       ChannelStats
 		amountOut    uint64
@@ -855,15 +917,18 @@ func GetChannelStats(lndChannelId uint64, timeStamp uint64) *ChannelStats {
 	}
 	defer clean()
 
-	fetchPaymentsStats(client, timeStamp, channelId, &result)
+	fetchPaymentsStats(client, from, to, channelId, &result)
 	cacheForwards(client)
 
-	timeStampF := float64(timeStamp)
+	// forwards resolve at fractions of a second
+	inPeriodF := func(t float64) bool {
+		return t > float64(from) && (to == 0 || t <= float64(to))
+	}
 
 	fo, ok := forwardsOut.Read(lndChannelId)
 	if ok {
 		for _, e := range fo {
-			if e.ResolvedTime > timeStampF && e.OutMsat >= IGNORE_FORWARDS_MSAT {
+			if inPeriodF(e.ResolvedTime) && e.OutMsat >= IGNORE_FORWARDS_MSAT {
 				amountOut += e.OutMsat
 				feeMsat += e.FeeMsat
 			}
@@ -873,7 +938,7 @@ func GetChannelStats(lndChannelId uint64, timeStamp uint64) *ChannelStats {
 	fi, ok := forwardsIn.Read(lndChannelId)
 	if ok {
 		for _, e := range fi {
-			if e.ResolvedTime > timeStampF && e.OutMsat >= IGNORE_FORWARDS_MSAT {
+			if inPeriodF(e.ResolvedTime) && e.OutMsat >= IGNORE_FORWARDS_MSAT {
 				amountIn += e.OutMsat
 				assistedMsat += e.FeeMsat
 			}
@@ -1126,7 +1191,7 @@ func DownloadAll() bool {
 			if channel.ChannelId > 0 {
 				channelId := ConvertLndToClnChannelId(channel.ChannelId)
 				// cache peerswap costs
-				fetchPaymentsStats(client, timestamp, channelId, &stats)
+				fetchPaymentsStats(client, timestamp, 0, channelId, &stats)
 			}
 		}
 	}
@@ -1174,7 +1239,7 @@ func GetInvoice(client *glightning.Lightning, request *ListInvoicesRequest) (Lis
 
 // get statistics for a channel since the timestamp
 // also caches swap rebates
-func fetchPaymentsStats(client *glightning.Lightning, timeStamp uint64, channelId string, result *ChannelStats) {
+func fetchPaymentsStats(client *glightning.Lightning, from, to uint64, channelId string, result *ChannelStats) {
 	var (
 		paidOutMsat       uint64
 		paidCostMsat      uint64
@@ -1200,7 +1265,7 @@ func fetchPaymentsStats(client *glightning.Lightning, timeStamp uint64, channelI
 
 				if len(inv.Invoices) > 0 {
 					for _, i := range inv.Invoices {
-						if i.Status == "paid" && i.PaidAt > timeStamp {
+						if i.Status == "paid" && inPeriod(i.PaidAt, from, to) {
 							amtMsat := i.MilliSatoshiReceived.MSat()
 							if !processInvoice(i.Description, int64(amtMsat)) {
 								// only account for non-peerswap related invoices
@@ -1224,7 +1289,7 @@ func fetchPaymentsStats(client *glightning.Lightning, timeStamp uint64, channelI
 					}
 
 					for _, p := range pmt.Payments {
-						if p.Status == "complete" && p.CompletedAt > timeStamp {
+						if p.Status == "complete" && inPeriod(p.CompletedAt, from, to) {
 							rebalancedInMsat += p.AmountMsat
 							rebalanceCostMsat += p.AmountSentMsat - p.AmountMsat
 						}
@@ -1246,7 +1311,7 @@ func fetchPaymentsStats(client *glightning.Lightning, timeStamp uint64, channelI
 				}
 
 				for _, p := range pmt.Payments {
-					if p.Status == "complete" && p.CompletedAt > timeStamp {
+					if p.Status == "complete" && inPeriod(p.CompletedAt, from, to) {
 						if !DecodeAndProcessInvoice(p.Bolt11, int64(htlc.AmountMsat)) {
 							// can be a rebalance out
 							if p.Destination == MyNodeId {
@@ -1407,6 +1472,45 @@ func SetHtlcSize(peerNodeId string,
 	return nil
 }
 
+type ListChannelsRequest struct {
+	Destination string `json:"destination,omitempty"`
+}
+
+func (r ListChannelsRequest) Name() string {
+	return "listchannels"
+}
+
+// fee rates of all other channels toward the node
+func fetchFeeRatesTo(nodeId string) ([]int, error) {
+	client, cleanup, err := GetClient()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var response map[string]interface{}
+	err = client.Request(&ListChannelsRequest{
+		Destination: nodeId,
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []int
+	channels, _ := response["channels"].([]interface{})
+	for _, channel := range channels {
+		channelMap := channel.(map[string]interface{})
+		if channelMap["source"] == MyNodeId || channelMap["active"] == false {
+			continue
+		}
+		if fee, ok := channelMap["fee_per_millionth"].(float64); ok {
+			rates = append(rates, int(fee))
+		}
+	}
+
+	return rates, nil
+}
+
 func HasInboundFees() bool {
 	return false
 }
@@ -1416,6 +1520,9 @@ func ApplyAutoFees() {
 		return
 	}
 
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
 	client, cleanup, err := GetClient()
 	if err != nil {
 		return
@@ -1430,6 +1537,12 @@ func ApplyAutoFees() {
 		return
 	}
 
+	// to keep discounts within other channels' rates
+	outboundRates := make(map[uint64]int64)
+	if HasInboundFees() {
+		FeeReport(client, outboundRates, make(map[uint64]int64))
+	}
+
 	// Iterate over channels to set fees
 	channels := response["channels"].([]interface{})
 	for _, channel := range channels {
@@ -1448,15 +1561,21 @@ func ApplyAutoFees() {
 			continue
 		}
 
-		params := &AutoFeeDefaults
-		if AutoFee[channelId] != nil {
-			// channel has custom parameters
-			params = AutoFee[channelId]
-		}
+		params, _ := AutoFeeRule(channelId)
 
-		oldFee := int(channelMap["fee_proportional_millionths"].(float64))
+		oldFee := engineFee(channelId, int(channelMap["fee_proportional_millionths"].(float64)), false)
 		newFee := oldFee
 		liqPct := int(channelMap["to_us_msat"].(float64) * 100 / channelMap["total_msat"].(float64))
+		adj := feeAdjust{Schedule: params.SchedulePct(time.Now())}
+
+		if updates, ok := channelMap["updates"].(map[string]interface{}); ok {
+			if local, ok := updates["local"].(map[string]interface{}); ok {
+				if maxHtlc, ok := local["htlc_maximum_msat"].(float64); ok {
+					applyMaxHtlc(channelMap["peer_id"].(string), channelId, params,
+						uint64(channelMap["to_us_msat"].(float64)/1000), uint64(maxHtlc/1000), uint64(channelMap["total_msat"].(float64)/1000))
+				}
+			}
+		}
 
 		// check 10 minutes back to be sure
 		ts, ok := LastForwardTS.Read(channelId)
@@ -1466,7 +1585,8 @@ func ApplyAutoFees() {
 
 			if liqPct <= params.LowLiqPct {
 				// bump fee
-				newFee += params.FailedBumpPPM
+				adj.Base = scheduleBase(channelId, oldFee) + params.FailedBumpPPM
+				newFee = withSchedule(adj.Base, adj.pct())
 			} else {
 				// move threshold or do nothing
 				moveLowLiqThreshold(channelId, params.FailedMoveThreshold)
@@ -1476,7 +1596,26 @@ func ApplyAutoFees() {
 
 		// if no Fail Bump
 		if newFee == oldFee {
-			newFee = calculateAutoFee(channelId, params, liqPct, oldFee)
+			// unknown until the peer sends its channel_update
+			peerRate := -1
+			if updates, ok := channelMap["updates"].(map[string]interface{}); ok {
+				if remote, ok := updates["remote"].(map[string]interface{}); ok {
+					if f, ok := remote["fee_proportional_millionths"].(float64); ok {
+						peerRate = int(f)
+					}
+				}
+			}
+			newFee, adj = calculateAutoFee(channelId, params, liqPct, uint64(channelMap["total_msat"].(float64)/1000), oldFee, channelMap["peer_id"].(string), peerRate)
+		}
+
+		// same as LND once CLN supports inbound fees
+		if HasInboundFees() {
+			inboundRate := engineFee(channelId, 0, true)
+			floor := inboundFloor(outboundRates, channelId)
+
+			if newRate, ok := autoInboundFee(params, channelId, liqPct, inboundRate, floor); ok {
+				applyFee(channelMap["peer_id"].(string), channelId, inboundRate, newRate, true, feeAdjust{})
+			}
 		}
 
 		// set the new rate
@@ -1487,11 +1626,7 @@ func ApplyAutoFees() {
 			}
 
 			peerId := channelMap["peer_id"].(string)
-			_, err = SetFeeRate(peerId, channelId, int64(newFee), false, false)
-			if err == nil && !lastFeeIsTheSame(channelId, newFee, false) {
-				// log the last change
-				LogFee(channelId, oldFee, newFee, false, false)
-			}
+			applyFee(peerId, channelId, oldFee, newFee, false, adj)
 		}
 	}
 }
@@ -5,13 +5,13 @@ import (
 	"encoding/gob"
 	"log"
 	"math"
-	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
 	"peerswap-web/cmd/psweb/config"
 	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/events"
 	"peerswap-web/cmd/psweb/safemap"
 
 	"github.com/btcsuite/btcd/chaincfg"
@@ -34,11 +34,14 @@ var (
 	MyNodeId    string
 
 	AutoFeeEnabledAll bool
+	// compute and log fees without changing channel policies
+	AutoFeeDryRun bool
 	// maps to LND channel Id
-	AutoFee         = make(map[uint64]*AutoFeeParams)
-	AutoFeeLog      = make(map[uint64][]*AutoFeeEvent)
-	AutoFeeEnabled  = make(map[uint64]bool)
-	AutoFeeDefaults = AutoFeeParams{
+	AutoFeeLog     = make(map[uint64][]*AutoFeeEvent)
+	AutoFeeEnabled = make(map[uint64]bool)
+	// simulated changes while in dry run
+	AutoFeeDryRunLog = make(map[uint64][]*AutoFeeEvent)
+	AutoFeeDefaults  = AutoFeeParams{
 		FailedBumpPPM:     10,
 		LowLiqPct:         10,
 		LowLiqRate:        1000,
@@ -50,6 +53,8 @@ var (
 		InactivityDropPct: 5,
 		CoolOffHours:      24,
 		LowLiqDiscount:    0,
+		FlowDrainDays:     7,
+		FlowFillDays:      7,
 	}
 
 	// track timestamp of the last outbound forward per channel
@@ -127,6 +132,16 @@ type ChannelStats struct {
 	RebalanceCost  uint64
 }
 
+// flow stats for a channel since timestamp
+func GetChannelStats(channelId uint64, timeStamp uint64) *ChannelStats {
+	return GetChannelStatsBetween(channelId, timeStamp, 0)
+}
+
+// true if the unix time is after from and not after to, zero to is now
+func inPeriod(t, from, to uint64) bool {
+	return t > from && (to == 0 || t <= to)
+}
+
 type ChanneInfo struct {
 	ChannelId          uint64
 	LocalBalance       uint64
@@ -159,10 +174,14 @@ type AutoFeeStatus struct {
 	Rule        string
 	AutoFee     *AutoFeeParams
 	Custom      bool
+	Group       string
 	FeeRate     int64
 	InboundRate int64
-	DaysNoFlow  int
-	Active      bool
+	// what dry run would have set
+	ProposedRate    int64
+	ProposedInbound int64
+	DaysNoFlow      int
+	Active          bool
 }
 
 type AutoFeeParams struct {
@@ -188,17 +207,36 @@ type AutoFeeParams struct {
 	InactivityDropPct int
 	// hours to wait before reducing the fee rate again
 	CoolOffHours int
-	// inbound fee (<0 = discount) when liquidity is below LowLiqPct
+	// inbound fee (<0 = discount) when liquidity is below LowLiqPct, if no InboundBands
 	LowLiqDiscount int
+	// inbound rates by local balance range, discounts when depleted
+	InboundBands []InboundBand
+	// PEER_FEE_OFF, PEER_FEE_PEER or PEER_FEE_MARKET
+	PeerFeeMode int
+	// floor at the reference rate plus this ppm, can be negative to undercut
+	PeerFeeMargin int
+	// cap at % of the reference rate, 0 = no cap
+	PeerFeeCapPct int
+	// largest ppm change in one update, 0 = no limit
+	MaxStepPPM int
+	// most automatic changes in 24 hours, 0 = no limit
+	MaxChangesPerDay int
+	// absolute bounds of the outbound rate, 0 = none
+	CeilingPPM int
+	FloorPPM   int
+	// max HTLC as % of local balance, 0 = not managed
+	MaxHtlcPct int
+	// % adjustments on top of the liquidity regime at certain hours
+	Schedule []ScheduleWindow
+	// largest % adjustment ahead of draining or filling, 0 = flow-aware mode off
+	FlowMaxPct int
+	// start raising the rate when the channel drains within these days, 0 = never
+	FlowDrainDays int
+	// start lowering the rate when the channel fills within these days, 0 = never
+	FlowFillDays int
 }
 
-type AutoFeeEvent struct {
-	TimeStamp int64
-	OldRate   int
-	NewRate   int
-	IsInbound bool
-	IsManual  bool
-}
+type AutoFeeEvent = db.AutoFeeEvent
 
 // for chart plotting and forwards log
 type DataPoint struct {
@@ -366,16 +404,18 @@ func stringIsInSlice(whatToFind string, whereToSearch []string) bool {
 	return false
 }
 
-// returns the rule and whether it is custom
+// returns the effective rule and whether the channel overrides its group or default
 func AutoFeeRule(channelId uint64) (*AutoFeeParams, bool) {
-	params := &AutoFeeDefaults
-	isCustom := false
-	if channelId > 0 && AutoFee[channelId] != nil {
-		// channel has custom parameters
-		params = AutoFee[channelId]
-		isCustom = true
+	if channelId == 0 {
+		return &AutoFeeDefaults, false
+	}
+	base, _ := AutoFeeBaseRule(channelId)
+	override := AutoFeeOverrides[channelId]
+	if len(override) == 0 {
+		return base, false
 	}
-	return params, isCustom
+	// channel has custom parameters
+	return applyOverride(base, override), true
 }
 
 // returns a string representation for the rule and whether it is not default
@@ -386,6 +426,10 @@ func AutoFeeRatesSummary(channelId uint64) (string, bool) {
 	normal := strconv.Itoa(params.NormalRate)
 	low := strconv.Itoa(params.LowLiqRate)
 	disc := strconv.Itoa(params.LowLiqDiscount)
+	if n := len(params.InboundBands); n > 0 {
+		// from depleted to excess
+		disc = strconv.Itoa(params.InboundBands[0].Rate) + ".." + strconv.Itoa(params.InboundBands[n-1].Rate)
+	}
 
 	summary := excess + "/" + normal + "/" + low
 	if HasInboundFees() {
@@ -399,51 +443,60 @@ func LoadDB() {
 	loadClaimJoinDB()
 
 	// load rebates from db
-	db.Load("Swaps", "SwapRebates", &SwapRebates)
+	SwapRebates, _ = db.LoadSwapRebates()
 
 	// load auto fees from db
 	db.Load("AutoFees", "AutoFeeEnabledAll", &AutoFeeEnabledAll)
+	db.Load("AutoFees", "AutoFeeDryRun", &AutoFeeDryRun)
 	db.Load("AutoFees", "AutoFeeEnabled", &AutoFeeEnabled)
-	db.Load("AutoFees", "AutoFee", &AutoFee)
 	db.Load("AutoFees", "AutoFeeDefaults", &AutoFeeDefaults)
+	loadAutoFeeRules()
 
 	// on or off
 	db.Load("Peers", "AdvertiseLiquidBalance", &AdvertiseLiquidBalance)
 	db.Load("Peers", "AdvertiseBitcoinBalance", &AdvertiseBitcoinBalance)
 
-	// drop non-array legacy log
-	var log map[uint64]interface{}
-	db.Load("AutoFees", "AutoFeeLog", &log)
+	// fee change history
+	AutoFeeLog, _ = db.LoadAutoFeeLog()
+	AutoFeeDryRunLog, _ = db.LoadDryRunLog()
+	MaxHtlcLog, _ = db.LoadMaxHtlcLog()
+	MaxHtlcDryRunLog, _ = db.LoadMaxHtlcDryRunLog()
+}
 
-	if len(log) == 0 {
-		return
+// returns the new rate and the adjustments it includes
+func calculateAutoFee(channelId uint64, params *AutoFeeParams, liqPct int, capacity uint64, oldFee int, peerId string, peerRate int) (int, feeAdjust) {
+	lastUpdate := int64(0)
+	lastLog := lastEngineLog(channelId, false)
+	if lastLog != nil {
+		lastUpdate = lastLog.TimeStamp
 	}
 
-	// Use reflection to determine the type
-	for _, data := range log {
-		v := reflect.ValueOf(data)
-		if v.Kind() == reflect.Slice {
-			// Type is map[uint64][]*AutoFeeEvent, load again
-			db.Load("AutoFees", "AutoFeeLog", &AutoFeeLog)
-			return
-		}
+	// zero if never forwarded
+	lastForward, _ := LastForwardTS.Read(channelId)
+
+	now := time.Now()
+	adj := feeAdjust{Schedule: params.SchedulePct(now)}
+	if params.FlowMaxPct > 0 {
+		adj.Flow = flowPct(params, statsFlowRate(GetForwardingStats(channelId)), liqPct, capacity)
 	}
+	newFee := autoFeeRate(params, liqPct, scheduleBase(channelId, oldFee), lastUpdate, lastForward, now)
+
+	ref, ok := peerFeeRef(params, peerId, peerRate)
+	newFee = peerFeeBounds(params, newFee, ref, ok)
+	adj.Base = newFee
+
+	return withSchedule(newFee, adj.pct()), adj
 }
 
-func calculateAutoFee(channelId uint64, params *AutoFeeParams, liqPct int, oldFee int) int {
+// the rule itself, independent of the live state so it can be replayed
+func autoFeeRate(params *AutoFeeParams, liqPct int, oldFee int, lastUpdate int64, lastForward int64, now time.Time) int {
 	newFee := oldFee
 	if liqPct >= params.LowLiqPct {
 		// normal or high liquidity regime, check if fee can be dropped
-		lastUpdate := int64(0)
-		lastLog := LastAutoFeeLog(channelId, false)
-		if lastLog != nil {
-			lastUpdate = lastLog.TimeStamp
-		}
-
 		// must be definitely above threshold and cool-off period passed
-		if liqPct > params.LowLiqPct && lastUpdate < time.Now().Add(-time.Duration(params.CoolOffHours)*time.Hour).Unix() {
+		if liqPct > params.LowLiqPct && lastUpdate < now.Add(-time.Duration(params.CoolOffHours)*time.Hour).Unix() {
 			// check the inactivity period
-			if ts, ok := LastForwardTS.Read(channelId); !ok || ok && ts < time.Now().AddDate(0, 0, -params.InactivityDays).Unix() {
+			if lastForward < now.AddDate(0, 0, -params.InactivityDays).Unix() {
 				// decrease the fee
 				newFee -= params.InactivityDropPPM
 				newFee = newFee * (100 - params.InactivityDropPct) / 100
@@ -476,44 +529,156 @@ func msatToSatUp(msat uint64) uint64 {
 
 // returns last log entry
 func LastAutoFeeLog(channelId uint64, isInbound bool) *AutoFeeEvent {
+	return lastLogEntry(AutoFeeLog, channelId, isInbound)
+}
+
+func lastLogEntry(feeLog map[uint64][]*AutoFeeEvent, channelId uint64, isInbound bool) *AutoFeeEvent {
 	// Loop backwards through the array
-	for i := len(AutoFeeLog[channelId]) - 1; i >= 0; i-- {
-		if AutoFeeLog[channelId][i].IsInbound == isInbound {
-			return AutoFeeLog[channelId][i]
+	for i := len(feeLog[channelId]) - 1; i >= 0; i-- {
+		if feeLog[channelId][i].IsInbound == isInbound {
+			return feeLog[channelId][i]
 		}
 	}
 	return nil
 }
 
+// last entry of the log the engine works with
+func lastEngineLog(channelId uint64, isInbound bool) *AutoFeeEvent {
+	if AutoFeeDryRun {
+		return lastLogEntry(AutoFeeDryRunLog, channelId, isInbound)
+	}
+	return lastLogEntry(AutoFeeLog, channelId, isInbound)
+}
+
+// in dry run the engine builds upon its own simulated rates
+func engineFee(channelId uint64, liveRate int, isInbound bool) int {
+	if AutoFeeDryRun {
+		if last := lastLogEntry(AutoFeeDryRunLog, channelId, isInbound); last != nil {
+			return last.NewRate
+		}
+	}
+	return liveRate
+}
+
+// ProposedFeeRate returns the rate dry run would have set
+func ProposedFeeRate(channelId uint64, liveRate int64, isInbound bool) int64 {
+	return int64(engineFee(channelId, int(liveRate), isInbound))
+}
+
+// sets the new auto fee rate, or only logs it in dry run
+func applyFee(peerId string, channelId uint64, oldFee int, newFee int, isInbound bool, adj feeAdjust) {
+	if !isInbound {
+		params, _ := AutoFeeRule(channelId)
+		usedUp := params.MaxChangesPerDay > 0 && autoChangesToday(channelId) >= params.MaxChangesPerDay
+		newFee = limitRate(params, oldFee, newFee, usedUp)
+	}
+
+	if newFee == oldFee {
+		// a policy update would be gossiped for nothing
+		return
+	}
+
+	if AutoFeeDryRun {
+		logDryRun(channelId, oldFee, newFee, isInbound, adj)
+		return
+	}
+
+	oldRate, err := SetFeeRate(peerId, channelId, int64(newFee), isInbound, false)
+	if err == nil && !lastFeeIsTheSame(channelId, newFee, isInbound) {
+		// log the last change
+		logFee(channelId, &AutoFeeEvent{
+			TimeStamp:   time.Now().Unix(),
+			OldRate:     oldRate,
+			NewRate:     newFee,
+			IsInbound:   isInbound,
+			SchedulePct: adj.Schedule,
+			FlowPct:     adj.Flow,
+			BaseRate:    adj.Base,
+		})
+	}
+}
+
+func logDryRun(channelId uint64, oldRate int, newRate int, isInbound bool, adj feeAdjust) {
+	event := &AutoFeeEvent{
+		TimeStamp:   time.Now().Unix(),
+		OldRate:     oldRate,
+		NewRate:     newRate,
+		IsInbound:   isInbound,
+		SchedulePct: adj.Schedule,
+		FlowPct:     adj.Flow,
+		BaseRate:    adj.Base,
+	}
+	AutoFeeDryRunLog[channelId] = append(AutoFeeDryRunLog[channelId], event)
+	// persist to db
+	db.AddDryRunEvent(channelId, event)
+
+	direction := "outbound"
+	if isInbound {
+		direction = "inbound"
+	}
+	log.Printf("AutoFee dry run: channel %d %s rate %d -> %d", channelId, direction, oldRate, newRate)
+}
+
+// enabling dry run starts a fresh simulation
+func SetAutoFeeDryRun(enabled bool) {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	if enabled && !AutoFeeDryRun {
+		AutoFeeDryRunLog = make(map[uint64][]*AutoFeeEvent)
+		MaxHtlcDryRunLog = make(map[uint64][]*MaxHtlcEvent)
+		db.ClearDryRunLog()
+	}
+	AutoFeeDryRun = enabled
+	db.Save("AutoFees", "AutoFeeDryRun", AutoFeeDryRun)
+}
+
+// LogFee records a change made outside of the engine
 func LogFee(channelId uint64, oldRate int, newRate int, isInbound bool, isManual bool) {
-	AutoFeeLog[channelId] = append(AutoFeeLog[channelId], &AutoFeeEvent{
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	logFee(channelId, &AutoFeeEvent{
 		TimeStamp: time.Now().Unix(),
 		OldRate:   oldRate,
 		NewRate:   newRate,
 		IsInbound: isInbound,
 		IsManual:  isManual,
 	})
+}
+
+// caller holds autoFeeMutex
+func logFee(channelId uint64, event *AutoFeeEvent) {
+	AutoFeeLog[channelId] = append(AutoFeeLog[channelId], event)
 	// persist to db
-	db.Save("AutoFees", "AutoFeeLog", AutoFeeLog)
+	db.AddAutoFeeEvent(channelId, event)
+
+	events.Publish(events.FEE, &events.Fee{
+		ChannelId: channelId,
+		OldRate:   event.OldRate,
+		NewRate:   event.NewRate,
+		IsInbound: event.IsInbound,
+		IsManual:  event.IsManual,
+	})
 }
 
+// caller holds autoFeeMutex
 func moveLowLiqThreshold(channelId uint64, bump int) {
-	if bump == 0 {
+	if bump == 0 || AutoFeeDryRun {
+		// dry run must not alter the rules
 		return
 	}
 
-	if AutoFee[channelId] == nil {
-		// add custom parameters
-		AutoFee[channelId] = new(AutoFeeParams)
-		// clone default values
-		*AutoFee[channelId] = AutoFeeDefaults
-	}
+	params, _ := AutoFeeRule(channelId)
+	rule := *params
 
 	// do not allow reaching high liquidity threshold
-	if AutoFee[channelId].LowLiqPct+bump < AutoFee[channelId].ExcessPct {
-		AutoFee[channelId].LowLiqPct += bump
-		// persist to db
-		db.Save("AutoFees", "AutoFee", AutoFee)
+	if rule.LowLiqPct+bump < rule.ExcessPct {
+		rule.LowLiqPct += bump
+		// only LowLiqPct becomes custom, the rest still follows the group or default
+		if err := setAutoFeeRule(channelId, &rule); err != nil {
+			log.Println("moveLowLiqThreshold:", err)
+		}
 	}
 
 }
@@ -560,12 +725,47 @@ func saveSwapRabate(swapId string, rebate int64) {
 	// save rebate payment
 	SwapRebates[swapId] = rebate
 	// persist to db
-	db.Save("Swaps", "SwapRebates", SwapRebates)
+	db.SaveSwapRebate(swapId, rebate)
+}
+
+// keeps the new rate within max step from the old one, or at the old one
+// when the daily changes are used up, then clamps to ceiling and floor
+func limitRate(params *AutoFeeParams, oldFee int, newFee int, usedUp bool) int {
+	if usedUp {
+		newFee = oldFee
+	} else if params.MaxStepPPM > 0 {
+		newFee = min(max(newFee, oldFee-params.MaxStepPPM), oldFee+params.MaxStepPPM)
+	}
+	// ceiling and floor are absolute, they beat the step limits
+	if params.CeilingPPM > 0 {
+		newFee = min(newFee, params.CeilingPPM)
+	}
+	if params.FloorPPM > 0 {
+		newFee = max(newFee, params.FloorPPM)
+	}
+	return newFee
+}
+
+// automatic outbound changes in the last 24 hours
+func autoChangesToday(channelId uint64) int {
+	feeLog := AutoFeeLog[channelId]
+	if AutoFeeDryRun {
+		feeLog = AutoFeeDryRunLog[channelId]
+	}
+
+	count := 0
+	since := time.Now().Add(-24 * time.Hour).Unix()
+	for i := len(feeLog) - 1; i >= 0 && feeLog[i].TimeStamp > since; i-- {
+		if !feeLog[i].IsInbound && !feeLog[i].IsManual {
+			count++
+		}
+	}
+	return count
 }
 
 // check if the last logged fee rate is the same as newFee
 func lastFeeIsTheSame(channelId uint64, newFee int, isInbound bool) bool {
-	lastFee := LastAutoFeeLog(channelId, isInbound)
+	lastFee := lastEngineLog(channelId, isInbound)
 	if lastFee != nil {
 		if newFee == lastFee.NewRate && time.Now().Unix()-lastFee.TimeStamp < 86_400 { // only care about the last 24h
 			return true
@@ -588,6 +788,6 @@ func getHarnessNetParams() *chaincfg.Params {
 		return &chaincfg.MainNetParams
 	}
 
-	log.Panicf("Chain %s is not supported!")
+	log.Panicf("Chain %s is not supported!", config.Config.Chain)
 	return nil
 }
@@ -0,0 +1,50 @@
+package ln
+
+import "testing"
+
+func TestLimitRate(t *testing.T) {
+	tests := []struct {
+		name   string
+		params AutoFeeParams
+		oldFee int
+		newFee int
+		usedUp bool
+		want   int
+	}{
+		{"no limits", AutoFeeParams{}, 500, 900, false, 900},
+		{"step up", AutoFeeParams{MaxStepPPM: 100}, 500, 900, false, 600},
+		{"step down", AutoFeeParams{MaxStepPPM: 100}, 500, 100, false, 400},
+		{"within step", AutoFeeParams{MaxStepPPM: 100}, 500, 550, false, 550},
+		{"ceiling", AutoFeeParams{CeilingPPM: 700}, 500, 900, false, 700},
+		{"floor", AutoFeeParams{FloorPPM: 200}, 500, 100, false, 200},
+		{"ceiling beats step", AutoFeeParams{MaxStepPPM: 50, CeilingPPM: 300}, 500, 450, false, 300},
+		{"floor beats step", AutoFeeParams{MaxStepPPM: 50, FloorPPM: 700}, 500, 550, false, 700},
+		{"used up keeps old rate", AutoFeeParams{MaxChangesPerDay: 2}, 500, 900, true, 500},
+		{"used up still clamped", AutoFeeParams{MaxChangesPerDay: 2, CeilingPPM: 300}, 500, 900, true, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := limitRate(&tt.params, tt.oldFee, tt.newFee, tt.usedUp); got != tt.want {
+				t.Errorf("limitRate(%d, %d) = %d, want %d", tt.oldFee, tt.newFee, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithSchedule(t *testing.T) {
+	tests := []struct {
+		fee, pct, want int
+	}{
+		{1000, 0, 1000},
+		{1000, 15, 1150},
+		{1234, 15, 1419},
+		{1000, -99, 10},
+	}
+
+	for _, tt := range tests {
+		if got := withSchedule(tt.fee, tt.pct); got != tt.want {
+			t.Errorf("withSchedule(%d, %d) = %d, want %d", tt.fee, tt.pct, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ln
+
+import (
+	"math"
+)
+
+// adjustments the engine applies on top of the liquidity regime
+type feeAdjust struct {
+	Schedule int
+	Flow     int
+	// rate the percentages apply to
+	Base int
+}
+
+// combined percentage, cannot take the rate to zero
+func (a feeAdjust) pct() int {
+	return max(a.Schedule+a.Flow, -99)
+}
+
+// net outbound sats per day, positive when draining.
+// The last 7 days weigh twice as much as the last 30.
+func flowRate(out7d, in7d, out30d, in30d float64) float64 {
+	return (2*(out7d-in7d)/7 + (out30d-in30d)/30) / 3
+}
+
+func statsFlowRate(stats *ForwardingStats) float64 {
+	return flowRate(float64(stats.AmountOut7d), float64(stats.AmountIn7d), float64(stats.AmountOut30d), float64(stats.AmountIn30d))
+}
+
+// days until local balance is exhausted (draining) or remote balance is (filling),
+// -1 if there is no net flow
+func flowDays(rate float64, liqPct int, capacity uint64) (float64, bool) {
+	local := float64(capacity) * float64(liqPct) / 100
+	switch {
+	case rate > 0:
+		return local / rate, true
+	case rate < 0:
+		return (float64(capacity) - local) / -rate, false
+	}
+	return -1, false
+}
+
+// FlowForecast returns days until the channel drains or fills at the measured pace
+func FlowForecast(channelId uint64, liqPct int, capacity uint64) (float64, bool) {
+	return flowDays(statsFlowRate(GetForwardingStats(channelId)), liqPct, capacity)
+}
+
+// raises the rate ahead of draining and lowers it ahead of filling,
+// the closer the estimate the larger the adjustment, in 5% steps to avoid constant updates
+func flowPct(params *AutoFeeParams, rate float64, liqPct int, capacity uint64) int {
+	if params.FlowMaxPct == 0 {
+		return 0
+	}
+
+	days, draining := flowDays(rate, liqPct, capacity)
+	horizon, sign := params.FlowFillDays, -1
+	if draining {
+		horizon, sign = params.FlowDrainDays, 1
+	}
+
+	if days < 0 || days >= float64(horizon) {
+		// balanced or far enough
+		return 0
+	}
+
+	pct := float64(params.FlowMaxPct) * (1 - days/float64(horizon))
+	return sign * int(math.Round(pct/5)*5)
+}
@@ -0,0 +1,151 @@
+package ln
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inbound fee rate for a range of local balance
+type InboundBand struct {
+	// local balance %, From inclusive, To exclusive except for 100
+	FromPct int
+	ToPct   int
+	// discount, zero or negative
+	Rate int
+}
+
+func (b InboundBand) contains(liqPct int) bool {
+	return liqPct >= b.FromPct && (liqPct < b.ToPct || b.ToPct == 100 && liqPct == 100)
+}
+
+// formats as "-200 0-10%"
+func (b InboundBand) String() string {
+	return fmt.Sprintf("%+d %d-%d%%", b.Rate, b.FromPct, b.ToPct)
+}
+
+// bands separated by ";" for the AF page
+func (p *AutoFeeParams) InboundBandsString() string {
+	var s []string
+	for _, b := range p.InboundBands {
+		s = append(s, b.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+// ParseInboundBands reads bands like "-200 0-10%; -50 10-25%"
+func ParseInboundBands(text string) ([]InboundBand, error) {
+	var bands []InboundBand
+
+	for _, part := range strings.FieldsFunc(text, func(r rune) bool { return r == ';' || r == '\n' }) {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || !strings.HasSuffix(fields[1], "%") {
+			return nil, fmt.Errorf("inbound band %q must be like -200 0-10%%", part)
+		}
+
+		var b InboundBand
+		var err error
+
+		if b.Rate, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, fmt.Errorf("inbound band %q: invalid rate", part)
+		}
+		if b.Rate > 0 {
+			// LND rejects surcharges without accept-positive-inbound-fees
+			return nil, fmt.Errorf("inbound band %q: only discounts are allowed", part)
+		}
+
+		from, to, ok := strings.Cut(strings.TrimSuffix(fields[1], "%"), "-")
+		if !ok {
+			return nil, fmt.Errorf("inbound band %q: range must be like 0-10%%", part)
+		}
+		b.FromPct, err = strconv.Atoi(from)
+		if err == nil {
+			b.ToPct, err = strconv.Atoi(to)
+		}
+		if err != nil || b.FromPct < 0 || b.ToPct > 100 || b.FromPct >= b.ToPct {
+			return nil, fmt.Errorf("inbound band %q: invalid range", part)
+		}
+
+		bands = append(bands, b)
+	}
+
+	sort.Slice(bands, func(i, j int) bool {
+		return bands[i].FromPct < bands[j].FromPct
+	})
+	for i := 1; i < len(bands); i++ {
+		if bands[i].FromPct < bands[i-1].ToPct {
+			return nil, fmt.Errorf("inbound bands %s and %s overlap", bands[i-1], bands[i])
+		}
+	}
+
+	return bands, nil
+}
+
+// inbound rate the rule wants at this liquidity, false to leave as is
+func inboundTarget(params *AutoFeeParams, liqPct int) (int, bool) {
+	if len(params.InboundBands) == 0 {
+		// single discount band below LowLiqPct
+		switch {
+		case liqPct < params.LowLiqPct:
+			return params.LowLiqDiscount, true
+		case liqPct > params.LowLiqPct:
+			return 0, true
+		}
+		return 0, false
+	}
+
+	for _, b := range params.InboundBands {
+		if b.contains(liqPct) {
+			return b.Rate, true
+		}
+	}
+
+	// outside of all bands
+	return 0, true
+}
+
+// lowest discount that keeps the combined rate non-negative
+// through any other channel, base fees are not accounted for
+func inboundFloor(outboundRates map[uint64]int64, channelId uint64) int {
+	lowest := int64(-1)
+	for id, rate := range outboundRates {
+		if id != channelId && (lowest < 0 || rate < lowest) {
+			lowest = rate
+		}
+	}
+	return -int(max(lowest, 0))
+}
+
+// returns the new inbound rate and whether to set it.
+// Discounts deepen right away, raising waits for CoolOffHours
+// and does not override manual changes.
+func autoInboundFee(params *AutoFeeParams, channelId uint64, liqPct int, current int, floor int) (int, bool) {
+	target, ok := inboundTarget(params, liqPct)
+	if !ok {
+		return current, false
+	}
+	// rules saved before surcharges were refused
+	target = min(max(target, floor), 0)
+
+	if target == current || lastFeeIsTheSame(channelId, target, true) {
+		return current, false
+	}
+
+	if target > current {
+		last := lastEngineLog(channelId, true)
+		if last == nil {
+			// only if not set outside of PSWeb
+			return target, current == 0
+		}
+		if last.IsManual || last.TimeStamp > time.Now().Add(-time.Duration(params.CoolOffHours)*time.Hour).Unix() {
+			return current, false
+		}
+	}
+
+	return target, true
+}
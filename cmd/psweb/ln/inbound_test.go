@@ -0,0 +1,82 @@
+package ln
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInboundBands(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    []InboundBand
+		wantErr bool
+	}{
+		{text: "", want: nil},
+		{
+			text: "-200 0-10%; -50 10-25%",
+			want: []InboundBand{{FromPct: 0, ToPct: 10, Rate: -200}, {FromPct: 10, ToPct: 25, Rate: -50}},
+		},
+		{
+			// sorted by range
+			text: "-50 10-25%\n-200 0-10%",
+			want: []InboundBand{{FromPct: 0, ToPct: 10, Rate: -200}, {FromPct: 10, ToPct: 25, Rate: -50}},
+		},
+		{
+			text: "0 50-100%",
+			want: []InboundBand{{FromPct: 50, ToPct: 100, Rate: 0}},
+		},
+		{text: "+100 0-10%", wantErr: true},
+		{text: "-10 0-10", wantErr: true},
+		{text: "-10 10%", wantErr: true},
+		{text: "-10 10-5%", wantErr: true},
+		{text: "-10 5-5%", wantErr: true},
+		{text: "-10 0-101%", wantErr: true},
+		{text: "abc 0-10%", wantErr: true},
+		{text: "-10 0-10% extra", wantErr: true},
+		{text: "-10 0-20%; -5 10-30%", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseInboundBands(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInboundTarget(t *testing.T) {
+	banded := &AutoFeeParams{InboundBands: []InboundBand{
+		{FromPct: 0, ToPct: 10, Rate: -200},
+		{FromPct: 90, ToPct: 100, Rate: -5},
+	}}
+	single := &AutoFeeParams{LowLiqPct: 10, LowLiqDiscount: -100}
+
+	tests := []struct {
+		name   string
+		params *AutoFeeParams
+		liqPct int
+		want   int
+		wantOk bool
+	}{
+		{"inside band", banded, 5, -200, true},
+		{"band end is exclusive", banded, 10, 0, true},
+		{"full channel", banded, 100, -5, true},
+		{"single band below threshold", single, 5, -100, true},
+		{"single band above threshold", single, 50, 0, true},
+		{"single band at threshold", single, 10, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := inboundTarget(tt.params, tt.liqPct)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("inboundTarget(%d) = %d, %v, want %d, %v", tt.liqPct, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
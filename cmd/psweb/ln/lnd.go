@@ -23,6 +23,7 @@ import (
 	"peerswap-web/cmd/psweb/bitcoin"
 	"peerswap-web/cmd/psweb/config"
 	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/events"
 	"peerswap-web/cmd/psweb/safemap"
 
 	"github.com/elementsproject/peerswap/peerswaprpc"
@@ -584,7 +585,7 @@ func fundPsbtSpendAll(cl walletrpc.WalletKitClient, utxoStrings *[]string, addre
 	return fundResp.FundedPsbt, nil
 }
 
-func BumpPeginFee(feeRate float64, label string) (*SentResult, error) {
+func BumpPeginFee(pegin *db.Pegin, feeRate float64, label string) (*SentResult, error) {
 
 	client, cleanup, err := GetClient()
 	if err != nil {
@@ -592,7 +593,7 @@ func BumpPeginFee(feeRate float64, label string) (*SentResult, error) {
 	}
 	defer cleanup()
 
-	tx, err := getTransaction(client, config.Config.PeginTxId)
+	tx, err := getTransaction(client, pegin.TxId)
 	if err != nil {
 		return nil, err
 	}
@@ -605,14 +606,14 @@ func BumpPeginFee(feeRate float64, label string) (*SentResult, error) {
 	cl := walletrpc.NewWalletKitClient(conn)
 
 	if !CanRBF() {
-		err = doCPFP(cl, tx.GetOutputDetails(), uint64(feeRate))
+		err = doCPFP(cl, pegin, tx.GetOutputDetails(), uint64(feeRate))
 		if err != nil {
 			return nil, err
 		} else {
 			return &SentResult{
-				TxId:       config.Config.PeginTxId,
+				TxId:       pegin.TxId,
 				RawHex:     "",
-				AmountSat:  config.Config.PeginAmount,
+				AmountSat:  pegin.Amount,
 				ExactSatVb: float64(feeRate),
 			}, nil
 		}
@@ -620,7 +621,7 @@ func BumpPeginFee(feeRate float64, label string) (*SentResult, error) {
 
 	ctx := context.Background()
 	res, err := cl.RemoveTransaction(ctx, &walletrpc.GetTransactionRequest{
-		Txid: config.Config.PeginTxId,
+		Txid: pegin.TxId,
 	})
 	if err != nil {
 		log.Println("RemoveTransaction:", err)
@@ -648,8 +649,8 @@ func BumpPeginFee(feeRate float64, label string) (*SentResult, error) {
 
 		ress, errr = SendCoinsWithUtxos(
 			&utxos,
-			config.Config.PeginAddress,
-			config.Config.PeginAmount,
+			pegin.Address,
+			pegin.Amount,
 			feeRate+extraBump,
 			len(tx.OutputDetails) == 1,
 			label)
@@ -663,7 +664,7 @@ func BumpPeginFee(feeRate float64, label string) (*SentResult, error) {
 	return ress, errr
 }
 
-func doCPFP(cl walletrpc.WalletKitClient, outputs []*lnrpc.OutputDetail, newFeeRate uint64) error {
+func doCPFP(cl walletrpc.WalletKitClient, pegin *db.Pegin, outputs []*lnrpc.OutputDetail, newFeeRate uint64) error {
 	if len(outputs) == 1 {
 		return errors.New("peg-in transaction has no change output, not possible to CPFP")
 	}
@@ -671,7 +672,7 @@ func doCPFP(cl walletrpc.WalletKitClient, outputs []*lnrpc.OutputDetail, newFeeR
 	// find change output
 	outputIndex := uint32(999) // bump will fail if output not found
 	for _, output := range outputs {
-		if output.GetAddress() != config.Config.PeginAddress {
+		if output.GetAddress() != pegin.Address {
 			outputIndex = uint32(output.OutputIndex)
 			break
 		}
@@ -680,7 +681,7 @@ func doCPFP(cl walletrpc.WalletKitClient, outputs []*lnrpc.OutputDetail, newFeeR
 	ctx := context.Background()
 	_, err := cl.BumpFee(ctx, &walletrpc.BumpFeeRequest{
 		Outpoint: &lnrpc.OutPoint{
-			TxidStr:     config.Config.PeginTxId,
+			TxidStr:     pegin.TxId,
 			OutputIndex: outputIndex,
 		},
 		SatPerVbyte: newFeeRate,
@@ -1114,6 +1115,14 @@ func subscribeForwards(ctx context.Context, client routerrpc.RouterClient) error
 							// TS for autofee
 							LastForwardTS.Write(htlc.OutgoingChannelId, int64(htlc.forwardingEvent.TimestampNs/1_000_000_000))
 
+							events.Publish(events.FORWARD, &events.Forward{
+								ChannelIn:  htlc.forwardingEvent.ChanIdIn,
+								ChannelOut: htlc.OutgoingChannelId,
+								AmountOut:  htlc.forwardingEvent.AmtOut,
+								FeeMsat:    htlc.forwardingEvent.FeeMsat,
+								TimeStamp:  int64(htlc.forwardingEvent.TimestampNs / 1_000_000_000),
+							})
+
 							// execute autofee
 							client, cleanup, err := GetClient()
 							if err != nil {
@@ -1544,8 +1553,40 @@ func GetChannelInfo(client lnrpc.LightningClient, channelId uint64, peerNodeId s
 	return info
 }
 
-// flow stats for a channel since timestamp
-func GetChannelStats(channelId uint64, timeStamp uint64) *ChannelStats {
+// channels with cached forwards, closed ones included
+func ForwardedChannels() []uint64 {
+	seen := make(map[uint64]bool)
+	collect := func(channelId uint64, _ []*lnrpc.ForwardingEvent) {
+		seen[channelId] = true
+	}
+	forwardsOut.Iterate(collect)
+	forwardsIn.Iterate(collect)
+
+	var result []uint64
+	for channelId := range seen {
+		result = append(result, channelId)
+	}
+	return result
+}
+
+// peer node ids of closed channels by channel id
+func ClosedChannelPeers(client lnrpc.LightningClient) map[uint64]string {
+	result := make(map[uint64]string)
+
+	res, err := client.ClosedChannels(context.Background(), &lnrpc.ClosedChannelsRequest{})
+	if err != nil {
+		log.Println("ClosedChannels:", err)
+		return result
+	}
+
+	for _, ch := range res.GetChannels() {
+		result[ch.ChanId] = ch.RemotePubkey
+	}
+	return result
+}
+
+// flow stats for a channel after from until to, zero to is now
+func GetChannelStatsBetween(channelId uint64, from, to uint64) *ChannelStats {
 
 	var (
 		result            ChannelStats
@@ -1561,12 +1602,16 @@ func GetChannelStats(channelId uint64, timeStamp uint64) *ChannelStats {
 		rebalanceCostMsat int64
 	)
 
-	timestampNs := timeStamp * 1_000_000_000
+	fromNs := from * 1_000_000_000
+	toNs := to * 1_000_000_000
+	inPeriodNs := func(ns uint64) bool {
+		return ns > fromNs && (to == 0 || ns <= toNs)
+	}
 
 	fo, ok := forwardsOut.Read(channelId)
 	if ok {
 		for _, e := range fo {
-			if e.TimestampNs > timestampNs && e.AmtOutMsat >= IGNORE_FORWARDS_MSAT {
+			if inPeriodNs(e.TimestampNs) && e.AmtOutMsat >= IGNORE_FORWARDS_MSAT {
 				routedOutMsat += e.AmtOutMsat
 				feeMsat += e.FeeMsat
 			}
@@ -1576,7 +1621,7 @@ func GetChannelStats(channelId uint64, timeStamp uint64) *ChannelStats {
 	fi, ok := forwardsIn.Read(channelId)
 	if ok {
 		for _, e := range fi {
-			if e.TimestampNs > timestampNs && e.AmtOutMsat >= IGNORE_FORWARDS_MSAT {
+			if inPeriodNs(e.TimestampNs) && e.AmtOutMsat >= IGNORE_FORWARDS_MSAT {
 				routedInMsat += e.AmtInMsat
 				assistedMsat += e.FeeMsat
 			}
@@ -1587,7 +1632,7 @@ func GetChannelStats(channelId uint64, timeStamp uint64) *ChannelStats {
 	if ok {
 		for i := 0; i < len(inv); i++ {
 			e := inv[i]
-			if uint64(e.AcceptTime) > timeStamp {
+			if inPeriodNs(uint64(e.AcceptTime) * 1_000_000_000) {
 				// check if it is related to a circular rebalancing
 				found := false
 				htcls, ok := rebalanceInHtlcs.Read(channelId)
@@ -1614,7 +1659,7 @@ func GetChannelStats(channelId uint64, timeStamp uint64) *ChannelStats {
 	htlcs, ok := paymentHtlcs.Read(channelId)
 	if ok {
 		for _, e := range htlcs {
-			if uint64(e.AttemptTimeNs) > timestampNs {
+			if inPeriodNs(uint64(e.AttemptTimeNs)) {
 				paidOutMsat += e.Route.TotalAmtMsat
 				costMsat += e.Route.TotalFeesMsat
 			}
@@ -1624,7 +1669,7 @@ func GetChannelStats(channelId uint64, timeStamp uint64) *ChannelStats {
 	htcls, ok := rebalanceInHtlcs.Read(channelId)
 	if ok {
 		for _, e := range htcls {
-			if uint64(e.AttemptTimeNs) > timestampNs {
+			if inPeriodNs(uint64(e.AttemptTimeNs)) {
 				rebalanceInMsat += e.Route.TotalAmtMsat - e.Route.TotalFeesMsat
 				rebalanceCostMsat += e.Route.TotalFeesMsat
 			}
@@ -1634,7 +1679,7 @@ func GetChannelStats(channelId uint64, timeStamp uint64) *ChannelStats {
 	htcls, ok = rebalanceOutHtlcs.Read(channelId)
 	if ok {
 		for _, e := range htcls {
-			if uint64(e.AttemptTimeNs) > timestampNs {
+			if inPeriodNs(uint64(e.AttemptTimeNs)) {
 				rebalanceOutMsat += e.Route.TotalAmtMsat
 			}
 		}
@@ -1919,12 +1964,18 @@ func SetFeeRate(peerNodeId string,
 		return oldRate, errors.New("rate was already set")
 	}
 
-	_, err = client.UpdateChannelPolicy(context.Background(), &req)
+	res, err := client.UpdateChannelPolicy(context.Background(), &req)
 	if err != nil {
 		log.Println("SetFeeRate:", err)
 		return oldRate, err
 	}
 
+	// rejected policies do not fail the call
+	if failed := res.GetFailedUpdates(); len(failed) > 0 {
+		log.Println("SetFeeRate:", failed[0].GetUpdateError())
+		return oldRate, errors.New(failed[0].GetUpdateError())
+	}
+
 	return oldRate, nil
 }
 
@@ -2011,16 +2062,14 @@ func SetHtlcSize(peerNodeId string,
 
 // called after individual HTLC settles or fails
 func applyAutoFee(client lnrpc.LightningClient, channelId uint64, htlcFail bool) {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
 
 	if !AutoFeeEnabledAll || !AutoFeeEnabled[channelId] {
 		return
 	}
 
-	params := &AutoFeeDefaults
-	if AutoFee[channelId] != nil {
-		// channel has custom parameters
-		params = AutoFee[channelId]
-	}
+	params, _ := AutoFeeRule(channelId)
 
 	ctx := context.Background()
 	r, err := client.GetChanInfo(ctx, &lnrpc.ChanInfoRequest{
@@ -2038,7 +2087,7 @@ func applyAutoFee(client lnrpc.LightningClient, channelId uint64, htlcFail bool)
 		peerId = r.Node1Pub
 	}
 
-	oldFee := int(policy.FeeRateMilliMsat)
+	oldFee := engineFee(channelId, int(policy.FeeRateMilliMsat), false)
 	newFee := oldFee
 
 	// get balances
@@ -2065,30 +2114,35 @@ func applyAutoFee(client lnrpc.LightningClient, channelId uint64, htlcFail bool)
 	}
 
 	liqPct := int(localBalance * 100 / r.Capacity)
+	adj := feeAdjust{Schedule: params.SchedulePct(time.Now())}
 
 	if htlcFail {
 		if liqPct < params.LowLiqPct {
 			// increase fee to help prevent further failed HTLCs
-			newFee += params.FailedBumpPPM
-
-			// bump LowLiqRate
-			if AutoFee[channelId] == nil {
-				// add custom parameters
-				AutoFee[channelId] = new(AutoFeeParams)
-				// clone default values
-				*AutoFee[channelId] = AutoFeeDefaults
+			bumpedFee := scheduleBase(channelId, oldFee) + params.FailedBumpPPM
+			adj.Base = bumpedFee
+			newFee = withSchedule(bumpedFee, adj.pct())
+
+			// bump LowLiqRate, dry run must not alter the rules
+			if !AutoFeeDryRun {
+				if params.CeilingPPM > 0 {
+					// do not keep bumping above the ceiling
+					bumpedFee = min(bumpedFee, params.CeilingPPM)
+				}
+				rule := *params
+				rule.LowLiqRate = bumpedFee
+				// only LowLiqRate becomes custom
+				if err := setAutoFeeRule(channelId, &rule); err != nil {
+					log.Println("applyAutoFee:", err)
+				}
 			}
-
-			AutoFee[channelId].LowLiqRate = newFee
-			// persist to db
-			db.Save("AutoFees", "AutoFee", AutoFee)
 		} else if liqPct > params.LowLiqPct {
 			// move threshold
 			moveLowLiqThreshold(channelId, params.FailedMoveThreshold)
 			return
 		}
 	} else {
-		newFee = calculateAutoFee(channelId, params, liqPct, oldFee)
+		newFee, adj = calculateAutoFee(channelId, params, liqPct, uint64(r.Capacity), oldFee, peerId, peerFeeRate(r))
 	}
 
 	// set the new rate
@@ -2103,13 +2157,56 @@ func applyAutoFee(client lnrpc.LightningClient, channelId uint64, htlcFail bool)
 			return
 		}
 
-		if old, err := SetFeeRate(peerId, channelId, int64(newFee), false, false); err == nil {
-			if !lastFeeIsTheSame(channelId, newFee, false) {
-				// log the last change
-				LogFee(channelId, old, newFee, false, false)
-			}
+		applyFee(peerId, channelId, oldFee, newFee, false, adj)
+	}
+}
+
+// peer's rate toward us, -1 if unknown
+func peerFeeRate(r *lnrpc.ChannelEdge) int {
+	peerPolicy := r.Node1Policy
+	if r.Node1Pub == MyNodeId {
+		peerPolicy = r.Node2Policy
+	}
+	if peerPolicy == nil {
+		return -1
+	}
+	return int(peerPolicy.FeeRateMilliMsat)
+}
+
+// fee rates of all other channels toward the node
+func fetchFeeRatesTo(nodeId string) ([]int, error) {
+	client, cleanup, err := GetClient()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	info, err := client.GetNodeInfo(context.Background(), &lnrpc.NodeInfoRequest{
+		PubKey:          nodeId,
+		IncludeChannels: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []int
+	for _, edge := range info.Channels {
+		if edge.Node1Pub == MyNodeId || edge.Node2Pub == MyNodeId {
+			// skip our own
+			continue
+		}
+		// the policy of the other end charges to reach the node
+		policy := edge.Node1Policy
+		if edge.Node1Pub == nodeId {
+			policy = edge.Node2Policy
 		}
+		if policy == nil || policy.Disabled {
+			continue
+		}
+		rates = append(rates, int(policy.FeeRateMilliMsat))
 	}
+
+	return rates, nil
 }
 
 // review all fees on timer
@@ -2119,6 +2216,9 @@ func ApplyAutoFees() {
 		return
 	}
 
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
 	client, cleanup, err := GetClient()
 	if err != nil {
 		return
@@ -2134,16 +2234,18 @@ func ApplyAutoFees() {
 		return
 	}
 
+	// to keep discounts within other channels' rates
+	outboundRates := make(map[uint64]int64)
+	if HasInboundFees() {
+		FeeReport(client, outboundRates, make(map[uint64]int64))
+	}
+
 	for _, ch := range res.Channels {
 		if !AutoFeeEnabled[ch.ChanId] {
 			continue
 		}
 
-		params := &AutoFeeDefaults
-		if AutoFee[ch.ChanId] != nil {
-			// channel has custom parameters
-			params = AutoFee[ch.ChanId]
-		}
+		params, _ := AutoFeeRule(ch.ChanId)
 
 		r, err := client.GetChanInfo(ctx, &lnrpc.ChanInfoRequest{
 			ChanId: ch.ChanId,
@@ -2160,10 +2262,12 @@ func ApplyAutoFees() {
 			peerId = r.Node1Pub
 		}
 
-		oldFee := int(policy.FeeRateMilliMsat)
+		oldFee := engineFee(ch.ChanId, int(policy.FeeRateMilliMsat), false)
 		liqPct := int((ch.LocalBalance + ch.UnsettledBalance) * 100 / r.Capacity)
 
-		newFee := calculateAutoFee(ch.ChanId, params, liqPct, oldFee)
+		applyMaxHtlc(peerId, ch.ChanId, params, uint64(ch.LocalBalance), policy.GetMaxHtlcMsat()/1000, uint64(r.Capacity))
+
+		newFee, adj := calculateAutoFee(ch.ChanId, params, liqPct, uint64(r.Capacity), oldFee, peerId, peerFeeRate(r))
 
 		// set the new rate
 		if newFee != oldFee {
@@ -2177,38 +2281,16 @@ func ApplyAutoFees() {
 				continue
 			}
 
-			_, err := SetFeeRate(peerId, ch.ChanId, int64(newFee), false, false)
-			if err == nil && !lastFeeIsTheSame(ch.ChanId, newFee, false) {
-				// log the last change
-				LogFee(ch.ChanId, oldFee, newFee, false, false)
-			}
+			applyFee(peerId, ch.ChanId, oldFee, newFee, false, adj)
 		}
 
 		// do not change inbound fee during pending HTLCs
 		if HasInboundFees() && ch.UnsettledBalance == 0 {
-			toSet := false
-			discountRate := int64(0)
-
-			if liqPct < params.LowLiqPct && policy.InboundFeeRateMilliMsat > int32(params.LowLiqDiscount) {
-				// set inbound fee discount
-				discountRate = int64(params.LowLiqDiscount)
-				toSet = true
-			} else if liqPct > params.LowLiqPct && policy.InboundFeeRateMilliMsat < 0 {
-				// remove discount unless it was set manually or CoolOffHours did not pass
-				lastFee := LastAutoFeeLog(ch.ChanId, true)
-				if lastFee != nil {
-					if !lastFee.IsManual && lastFee.TimeStamp < time.Now().Add(-time.Duration(params.CoolOffHours)*time.Hour).Unix() {
-						toSet = true
-					}
-				}
-			}
+			inboundRate := engineFee(ch.ChanId, int(policy.InboundFeeRateMilliMsat), true)
+			floor := inboundFloor(outboundRates, ch.ChanId)
 
-			if toSet && !lastFeeIsTheSame(ch.ChanId, int(discountRate), true) {
-				oldRate, err := SetFeeRate(peerId, ch.ChanId, discountRate, true, false)
-				if err == nil && !lastFeeIsTheSame(ch.ChanId, int(discountRate), true) {
-					// log the last change
-					LogFee(ch.ChanId, oldRate, int(discountRate), true, false)
-				}
+			if newRate, ok := autoInboundFee(params, ch.ChanId, liqPct, inboundRate, floor); ok {
+				applyFee(peerId, ch.ChanId, inboundRate, newRate, true, feeAdjust{})
 			}
 		}
 	}
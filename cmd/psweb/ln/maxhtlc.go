@@ -0,0 +1,91 @@
+package ln
+
+import (
+	"log"
+	"time"
+
+	"peerswap-web/cmd/psweb/db"
+)
+
+const (
+	// max HTLC is rounded down to this many sats, not to reveal the exact balance
+	MAX_HTLC_STEP = 10_000
+	// ignore changes smaller than this % of the current max HTLC
+	MAX_HTLC_MIN_CHANGE_PCT = 10
+)
+
+type MaxHtlcEvent = db.MaxHtlcEvent
+
+var (
+	// changes of max HTLC made by the auto fee engine
+	MaxHtlcLog = make(map[uint64][]*MaxHtlcEvent)
+	// simulated changes while in dry run
+	MaxHtlcDryRunLog = make(map[uint64][]*MaxHtlcEvent)
+)
+
+// max HTLC as MaxHtlcPct of local balance, 0 if the rule does not manage it
+func maxHtlcTarget(params *AutoFeeParams, localBalance uint64) uint64 {
+	if params.MaxHtlcPct == 0 {
+		return 0
+	}
+	target := localBalance * uint64(params.MaxHtlcPct) / 100
+	// cannot be zero, forwards will fail on the balance anyway
+	return max(target/MAX_HTLC_STEP*MAX_HTLC_STEP, MAX_HTLC_STEP)
+}
+
+// last change of the log the engine works with
+func lastMaxHtlcLog(channelId uint64) *MaxHtlcEvent {
+	entries := MaxHtlcLog[channelId]
+	if AutoFeeDryRun {
+		entries = MaxHtlcDryRunLog[channelId]
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[len(entries)-1]
+}
+
+// lowers max HTLC right away, raises it only after CoolOffHours
+func applyMaxHtlc(peerId string, channelId uint64, params *AutoFeeParams, localBalance, oldMax uint64, capacity uint64) {
+	newMax := min(maxHtlcTarget(params, localBalance), capacity)
+	if newMax == 0 {
+		return
+	}
+
+	last := lastMaxHtlcLog(channelId)
+	if last != nil && AutoFeeDryRun {
+		// the simulation continues from its own value
+		oldMax = last.NewMax
+	}
+
+	diff := max(newMax, oldMax) - min(newMax, oldMax)
+	if diff == 0 || diff*100 < oldMax*MAX_HTLC_MIN_CHANGE_PCT {
+		return
+	}
+
+	if newMax > oldMax && last != nil && last.TimeStamp > time.Now().Add(-time.Duration(params.CoolOffHours)*time.Hour).Unix() {
+		return
+	}
+
+	event := &MaxHtlcEvent{
+		TimeStamp: time.Now().Unix(),
+		OldMax:    oldMax,
+		NewMax:    newMax,
+	}
+
+	if AutoFeeDryRun {
+		MaxHtlcDryRunLog[channelId] = append(MaxHtlcDryRunLog[channelId], event)
+		// persist to db
+		db.AddMaxHtlcDryRunEvent(channelId, event)
+		log.Printf("AutoFee dry run: channel %d max HTLC %d -> %d", channelId, oldMax, newMax)
+		return
+	}
+
+	if SetHtlcSize(peerId, channelId, int64(newMax*1000), true) != nil {
+		return
+	}
+
+	MaxHtlcLog[channelId] = append(MaxHtlcLog[channelId], event)
+	// persist to db
+	db.AddMaxHtlcEvent(channelId, event)
+}
@@ -0,0 +1,98 @@
+package ln
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"peerswap-web/cmd/psweb/safemap"
+)
+
+// AutoFeeParams.PeerFeeMode
+const (
+	PEER_FEE_OFF = iota
+	// relative to the peer's rate toward us
+	PEER_FEE_PEER
+	// relative to the median rate other channels charge to the peer
+	PEER_FEE_MARKET
+)
+
+var PeerFeeModes = []string{"Off", "Peer's rate", "Market median"}
+
+type marketRate struct {
+	PPM       int
+	TimeStamp int64
+}
+
+// median rates to reach a node, refreshed hourly
+var marketRates = safemap.New[string, marketRate]()
+
+// reference rate for the peer-aware mode, false if off or unknown
+func peerFeeRef(params *AutoFeeParams, peerId string, peerRate int) (int, bool) {
+	switch params.PeerFeeMode {
+	case PEER_FEE_PEER:
+		return peerRate, peerRate >= 0
+	case PEER_FEE_MARKET:
+		return MarketFeeRate(peerId)
+	}
+	return 0, false
+}
+
+// PeerFeeRef is peerFeeRef for the backtester, -1 if off or unknown
+func PeerFeeRef(params *AutoFeeParams, peerId string, peerRate int64) int {
+	if ref, ok := peerFeeRef(params, peerId, int(peerRate)); ok {
+		return ref
+	}
+	return -1
+}
+
+// MarketFeeRate returns median fee rate other channels charge to reach the node
+func MarketFeeRate(nodeId string) (int, bool) {
+	if m, ok := marketRates.Read(nodeId); ok && m.TimeStamp > time.Now().Add(-time.Hour).Unix() {
+		return m.PPM, m.PPM >= 0
+	}
+
+	m := marketRate{
+		PPM:       -1,
+		TimeStamp: time.Now().Unix(),
+	}
+
+	rates, err := fetchFeeRatesTo(nodeId)
+	if err != nil {
+		// do not retry until the next refresh
+		log.Println("MarketFeeRate:", err)
+	} else {
+		m.PPM = median(rates)
+	}
+	marketRates.Write(nodeId, m)
+
+	return m.PPM, m.PPM >= 0
+}
+
+// -1 for empty
+func median(values []int) int {
+	if len(values) == 0 {
+		return -1
+	}
+	sort.Ints(values)
+	n := len(values)
+	if n%2 == 0 {
+		return (values[n/2-1] + values[n/2]) / 2
+	}
+	return values[n/2]
+}
+
+// floor and cap relative to the reference rate
+func peerFeeBounds(params *AutoFeeParams, fee int, ref int, ok bool) int {
+	if !ok {
+		return fee
+	}
+
+	fee = max(fee, ref+params.PeerFeeMargin)
+	if params.PeerFeeCapPct > 0 {
+		// cap wins if it is below the floor
+		fee = min(fee, ref*params.PeerFeeCapPct/100)
+	}
+
+	return max(fee, 0)
+}
@@ -0,0 +1,389 @@
+package ln
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"peerswap-web/cmd/psweb/db"
+)
+
+// fields of AutoFeeParams that differ from the channel's group or default rule
+type AutoFeeOverride map[string]json.RawMessage
+
+var (
+	// named rule groups, channels outside of a group use AutoFeeDefaults
+	AutoFeeGroups = make(map[string]*AutoFeeParams)
+	// group name per channel
+	AutoFeeGroupOf = make(map[uint64]string)
+	// per-channel changes on top of the group or default rule
+	AutoFeeOverrides = make(map[uint64]AutoFeeOverride)
+	// held by the engine while it applies fees, guards the rules
+	// and AutoFeeEnabled against the pages and the API
+	autoFeeMutex sync.Mutex
+)
+
+func loadAutoFeeRules() {
+	db.Load("AutoFees", "AutoFeeGroups", &AutoFeeGroups)
+	db.Load("AutoFees", "AutoFeeGroupOf", &AutoFeeGroupOf)
+	db.Load("AutoFees", "AutoFeeOverrides", &AutoFeeOverrides)
+
+	// the migration only knew the defaults saved to db,
+	// drop fields equal to the defaults in code
+	changed := false
+	for channelId, override := range AutoFeeOverrides {
+		base, _ := AutoFeeBaseRule(channelId)
+		sparse, err := diffRule(base, applyOverride(base, override))
+		if err != nil || len(sparse) == len(override) {
+			continue
+		}
+		if len(sparse) == 0 {
+			delete(AutoFeeOverrides, channelId)
+		} else {
+			AutoFeeOverrides[channelId] = sparse
+		}
+		changed = true
+	}
+	if changed {
+		db.Save("AutoFees", "AutoFeeOverrides", AutoFeeOverrides)
+	}
+}
+
+// AutoFeeBaseRule returns the group rule of the channel or the default one
+func AutoFeeBaseRule(channelId uint64) (*AutoFeeParams, string) {
+	if group := AutoFeeGroupOf[channelId]; group != "" && AutoFeeGroups[group] != nil {
+		return AutoFeeGroups[group], group
+	}
+	return &AutoFeeDefaults, ""
+}
+
+// base rule with the override applied, as a copy
+func applyOverride(base *AutoFeeParams, override AutoFeeOverride) *AutoFeeParams {
+	// unmarshal would reuse the backing arrays of shared slices
+	params := copyRule(base)
+	if len(override) == 0 {
+		return params
+	}
+
+	data, err := json.Marshal(override)
+	if err == nil {
+		// only listed fields are overwritten
+		err = json.Unmarshal(data, params)
+	}
+	if err != nil {
+		log.Println("AutoFee override:", err)
+		return copyRule(base)
+	}
+	return params
+}
+
+// copy that shares no slices with the rule
+func copyRule(params *AutoFeeParams) *AutoFeeParams {
+	p := *params
+	p.Schedule = append([]ScheduleWindow(nil), params.Schedule...)
+	p.InboundBands = append([]InboundBand(nil), params.InboundBands...)
+	return &p
+}
+
+// fields of params that differ from base
+func diffRule(base, params *AutoFeeParams) (AutoFeeOverride, error) {
+	var baseFields, fields map[string]json.RawMessage
+
+	data, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &baseFields); err != nil {
+		return nil, err
+	}
+
+	data, err = json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	override := make(AutoFeeOverride)
+	for name, value := range fields {
+		if !bytes.Equal(baseFields[name], value) {
+			override[name] = value
+		}
+	}
+	return override, nil
+}
+
+// SetAutoFeeRule stores only what differs from the channel's base rule
+func SetAutoFeeRule(channelId uint64, params *AutoFeeParams) error {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	return setAutoFeeRule(channelId, params)
+}
+
+// caller holds autoFeeMutex
+func setAutoFeeRule(channelId uint64, params *AutoFeeParams) error {
+	base, _ := AutoFeeBaseRule(channelId)
+	override, err := diffRule(base, params)
+	if err != nil {
+		return err
+	}
+
+	if len(override) == 0 {
+		delete(AutoFeeOverrides, channelId)
+	} else {
+		AutoFeeOverrides[channelId] = override
+	}
+
+	return db.Save("AutoFees", "AutoFeeOverrides", AutoFeeOverrides)
+}
+
+// ResetAutoFeeRule removes the channel's override
+func ResetAutoFeeRule(channelId uint64) error {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	delete(AutoFeeOverrides, channelId)
+	return db.Save("AutoFees", "AutoFeeOverrides", AutoFeeOverrides)
+}
+
+// AutoFeeGroupNames returns sorted group names
+func AutoFeeGroupNames() []string {
+	var names []string
+	for name := range AutoFeeGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SaveAutoFeeGroup creates or updates a group, members follow it
+func SaveAutoFeeGroup(name string, params *AutoFeeParams) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("group name is required")
+	}
+
+	p := *params
+
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	AutoFeeGroups[name] = &p
+
+	return db.Save("AutoFees", "AutoFeeGroups", AutoFeeGroups)
+}
+
+// DeleteAutoFeeGroup moves members back to the default rule
+func DeleteAutoFeeGroup(name string) error {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	if AutoFeeGroups[name] == nil {
+		return errors.New("group not found")
+	}
+
+	delete(AutoFeeGroups, name)
+	for channelId, group := range AutoFeeGroupOf {
+		if group == name {
+			delete(AutoFeeGroupOf, channelId)
+		}
+	}
+
+	if err := db.Save("AutoFees", "AutoFeeGroupOf", AutoFeeGroupOf); err != nil {
+		return err
+	}
+	return db.Save("AutoFees", "AutoFeeGroups", AutoFeeGroups)
+}
+
+// SetAutoFeeGroup assigns the channel to a group, "" for the default rule.
+// The override is kept as it is, applying on top of the new group.
+func SetAutoFeeGroup(channelId uint64, name string) error {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	if name == "" {
+		delete(AutoFeeGroupOf, channelId)
+	} else if AutoFeeGroups[name] == nil {
+		return errors.New("group not found")
+	} else {
+		AutoFeeGroupOf[channelId] = name
+	}
+
+	return db.Save("AutoFees", "AutoFeeGroupOf", AutoFeeGroupOf)
+}
+
+// SetAutoFeeDefaults replaces the default rule
+func SetAutoFeeDefaults(params *AutoFeeParams) error {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	AutoFeeDefaults = *params
+	return db.Save("AutoFees", "AutoFeeDefaults", AutoFeeDefaults)
+}
+
+// SetAutoFeeEnabled switches the engine for the channels
+func SetAutoFeeEnabled(enabled bool, channelIds ...uint64) error {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	for _, channelId := range channelIds {
+		AutoFeeEnabled[channelId] = enabled
+	}
+	return db.Save("AutoFees", "AutoFeeEnabled", AutoFeeEnabled)
+}
+
+// copy of the rules, safe to use while the engine runs
+type AutoFeeRules struct {
+	Defaults AutoFeeParams
+	Groups   map[string]*AutoFeeParams
+	GroupOf  map[uint64]string
+	// only the fields that differ from the group or default
+	Overrides map[uint64]AutoFeeOverride
+	// effective rules of channels with overrides
+	Custom  map[uint64]*AutoFeeParams
+	Enabled map[uint64]bool
+}
+
+// Rule returns the channel's effective rule, its group and if it has overrides
+func (r *AutoFeeRules) Rule(channelId uint64) (*AutoFeeParams, string, bool) {
+	group := r.GroupOf[channelId]
+	if r.Groups[group] == nil {
+		group = ""
+	}
+	if params := r.Custom[channelId]; params != nil && channelId > 0 {
+		return params, group, true
+	}
+	if group != "" {
+		return r.Groups[group], group, false
+	}
+	return &r.Defaults, "", false
+}
+
+// AutoFeeRulesSnapshot copies the rules under the engine's lock
+func AutoFeeRulesSnapshot() *AutoFeeRules {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	rules := &AutoFeeRules{
+		Defaults:  *copyRule(&AutoFeeDefaults),
+		Groups:    make(map[string]*AutoFeeParams),
+		GroupOf:   make(map[uint64]string),
+		Overrides: make(map[uint64]AutoFeeOverride),
+		Custom:    make(map[uint64]*AutoFeeParams),
+		Enabled:   make(map[uint64]bool),
+	}
+
+	for name, params := range AutoFeeGroups {
+		rules.Groups[name] = copyRule(params)
+	}
+	for channelId, group := range AutoFeeGroupOf {
+		rules.GroupOf[channelId] = group
+	}
+	for channelId, override := range AutoFeeOverrides {
+		o := make(AutoFeeOverride, len(override))
+		for field, value := range override {
+			o[field] = value
+		}
+		rules.Overrides[channelId] = o
+
+		params, _ := AutoFeeRule(channelId)
+		rules.Custom[channelId] = copyRule(params)
+	}
+	for channelId, enabled := range AutoFeeEnabled {
+		rules.Enabled[channelId] = enabled
+	}
+
+	return rules
+}
+
+// AutoFeeIsEnabled is true when the engine manages the channel
+func AutoFeeIsEnabled(channelId uint64) bool {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	return AutoFeeEnabledAll && AutoFeeEnabled[channelId]
+}
+
+// copy of one channel's rule and recent changes
+type AutoFeeChannel struct {
+	Enabled    bool
+	CustomRule bool
+	Group      string
+	Overrides  AutoFeeOverride
+	Rule       *AutoFeeParams
+	Log        []*AutoFeeEvent
+	// simulated changes while dry run is on
+	DryRunLog  []*AutoFeeEvent
+	MaxHtlcLog []*MaxHtlcEvent
+}
+
+// AutoFeeChannelSnapshot copies the channel's state and the events
+// after the unix time under the engine's lock
+func AutoFeeChannelSnapshot(channelId uint64, since int64) *AutoFeeChannel {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	rule, isCustom := AutoFeeRule(channelId)
+	c := &AutoFeeChannel{
+		Enabled:    AutoFeeEnabled[channelId],
+		CustomRule: isCustom,
+		Group:      AutoFeeGroupOf[channelId],
+		Overrides:  make(AutoFeeOverride),
+		Rule:       copyRule(rule),
+		Log:        eventsSince(AutoFeeLog[channelId], since),
+		DryRunLog:  []*AutoFeeEvent{},
+		MaxHtlcLog: []*MaxHtlcEvent{},
+	}
+
+	for field, value := range AutoFeeOverrides[channelId] {
+		c.Overrides[field] = value
+	}
+	if AutoFeeDryRun {
+		c.DryRunLog = eventsSince(AutoFeeDryRunLog[channelId], since)
+	}
+	for _, event := range MaxHtlcLog[channelId] {
+		if event.TimeStamp > since {
+			e := *event
+			c.MaxHtlcLog = append(c.MaxHtlcLog, &e)
+		}
+	}
+
+	return c
+}
+
+// AutoFeeLogSince copies the channel's fee changes after the unix time
+func AutoFeeLogSince(channelId uint64, since int64) []*AutoFeeEvent {
+	autoFeeMutex.Lock()
+	defer autoFeeMutex.Unlock()
+
+	return eventsSince(AutoFeeLog[channelId], since)
+}
+
+// copies of the events after the unix time, caller holds autoFeeMutex
+func eventsSince(events []*AutoFeeEvent, since int64) []*AutoFeeEvent {
+	result := []*AutoFeeEvent{}
+	for _, event := range events {
+		if event.TimeStamp > since {
+			e := *event
+			result = append(result, &e)
+		}
+	}
+	return result
+}
+
+// GroupMembers counts channels per group
+func GroupMembers(name string) int {
+	count := 0
+	for _, group := range AutoFeeGroupOf {
+		if group == name {
+			count++
+		}
+	}
+	return count
+}
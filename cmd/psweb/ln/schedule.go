@@ -0,0 +1,211 @@
+package ln
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// fee rate adjustment during certain hours, in UTC
+type ScheduleWindow struct {
+	// bitmask of time.Weekday, 0 = every day
+	Days int
+	// minutes from midnight, End < Start wraps past midnight, Start == End is all day
+	Start int
+	End   int
+	// percent added to the rate, can be negative
+	Pct int
+}
+
+func (w ScheduleWindow) active(t time.Time) bool {
+	t = t.UTC()
+	if w.Days != 0 && w.Days&(1<<int(t.Weekday())) == 0 {
+		return false
+	}
+
+	m := t.Hour()*60 + t.Minute()
+	switch {
+	case w.Start == w.End:
+		return true
+	case w.Start < w.End:
+		return m >= w.Start && m < w.End
+	default:
+		return m >= w.Start || m < w.End
+	}
+}
+
+// formats as "+15% Mon-Fri 13:00-21:00"
+func (w ScheduleWindow) String() string {
+	s := fmt.Sprintf("%+d%%", w.Pct)
+
+	if w.Days != 0 {
+		var days []string
+		for i := 0; i < 7; i++ {
+			if w.Days&(1<<i) == 0 {
+				continue
+			}
+			// collapse consecutive days into a range
+			j := i
+			for j < 6 && w.Days&(1<<(j+1)) != 0 {
+				j++
+			}
+			if j-i > 1 {
+				days = append(days, weekdays[i]+"-"+weekdays[j])
+				i = j
+			} else {
+				days = append(days, weekdays[i])
+			}
+		}
+		s += " " + strings.Join(days, ",")
+	}
+
+	if w.Start != w.End {
+		s += fmt.Sprintf(" %02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+	}
+
+	return s
+}
+
+// sum of all windows active at t
+func (p *AutoFeeParams) SchedulePct(t time.Time) int {
+	pct := 0
+	for _, w := range p.Schedule {
+		if w.active(t) {
+			pct += w.Pct
+		}
+	}
+	return max(pct, -99)
+}
+
+// windows separated by ";" for the AF page
+func (p *AutoFeeParams) ScheduleString() string {
+	var s []string
+	for _, w := range p.Schedule {
+		s = append(s, w.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+// ParseSchedule reads windows like "+15% Mon-Fri 13:00-21:00; -10% Sat,Sun"
+func ParseSchedule(text string) ([]ScheduleWindow, error) {
+	var schedule []ScheduleWindow
+
+	for _, part := range strings.FieldsFunc(text, func(r rune) bool { return r == ';' || r == '\n' }) {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var w ScheduleWindow
+
+		pct, err := strconv.Atoi(strings.TrimSuffix(fields[0], "%"))
+		if err != nil || !strings.HasSuffix(fields[0], "%") {
+			return nil, fmt.Errorf("schedule %q must start with a percentage like +15%%", part)
+		}
+		if pct <= -100 || pct == 0 {
+			return nil, fmt.Errorf("schedule %q: percentage must be above -100 and not zero", part)
+		}
+		w.Pct = pct
+
+		for _, f := range fields[1:] {
+			if strings.Contains(f, ":") {
+				if w.Start, w.End, err = parseHours(f); err != nil {
+					return nil, fmt.Errorf("schedule %q: %w", part, err)
+				}
+			} else if w.Days, err = parseDays(f); err != nil {
+				return nil, fmt.Errorf("schedule %q: %w", part, err)
+			}
+		}
+
+		schedule = append(schedule, w)
+	}
+
+	return schedule, nil
+}
+
+// "Mon-Fri" or "Sat,Sun"
+func parseDays(s string) (int, error) {
+	days := 0
+	for _, d := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(d, "-")
+		i := weekdayIndex(from)
+		j := i
+		if isRange {
+			j = weekdayIndex(to)
+		}
+		if i < 0 || j < 0 {
+			return 0, errors.New("unknown day " + d)
+		}
+		for {
+			days |= 1 << i
+			if i == j {
+				break
+			}
+			// Fri-Mon wraps through the weekend
+			i = (i + 1) % 7
+		}
+	}
+	return days, nil
+}
+
+func weekdayIndex(s string) int {
+	for i, d := range weekdays {
+		if strings.EqualFold(s, d) {
+			return i
+		}
+	}
+	return -1
+}
+
+// "13:00-21:00"
+func parseHours(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, errors.New("hours must be like 13:00-21:00")
+	}
+	start, err := parseMinutes(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseMinutes(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseMinutes(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 0, nil
+		}
+		return 0, errors.New("invalid time " + s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// applies schedule percentage to a rate
+func withSchedule(fee int, pct int) int {
+	return fee * (100 + pct) / 100
+}
+
+// the rate before the schedule and flow adjustments the engine applied last time
+func scheduleBase(channelId uint64, oldFee int) int {
+	last := lastEngineLog(channelId, false)
+	if last == nil || last.IsManual || last.NewRate != oldFee {
+		// manual changes
+		return oldFee
+	}
+	if last.BaseRate > 0 {
+		// dividing back is lossy and would drift every run
+		return last.BaseRate
+	}
+	// logged before the base was kept
+	pct := feeAdjust{Schedule: last.SchedulePct, Flow: last.FlowPct}.pct()
+	return oldFee * 100 / (100 + pct)
+}
@@ -0,0 +1,129 @@
+package ln
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    []ScheduleWindow
+		wantErr bool
+	}{
+		{text: "", want: nil},
+		{text: " ; ", want: nil},
+		{
+			text: "+15% Mon-Fri 13:00-21:00",
+			want: []ScheduleWindow{{Days: 0b0111110, Start: 780, End: 1260, Pct: 15}},
+		},
+		{
+			text: "-10% Sat,Sun",
+			want: []ScheduleWindow{{Days: 0b1000001, Pct: -10}},
+		},
+		{
+			// wraps through the weekend
+			text: "+5% fri-mon",
+			want: []ScheduleWindow{{Days: 0b1100011, Pct: 5}},
+		},
+		{
+			// all days, past midnight
+			text: "+20% 22:00-02:30",
+			want: []ScheduleWindow{{Start: 1320, End: 150, Pct: 20}},
+		},
+		{
+			text: "+20% 22:00-24:00",
+			want: []ScheduleWindow{{Start: 1320, End: 0, Pct: 20}},
+		},
+		{
+			text: "+15% Mon-Fri 13:00-21:00; -10% Sat,Sun\n+5% 08:00-09:00",
+			want: []ScheduleWindow{
+				{Days: 0b0111110, Start: 780, End: 1260, Pct: 15},
+				{Days: 0b1000001, Pct: -10},
+				{Start: 480, End: 540, Pct: 5},
+			},
+		},
+		{text: "15 Mon", wantErr: true},
+		{text: "+0% Mon", wantErr: true},
+		{text: "-100% Mon", wantErr: true},
+		{text: "+10% Funday", wantErr: true},
+		{text: "+10% Mon-Funday", wantErr: true},
+		{text: "+10% 13:00", wantErr: true},
+		{text: "+10% 25:00-26:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseSchedule(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulePct(t *testing.T) {
+	params := &AutoFeeParams{Schedule: []ScheduleWindow{
+		{Days: 0b0111110, Start: 780, End: 1260, Pct: 15},
+		{Start: 1320, End: 120, Pct: -10},
+		{Days: 0b0000010, Pct: -200},
+	}}
+
+	tests := []struct {
+		name string
+		time string
+		want int
+	}{
+		{"weekday window", "2026-10-14T13:00:00Z", 15},
+		{"window end is exclusive", "2026-10-14T21:00:00Z", 0},
+		{"past midnight", "2026-10-15T01:59:00Z", -10},
+		{"outside all windows", "2026-10-18T13:00:00Z", 0},
+		{"capped at -99", "2026-10-12T14:00:00Z", -99},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, err := time.Parse(time.RFC3339, tt.time)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := params.SchedulePct(ts); got != tt.want {
+				t.Errorf("SchedulePct(%s) = %d, want %d", tt.time, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleBase(t *testing.T) {
+	const channelId = 1
+	defer func() { delete(AutoFeeLog, channelId) }()
+
+	tests := []struct {
+		name string
+		last *AutoFeeEvent
+		fee  int
+		want int
+	}{
+		{"no log", nil, 1419, 1419},
+		{"kept base", &AutoFeeEvent{NewRate: 1419, SchedulePct: 15, BaseRate: 1234}, 1419, 1234},
+		{"legacy event", &AutoFeeEvent{NewRate: 1150, SchedulePct: 15}, 1150, 1000},
+		{"manual change", &AutoFeeEvent{NewRate: 1419, SchedulePct: 15, BaseRate: 1234, IsManual: true}, 1419, 1419},
+		{"changed since", &AutoFeeEvent{NewRate: 1419, SchedulePct: 15, BaseRate: 1234}, 900, 900},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AutoFeeLog[channelId] = nil
+			if tt.last != nil {
+				AutoFeeLog[channelId] = []*AutoFeeEvent{tt.last}
+			}
+			if got := scheduleBase(channelId, tt.fee); got != tt.want {
+				t.Errorf("scheduleBase(%d) = %d, want %d", tt.fee, got, tt.want)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
 	"peerswap-web/cmd/psweb/ln"
 
 	"github.com/elementsproject/glightning/glightning"
@@ -74,13 +75,22 @@ func main() {
 
 	plugin.SubscribeSendPaySuccess(onSendPaySuccess)
 	plugin.SubscribeInvoicePaid(onInvoicePaid)
+	plugin.SubscribeShutdown(onShutdown)
 
 	err := plugin.Start(os.Stdin, os.Stdout)
+	db.Close()
 	if err != nil {
 		log.Fatalln(err)
 	}
 }
 
+// lightningd is stopping, flush the database and exit
+func onShutdown() {
+	log.Println("Received shutdown notification")
+	db.Close()
+	os.Exit(0)
+}
+
 func redirectStderr(filename string) error {
 	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -10,6 +10,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
 	"syscall"
 )
 
@@ -63,6 +64,8 @@ func main() {
 	sig := <-signalChan
 	log.Printf("Received termination signal: %s\n", sig)
 
+	db.Close()
+
 	// Exit the program gracefully
 	os.Exit(0)
 }
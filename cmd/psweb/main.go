@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"embed"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,6 +16,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -53,6 +55,10 @@ type AutoSwapParams struct {
 	Amount         uint64
 	RoutingPpm     uint64
 	PremiumRatePpm int64
+	// "lbtc" or "btc"
+	Asset string
+	// estimated premium plus opening tx fee, sats
+	Cost int64
 }
 
 var (
@@ -70,8 +76,6 @@ var (
 	txFee = make(map[string]int64)
 	// Key used for cookie encryption
 	store *sessions.CookieStore
-	// pending Auto Swap Id to check the state later
-	autoSwapId string
 	// store peer pub mapped to channel Id
 	peerNodeId = make(map[uint64]string)
 	// only poll all peers once after peerswap initializes
@@ -86,6 +90,8 @@ var (
 	elementsBitcoinId = ""
 	// required maturity for peg-in funding tx
 	peginBlocks = uint32(102)
+	// one checkPegin at a time
+	peginMutex sync.Mutex
 	// wait for lighting to sync
 	lightningHasStarted = false
 	// debug flag
@@ -108,10 +114,28 @@ func start() {
 		peginBlocks = 10
 	}
 
+	// open database for the lifetime of the process
+	if err := db.Open(); err != nil {
+		if errors.Is(err, db.ErrNewerSchema) {
+			// running on it would corrupt the newer data
+			log.Fatalln("Cannot start, please upgrade PSWeb:", err)
+		}
+		// automation would run with nothing persisting
+		log.Fatalln("Cannot start, database is not available:", err)
+	}
+
 	// Load persisted data from database
+	migrateLegacyPegin()
 	ln.LoadDB()
 	db.Load("Peers", "NodeId", &peerNodeId)
-	db.Load("Swaps", "txFee", &txFee)
+	loadAutoSwapOut()
+	loadAutoSwapOutcomes()
+	loadAutoSwapsInFlight()
+	loadSwapLedger()
+	loadOnchainCosts()
+	loadTxLabels()
+	txFee, _ = db.LoadTxFees()
+	loadApiTokens()
 
 	// Get all HTML template files from the embedded filesystem
 	templateFiles, err := tplFolder.ReadDir("templates")
@@ -159,19 +183,30 @@ func start() {
 	r.HandleFunc("/stop", stopHandler)
 	r.HandleFunc("/update", updateHandler)
 	r.HandleFunc("/liquid", liquidHandler)
+	r.HandleFunc("/autoswaps", autoSwapsHandler)
+	r.HandleFunc("/ledger", ledgerHandler)
+	r.HandleFunc("/accounting", accountingHandler)
+	r.HandleFunc("/labels", labelsHandler)
 	r.HandleFunc("/loading", loadingHandler)
 	r.HandleFunc("/log", logHandler)
 	r.HandleFunc("/logapi", logApiHandler)
 	r.HandleFunc("/backup", backupHandler)
+	r.HandleFunc("/restore", restoreHandler)
 	r.HandleFunc("/bitcoin", bitcoinHandler)
 	r.HandleFunc("/pegin", peginHandler)
 	r.HandleFunc("/bumpfee", bumpfeeHandler)
+	r.HandleFunc("/pegout", pegoutHandler)
 	r.HandleFunc("/ca", caHandler)
 	r.HandleFunc("/premiums", globalPremiumsHandler)
 	r.HandleFunc("/login", loginHandler)
 	r.HandleFunc("/logout", logoutHandler)
 	r.HandleFunc("/downloadca", downloadCaHandler)
 	r.HandleFunc("/af", afHandler)
+	r.HandleFunc("/backtest", backtestHandler)
+	r.HandleFunc("/events", eventsHandler)
+
+	// JSON API
+	addApiRoutes(r)
 
 	if config.Config.SecureConnection {
 		// HTTP redirection
@@ -199,6 +234,9 @@ func start() {
 
 	// Start timer to run every minute
 	go startTimer()
+
+	// push live updates to /events subscribers
+	go watchEvents()
 }
 
 func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
@@ -238,8 +276,8 @@ func serveHTTPS(handler http.Handler) {
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		ClientCAs:    caCertPool,
-		ClientAuth:   tls.RequireAndVerifyClientCert, // Require and verify client certificate
-		MinVersion:   tls.VersionTLS12,               // Force TLS 1.2 or higher
+		ClientAuth:   tls.VerifyClientCertIfGiven, // authMiddleware rejects requests with neither a certificate nor a token
+		MinVersion:   tls.VersionTLS12,            // Force TLS 1.2 or higher
 	}
 
 	// Do not require client certificate if Password auth enabled
@@ -362,16 +400,30 @@ func onTimer() {
 		// Check if peg-in can be claimed, initiated or joined
 		checkPegin()
 
+		// follow peg-outs until paid out on Bitcoin
+		checkPegout()
+
 		// advertise own balances if enabled
 		advertiseBalances()
 
 		// poll peers for their balances and ClaimJoin invites
 		pollBalances()
 
-		// see if possible to execute Automatic Liquid Swap In
+		// record outcomes of finished auto swaps
+		followAutoSwaps()
+
+		// record costs and revenue of swaps
+		updateSwapLedger()
+
+		// see if possible to execute Automatic Swap In
 		if config.Config.AutoSwapEnabled {
 			executeAutoSwap()
 		}
+
+		// see if possible to execute Automatic Swap Out
+		if config.Config.AutoSwapOutEnabled {
+			executeAutoSwapOut()
+		}
 	} else {
 		// run only once when lighting becomes available
 		lightningHasStarted = cacheAliases()
@@ -427,7 +479,7 @@ func liquidBackup(force bool) {
 
 	msg := formatWithThousandSeparators(satAmount) + " (" + sign + formatSigned(int64(satAmount)-int64(config.Config.ElementsBackupAmount)) + ")"
 
-	err = telegramSendFile(config.Config.DataDir, destinationZip, msg)
+	err = telegramSendFile(config.Config.DataDir, destinationZip, "🌊 "+msg)
 	if err != nil {
 		log.Println("Error sending zip:", err)
 		return
@@ -994,7 +1046,6 @@ func convertSwapsToHTMLTable(swaps []*peerswaprpc.PrettyPrintSwap, nodeId string
 		unsortedTable []Table
 		totalAmount   uint64
 		totalCost     int64
-		persist       = false // new tx costs to persist
 	)
 
 	for _, swap := range swaps {
@@ -1048,8 +1099,7 @@ func convertSwapsToHTMLTable(swaps []*peerswaprpc.PrettyPrintSwap, nodeId string
 			table += " ⚡&nbsp⇨&nbsp" + asset
 		}
 
-		cost, _, new := swapCost(swap)
-		persist = persist || new
+		cost, _ := swapCost(swap)
 
 		if cost != 0 {
 			totalCost += cost
@@ -1096,11 +1146,6 @@ func convertSwapsToHTMLTable(swaps []*peerswaprpc.PrettyPrintSwap, nodeId string
 		})
 	}
 
-	if persist {
-		// save new chain fees to db
-		db.Save("Swaps", "txFee", txFee)
-	}
-
 	// sort the table on TimeStamp field
 	sort.Slice(unsortedTable, func(i, j int) bool {
 		return unsortedTable[i].TimeStamp > unsortedTable[j].TimeStamp
@@ -1134,6 +1179,12 @@ func convertSwapsToHTMLTable(swaps []*peerswaprpc.PrettyPrintSwap, nodeId string
 
 // Check Peg-in status
 func checkPegin() {
+	if !peginMutex.TryLock() {
+		// another check is in progress
+		return
+	}
+	defer peginMutex.Unlock()
+
 	currentBlockHeight := ln.GetBlockHeight()
 
 	if currentBlockHeight > ln.JoinBlockHeight && ln.MyRole == "none" && ln.ClaimJoinHandler != "" {
@@ -1146,146 +1197,191 @@ func checkPegin() {
 		db.Save("ClaimJoin", "ClaimJoinHandler", ln.ClaimJoinHandler)
 	}
 
-	if config.Config.PeginTxId == "" {
-		// send telegram if received new ClaimJoin invitation
-		if peginInvite != ln.ClaimJoinHandler {
-			t := "⌚ ClaimJoin invitation has expired"
-			if ln.ClaimJoinHandler != "" {
-				duration := time.Duration(10*(ln.JoinBlockHeight-currentBlockHeight)) * time.Minute
-				timeLimit := time.Now().Add(duration).Format("3:04 PM")
-				t = "🧬 Invitation to join a confidential peg-in before " + timeLimit
-			}
-			if telegramSendMessage(t) {
-				peginInvite = ln.ClaimJoinHandler
-			}
+	// send telegram if received new ClaimJoin invitation
+	if ln.ClaimPeginId == 0 && peginInvite != ln.ClaimJoinHandler {
+		t := "⌚ ClaimJoin invitation has expired"
+		if ln.ClaimJoinHandler != "" {
+			duration := time.Duration(10*(ln.JoinBlockHeight-currentBlockHeight)) * time.Minute
+			timeLimit := time.Now().Add(duration).Format("3:04 PM")
+			t = "🧬 Invitation to join a confidential peg-in before " + timeLimit
 		}
+		if telegramSendMessage(t) {
+			peginInvite = ln.ClaimJoinHandler
+		}
+	}
+
+	for _, p := range activePegins() {
+		followPegin(p, currentBlockHeight)
+	}
+}
+
+// advances one peg-in or withdrawal, saves the record when it changes
+func followPegin(p *db.Pegin, currentBlockHeight uint32) {
+	if p.TxId == "external" || p.TxId == "" && p.Status == "pending" {
+		// awaiting funding txid
 		return
 	}
 
-	if config.Config.PeginTxId == "external" {
+	if p.Status == "claimed" {
+		// finish by sending telegram message
+		telegramSendMessage("💸 Peg-in complete! Liquid TxId: `" + p.ClaimTxId + "`")
+		publishPegin(p, p.ClaimTxId, -1, "complete")
+		labelTx("lbtc", p.ClaimTxId, "ClaimJoin Peg-in claim")
+		peginInvite = ""
+		ln.ClaimJoinHandler = ""
+		p.Status = "complete"
+		db.SavePegin(p)
 		return
 	}
 
-	if config.Config.PeginClaimJoin {
-		if config.Config.PeginClaimScript == "done" {
-			// finish by sending telegram message
-			telegramSendMessage("💸 Peg-in complete! Liquid TxId: `" + config.Config.PeginTxId + "`")
-			peginInvite = ""
-			ln.ClaimJoinHandler = ""
-			config.Config.PeginClaimScript = ""
-			config.Config.PeginTxId = ""
-			config.Config.PeginClaimJoin = false
-			config.Save()
-			return
-		}
-
-		if ln.MyRole != "none" {
-			// 10 blocks to wait before switching back to individual claim
-			if currentBlockHeight >= ln.ClaimBlockHeight+10 {
-				// claim pegin individually
-				t := "ClaimJoin expired, falling back to the individual claim"
-				log.Println(t)
-				telegramSendMessage("🧬 " + t)
-				ln.MyRole = "none"
-				config.Config.PeginClaimJoin = false
-				config.Save()
-				ln.EndClaimJoin("", "Reached Claim Block Height")
-			} else if currentBlockHeight >= ln.ClaimBlockHeight && ln.MyRole == "initiator" {
-				// proceed with
-				ln.OnBlock(currentBlockHeight)
-			}
-			return
-		}
-	}
-
-	confs, _ := peginConfirmations(config.Config.PeginTxId)
-	if confs < 0 && config.Config.PeginReplacedTxId != "" {
-		confs, _ = peginConfirmations(config.Config.PeginReplacedTxId)
-		if confs > 0 {
-			// RBF replacement conflict: the old transaction mined before the new one
-			config.Config.PeginTxId = config.Config.PeginReplacedTxId
-			config.Config.PeginReplacedTxId = ""
-			log.Println("The last RBF failed as previous tx mined earlier, switching to prior txid:", config.Config.PeginTxId)
-		}
-	}
-
-	if confs > 0 {
-		if config.Config.PeginClaimScript == "" {
-			// regular BTC withdrawal
-			log.Println("BTC withdrawal complete, txId: " + config.Config.PeginTxId)
-			telegramSendMessage("💸 BTC withdrawal complete. TxId: `" + config.Config.PeginTxId + "`")
-		} else if confs >= int32(peginBlocks) && ln.MyRole == "none" {
-			// pegin matured, claim individual peg-in
-			failed := false
-			proof := ""
-			txid := ""
-			rawTx, err := bitcoin.GetRawTransaction(config.Config.PeginTxId, nil)
-			if err == nil {
-				proof, err = bitcoin.GetTxOutProof(config.Config.PeginTxId)
-				if err != nil {
-					// try again
-					time.Sleep(10 * time.Second)
-					proof, err = bitcoin.GetTxOutProof(config.Config.PeginTxId)
+	if p.ClaimJoin && ln.ClaimPeginId == p.Id && ln.MyRole != "none" {
+		// 10 blocks to wait before switching back to individual claim
+		if currentBlockHeight >= ln.ClaimBlockHeight+10 {
+			// claim pegin individually
+			t := "ClaimJoin expired, falling back to the individual claim"
+			log.Println(t)
+			telegramSendMessage("🧬 " + t)
+			ln.MyRole = "none"
+			p.ClaimJoin = false
+			db.SavePegin(p)
+			ln.EndClaimJoin("", "Reached Claim Block Height")
+		} else if currentBlockHeight >= ln.ClaimBlockHeight && ln.MyRole == "initiator" {
+			// proceed with
+			ln.OnBlock(currentBlockHeight)
+		}
+		return
+	}
+
+	changed := false
+	confs, _ := peginConfirmations(p.TxId)
+	if confs < 0 {
+		// RBF replacement conflict: an older transaction mined before the new one
+		for i := len(p.ReplacedTxIds) - 1; i >= 0; i-- {
+			if c, _ := peginConfirmations(p.ReplacedTxIds[i]); c > 0 {
+				confs = c
+				p.TxId = p.ReplacedTxIds[i]
+				p.ReplacedTxIds = p.ReplacedTxIds[:i]
+				if amount, err := peginOutputAmount(p.TxId, p.Address); err == nil {
+					p.Amount = amount
 				}
+				changed = true
+				log.Println("The last RBF failed as previous tx mined earlier, switching to prior txid:", p.TxId)
+				break
+			}
+		}
+	}
 
-				if err == nil {
-					txid, err = liquid.ClaimPegin(rawTx, proof, config.Config.PeginClaimScript)
-					// claimpegin takes long time, allow it to timeout
-					if err != nil && err.Error() != "timeout reading data from server" && err.Error() != "-4: Error: The transaction was rejected! Reason given: pegin-already-claimed" {
-						failed = true
-					}
-				} else {
+	if confs != p.Confirmations {
+		p.Confirmations = confs
+		changed = true
+		publishPegin(p, p.TxId, confs, "confirming")
+	}
+
+	if confs <= 0 {
+		if changed {
+			db.SavePegin(p)
+		}
+		return
+	}
+
+	if !p.IsPegin() {
+		// regular BTC withdrawal
+		recordOnchainCost("withdrawal", "btc", p.TxId)
+		log.Println("BTC withdrawal complete, txId: " + p.TxId)
+		telegramSendMessage("💸 BTC withdrawal complete. TxId: `" + p.TxId + "`")
+		publishPegin(p, p.TxId, confs, "complete")
+		p.Status = "complete"
+		db.SavePegin(p)
+		return
+	}
+
+	recordOnchainCost("pegin", "btc", p.TxId)
+
+	if confs >= int32(peginBlocks) {
+		// pegin matured, claim individual peg-in
+		failed := false
+		proof := ""
+		txid := ""
+		rawTx, err := bitcoin.GetRawTransaction(p.TxId, nil)
+		if err == nil {
+			proof, err = bitcoin.GetTxOutProof(p.TxId)
+			if err != nil {
+				// try again
+				time.Sleep(10 * time.Second)
+				proof, err = bitcoin.GetTxOutProof(p.TxId)
+			}
+
+			if err == nil {
+				txid, err = liquid.ClaimPegin(rawTx, proof, p.ClaimScript)
+				// claimpegin takes long time, allow it to timeout
+				if err != nil && err.Error() != "timeout reading data from server" && err.Error() != "-4: Error: The transaction was rejected! Reason given: pegin-already-claimed" {
 					failed = true
 				}
 			} else {
 				failed = true
 			}
+		} else {
+			failed = true
+		}
 
-			if failed {
-				log.Printf("Peg-in claim FAILED! Recover your funds manually with this command line:\n\nelements-cli claimpegin %s %s %s\n", rawTx, proof, config.Config.PeginClaimScript)
-				telegramSendMessage("❗ Peg-in claim FAILED! See log for instructions.")
-			} else {
-				log.Println("Peg-in complete! Liquid TxId:", txid)
-				telegramSendMessage("💸 Peg-in complete! Liquid TxId: `" + txid + "`")
-			}
+		if failed {
+			log.Printf("Peg-in claim FAILED! Recover your funds manually with this command line:\n\nelements-cli claimpegin %s %s %s\n", rawTx, proof, p.ClaimScript)
+			telegramSendMessage("❗ Peg-in claim FAILED! See log for instructions. TxId: `" + p.TxId + "`")
+			publishPegin(p, p.TxId, confs, "failed")
+			p.Status = "failed"
 		} else {
-			if ln.ClaimStatus == "Awaiting funding tx to confirm" {
-				// funding tx confirmed
-				ln.ClaimStatus = "Funding tx confirmed, awaiting maturity"
-				db.Save("ClaimJoin", "ClaimStatus", ln.ClaimStatus)
-				telegramSendMessage(ln.ClaimStatus + ", ETA: " + time.Now().Add(time.Hour*17).Format("3:04 PM"))
-			}
-
-			if config.Config.PeginClaimJoin {
-				if ln.MyRole == "none" {
-					claimHeight := currentBlockHeight + peginBlocks - uint32(confs)
-					if ln.ClaimJoinHandler == "" {
-						// I will coordinate this join
-						if ln.InitiateClaimJoin(claimHeight) {
-							t := "Sent ClaimJoin invitations"
-							log.Println(t + " as " + ln.MyPublicKey())
-							telegramSendMessage("🧬 " + t)
-							ln.MyRole = "initiator"
-							db.Save("ClaimJoin", "MyRole", ln.MyRole)
-						}
-					} else if currentBlockHeight <= ln.JoinBlockHeight {
-						// join by replying to initiator
-						if ln.JoinClaimJoin(claimHeight) {
-							t := "Applied to join confidential pegin"
-							log.Println(t + " " + ln.ClaimJoinHandler + " as " + ln.MyPublicKey())
-							telegramSendMessage("🧬 " + t)
-						} else {
-							log.Println("Failed to apply to ClaimJoin group", ln.ClaimJoinHandler)
-						}
-					}
-				}
-			}
-			return
+			log.Println("Peg-in complete! Liquid TxId:", txid)
+			labelTx("lbtc", txid, "Liquid Peg-in claim")
+			recordOnchainCost("pegin", "lbtc", txid)
+			telegramSendMessage("💸 Peg-in complete! Liquid TxId: `" + txid + "`")
+			publishPegin(p, txid, confs, "complete")
+			p.ClaimTxId = txid
+			p.Status = "complete"
 		}
 
 		// stop trying after one attempt to claim
-		config.Config.PeginTxId = ""
-		config.Save()
+		db.SavePegin(p)
+		ln.ForgetClaimPegin(p.Id)
+		return
+	}
+
+	if changed {
+		db.SavePegin(p)
+	}
+
+	// only one peg-in at a time can take part in ClaimJoin
+	if !p.ClaimJoin || ln.ClaimPeginId != 0 && ln.ClaimPeginId != p.Id {
+		return
+	}
+
+	if ln.ClaimStatus == "Awaiting funding tx to confirm" {
+		// funding tx confirmed
+		ln.ClaimStatus = "Funding tx confirmed, awaiting maturity"
+		db.Save("ClaimJoin", "ClaimStatus", ln.ClaimStatus)
+		telegramSendMessage(ln.ClaimStatus + ", ETA: " + time.Now().Add(time.Hour*17).Format("3:04 PM"))
+	}
+
+	if ln.MyRole == "none" {
+		claimHeight := currentBlockHeight + peginBlocks - uint32(confs)
+		if ln.ClaimJoinHandler == "" {
+			// I will coordinate this join
+			if ln.InitiateClaimJoin(p, claimHeight) {
+				t := "Sent ClaimJoin invitations"
+				log.Println(t + " as " + ln.MyPublicKey())
+				telegramSendMessage("🧬 " + t)
+				ln.MyRole = "initiator"
+				db.Save("ClaimJoin", "MyRole", ln.MyRole)
+			}
+		} else if currentBlockHeight <= ln.JoinBlockHeight {
+			// join by replying to initiator
+			if ln.JoinClaimJoin(p, claimHeight) {
+				t := "Applied to join confidential pegin"
+				log.Println(t + " " + ln.ClaimJoinHandler + " as " + ln.MyPublicKey())
+				telegramSendMessage("🧬 " + t)
+			} else {
+				log.Println("Failed to apply to ClaimJoin group", ln.ClaimJoinHandler)
+			}
+		}
 	}
 }
 
@@ -1343,28 +1439,93 @@ func cacheAliases() bool {
 	return true
 }
 
+// on-chain cost of the opening tx we pay when swapping in this asset
+func openingTxCost(asset string) int64 {
+	if asset == "btc" {
+		return int64(math.Ceil(mempoolFeeRate * OPENING_TX_SIZE_BTC))
+	}
+	if hasDiscountedvSize {
+		return int64(math.Ceil(liquid.EstimateFee() * OPENING_TX_SIZE_LBTC_DISCOUNTED))
+	}
+	return int64(math.Ceil(liquid.EstimateFee() * OPENING_TX_SIZE_LBTC))
+}
+
+// balances available for swap-ins by asset, after reserves
+func autoSwapInBalances() map[string]uint64 {
+	balances := make(map[string]uint64)
+
+	// Elements does not permit sending the whole balance, haircut it
+	if liquidBalance := getUnlockedLbtcBalance(); liquidBalance > uint64(SwapLbtcDustReserve) {
+		balances["lbtc"] = liquidBalance - uint64(SwapLbtcDustReserve)
+	}
+
+	if config.Config.BitcoinSwaps {
+		cl, clean, err := ln.GetClient()
+		if err != nil {
+			return balances
+		}
+		defer clean()
+
+		// haircut by anchor reserve
+		if bitcoinBalance := uint64(ln.ConfirmedWalletBalance(cl)); bitcoinBalance > ANCHOR_RESERVE {
+			balances["btc"] = bitcoinBalance - ANCHOR_RESERVE
+		}
+	}
+
+	return balances
+}
+
 // Finds a candidate for an automatic swap-in
-// The goal is to spend maximum available liquid
+// The goal is to spend maximum available liquid or bitcoin
 // To rebalance a channel with high enough historic fee PPM
 func findSwapInCandidate(candidate *AutoSwapParams) error {
+	ranks, err := rankSwapInCandidates(false)
+	if err != nil {
+		return err
+	}
+	for _, r := range ranks {
+		if r.Selected {
+			*candidate = r.AutoSwapParams
+		}
+	}
+	return nil
+}
+
+// evaluates all channels for a swap-in with the rule that excluded each.
+// Without explain, returns early when no channel can qualify.
+func rankSwapInCandidates(explain bool) ([]*AutoSwapRank, error) {
 	minAmount := config.Config.AutoSwapThresholdAmount - uint64(SwapLbtcDustReserve)
-	minPPM := config.Config.AutoSwapThresholdPPM
+
+	// only assets with enough accumulated balance
+	balances := autoSwapInBalances()
+	txCost := make(map[string]int64)
+	for asset, balance := range balances {
+		if balance < minAmount {
+			delete(balances, asset)
+			continue
+		}
+		txCost[asset] = openingTxCost(asset)
+	}
+
+	if len(balances) == 0 && !explain {
+		return nil, nil
+	}
 
 	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer cleanup()
 
 	res, err := ps.ListPeers(client)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	peers := res.GetPeers()
 
 	res2, err := ps.ListSwaps(client)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	swaps := res2.GetSwaps()
 
@@ -1373,35 +1534,81 @@ func findSwapInCandidate(candidate *AutoSwapParams) error {
 	// true if initiated swap out or received swap in
 	lastWasSwapOut := make(map[uint64]bool)
 
+	// channels with a swap in progress
+	busy := make(map[uint64]bool)
+
 	for _, swap := range swaps {
-		if simplifySwapState(swap.State) == "success" && swapTimestamps[swap.LndChanId] < swap.CreatedAt {
+		state := simplifySwapState(swap.State)
+		if state == "pending" {
+			busy[swap.LndChanId] = true
+		}
+		if state == "success" && swapTimestamps[swap.LndChanId] < swap.CreatedAt {
 			swapTimestamps[swap.LndChanId] = swap.CreatedAt
-			lastWasSwapOut[swap.LndChanId] = false
-			if swap.Asset == "lbtc" && (swap.Type+swap.Role == "swap-outsender" || swap.Type+swap.Role == "swap-inreceiver") {
-				lastWasSwapOut[swap.LndChanId] = true
-			}
+			lastWasSwapOut[swap.LndChanId] = swap.Type+swap.Role == "swap-outsender" || swap.Type+swap.Role == "swap-inreceiver"
 		}
 	}
 
 	cl, clean, err := ln.GetClient()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer clean()
 
+	ranks := []*AutoSwapRank{}
+
 	for _, peer := range peers {
-		// ignore peer with Liquid swaps disabled
-		if !peer.SwapsAllowed || !stringIsInSlice("lbtc", peer.SupportedAssets) {
-			continue
-		}
-		// ignore high LBTC swap-in premium
-		if peer.PeerPremium.Rates[2].PremiumRatePpm > config.Config.AutoSwapPremiumLimit {
-			continue
+		// assets the peer accepts within our premium limit
+		premiums := make(map[string]int64)
+		peerExcluded := ""
+
+		switch {
+		case len(balances) == 0:
+			peerExcluded = "no L-BTC or BTC balance above threshold amount"
+		case !peer.SwapsAllowed:
+			peerExcluded = "swaps with peer disabled"
+		default:
+			for asset := range balances {
+				if !stringIsInSlice(asset, peer.SupportedAssets) {
+					peerExcluded = "peer does not support " + asset
+					continue
+				}
+				premium, _ := peerPremiumRate(peer, swapAssetType(asset), peerswaprpc.OperationType_SWAP_IN)
+				if premium > config.Config.AutoSwapPremiumLimit {
+					peerExcluded = fmt.Sprintf("%s premium %d above limit %d", asset, premium, config.Config.AutoSwapPremiumLimit)
+					continue
+				}
+				premiums[asset] = premium
+			}
+			if len(premiums) > 0 {
+				peerExcluded = ""
+			}
 		}
 
 		for _, channel := range peer.Channels {
-			// ignore if there was an opposite peerswap
-			if !channel.Active || lastWasSwapOut[channel.ChannelId] {
+			rank := &AutoSwapRank{
+				AutoSwapParams: AutoSwapParams{
+					PeerAlias: getNodeAlias(peer.NodeId),
+					PeerId:    peer.NodeId,
+					ChannelId: channel.ChannelId,
+				},
+				Direction: "in",
+			}
+			ranks = append(ranks, rank)
+
+			switch {
+			case peerExcluded != "":
+				rank.Excluded = peerExcluded
+			case !channel.Active:
+				rank.Excluded = "channel inactive"
+			case busy[channel.ChannelId]:
+				rank.Excluded = "swap in progress"
+			case lastWasSwapOut[channel.ChannelId]:
+				// ignore if there was an opposite peerswap
+				rank.Excluded = "last swap was out"
+			case autoSwapBlocked(peer.NodeId, channel.ChannelId):
+				rank.Excluded = "on hold after failure"
+			}
+			if rank.Excluded != "" {
 				continue
 			}
 
@@ -1413,6 +1620,7 @@ func findSwapInCandidate(candidate *AutoSwapParams) error {
 			targetBalance = min(targetBalance, chanInfo.Capacity*99/100)
 
 			if targetBalance < channel.LocalBalance {
+				rank.Excluded = fmt.Sprintf("local balance above target %d%%", config.Config.AutoSwapTargetPct)
 				continue
 			}
 
@@ -1421,203 +1629,303 @@ func findSwapInCandidate(candidate *AutoSwapParams) error {
 				lastSwapTimestamp = swapTimestamps[channel.ChannelId]
 			}
 
-			// only consider sink channels (net routing > 1k)
 			stats := ln.GetChannelStats(channel.ChannelId, uint64(lastSwapTimestamp))
-			if stats.RoutedOut-stats.RoutedIn <= 1000 {
-				continue
+			if stats.RoutedOut > 100_000 { // ignore insignificant volume
+				rank.RoutingPpm = stats.FeeSat * 1_000_000 / stats.RoutedOut
 			}
 
-			swapAmount := targetBalance - channel.LocalBalance
+			// only consider sink channels (net routing > 1k)
+			if stats.RoutedOut <= stats.RoutedIn+1000 {
+				rank.Excluded = "not a sink: RoutedOut-RoutedIn <= 1000"
+				continue
+			}
 
 			// limit to peer's max HTLC setting and remote balance less reserve for LN fee
-			swapAmount = min(swapAmount, chanInfo.PeerMaxHtlc, channel.RemoteBalance-1000, config.Config.AutoSwapMaxAmount)
+			maxAmount := min(targetBalance-channel.LocalBalance, chanInfo.PeerMaxHtlc, max(channel.RemoteBalance, 1000)-1000, config.Config.AutoSwapMaxAmount)
 
-			// only consider channels with enough remote balance
-			if swapAmount >= minAmount {
-				ppm := uint64(0)
-				if stats.RoutedOut > 100_000 { // ignore insignificant volume
-					ppm = stats.FeeSat * 1_000_000 / stats.RoutedOut
+			// choose the asset with the lowest cost per swapped sat
+			for a, premium := range premiums {
+				amount := min(maxAmount, balances[a])
+				if amount < minAmount {
+					continue
 				}
-
-				// aim to maximize PPM
-				// if ppm ties, choose the candidate with larger potential swap amount
-				if ppm > minPPM || ppm == minPPM && swapAmount > candidate.Amount {
-					// set the candidate's PPM as the new target to beat
-					minPPM = ppm
-					// save the candidate
-					candidate.ChannelId = channel.ChannelId
-					candidate.PeerId = peer.NodeId
-					candidate.PeerAlias = getNodeAlias(peer.NodeId)
-					// set maximum possible amount
-					candidate.Amount = swapAmount
-					candidate.RoutingPpm = ppm
+				c := premium*int64(amount)/1_000_000 + txCost[a]
+				if rank.Asset == "" || c*int64(rank.Amount) < rank.Cost*int64(amount) || c*int64(rank.Amount) == rank.Cost*int64(amount) && a == "lbtc" {
+					rank.Asset, rank.Amount, rank.Cost = a, amount, c
+					rank.PremiumRatePpm = premium
 				}
 			}
+
+			// only consider channels with enough remote balance
+			if rank.Asset == "" {
+				rank.Amount = maxAmount
+				rank.Excluded = "amount below minimum " + formatWithThousandSeparators(minAmount)
+			}
 		}
 	}
-	return nil
+
+	selectAutoSwap(ranks, config.Config.AutoSwapThresholdPPM)
+
+	return ranks, nil
 }
 
 func executeAutoSwap() {
+	room := autoSwapAllowance()
+	if room < 100_000 {
+		return
+	}
+
+	var candidate AutoSwapParams
+
+	if err := findSwapInCandidate(&candidate); err != nil {
+		// some error prevented candidate finding
+		return
+	}
+
+	// no suitable candidates were found
+	if candidate.Amount == 0 {
+		return
+	}
+
+	amount := min(candidate.Amount, room)
+	if amount < candidate.Amount {
+		candidate.Cost -= candidate.PremiumRatePpm * int64(candidate.Amount-amount) / 1_000_000
+		candidate.Amount = amount
+	}
+
 	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
 	if err != nil {
 		return
 	}
 	defer cleanup()
 
-	res2, err := ps.ListActiveSwaps(client)
+	// execute swap with 0 premium limit
+	swapId, err := ps.SwapIn(client, amount, candidate.ChannelId, candidate.Asset, false, 0)
 	if err != nil {
+		log.Println("AutoSwap error:", err)
+		autoSwapError("in", &candidate, swapId, err)
 		return
 	}
 
-	activeSwaps := res2.GetSwaps()
+	addAutoSwapInFlight(swapId, "in", candidate)
 
-	if len(activeSwaps) > 0 {
-		// cannot have active swaps pending to initiate auto swap
-		return
-	}
+	assetName := assetDisplayName(candidate.Asset)
 
-	if autoSwapId != "" { // means autoswap is pending
-		disable := false
-		// no active swaps means completed or failed
-		// check the state
-		res, err := ps.GetSwap(client, autoSwapId)
-		if err != nil {
-			log.Println("GetSwap:", err)
-			// someting is wrong
-			disable = true
-		} else {
-			if res.GetSwap().State == "State_ClaimedPreimage" {
-				log.Println("AutoSwap complete")
-			} else {
-				log.Println("AutoSwap failed")
-				// to avoid paying more fees
-				disable = true
-			}
-		}
+	// Log swap id
+	log.Println("Initiated Auto Swap-In, id: "+swapId+", Peer: "+candidate.PeerAlias+", "+assetName+" Amount: "+formatWithThousandSeparators(amount)+", Channel's PPM: ", formatWithThousandSeparators(candidate.RoutingPpm), ", Est. Cost: ", formatSigned(candidate.Cost))
 
-		if disable {
-			// disable auto swap
-			config.Config.AutoSwapEnabled = false
-			config.Save()
-			log.Println("Automatic swap-ins Disabled")
-		}
+	// Send telegram
+	telegramSendMessage("🤖 Initiated Auto Swap-In with " + candidate.PeerAlias + " for " + formatWithThousandSeparators(amount) + " " + assetName + " sats. Channel's PPM: " + formatWithThousandSeparators(candidate.RoutingPpm) + ". Est. cost: " + formatSigned(candidate.Cost))
+}
 
-		// stop following
-		autoSwapId = ""
-		return
+// initiates a manual swap, either from or to must be "ln"
+// returns swap id
+func startSwap(nodeId string, channelId, swapAmount uint64, from, to string, premiumLimit int64) (string, error) {
+	asset := from
+	direction := "in"
+	if asset == "ln" {
+		asset = to
+		direction = "out"
+	}
+	if asset == "ln" || from != "ln" && to != "ln" {
+		return "", errors.New("invalid combination of assets")
 	}
 
-	satAmount := getUnlockedLbtcBalance()
-
-	if satAmount < config.Config.AutoSwapThresholdAmount {
-		return
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		return "", err
 	}
+	defer cleanup()
 
-	var candidate AutoSwapParams
+	var id string
 
-	if err := findSwapInCandidate(&candidate); err != nil {
-		// some error prevented candidate finding
-		return
+	switch direction {
+	case "in":
+		id, err = ps.SwapIn(client, swapAmount, channelId, asset, false, premiumLimit)
+	case "out":
+		id, err = ps.SwapOut(client, swapAmount, channelId, asset, false, premiumLimit)
 	}
 
-	amount := candidate.Amount
+	if err != nil {
+		e := err.Error()
+		if e == "Request timed out" || strings.Contains(e, "rpc timeout reached") {
+			// sometimes the swap is pending anyway
+			res, er := ps.ListActiveSwaps(client)
+			if er != nil {
+				log.Println("ListActiveSwaps:", er)
+				return "", er
+			}
+			activeSwaps := res.GetSwaps()
+			if len(activeSwaps) != 1 {
+				// return the original error
+				log.Println("doSwap:", err)
+				return "", err
+			}
+			// follow this id
+			id = activeSwaps[0].Id
+		} else {
+			log.Println("doSwap:", err)
+			return "", err
+		}
+	}
 
-	// no suitable candidates were found
-	if amount == 0 {
-		return
+	// delete peer balance information
+	if asset == "btc" {
+		if ln.BitcoinBalances != nil {
+			delete(ln.BitcoinBalances, nodeId)
+		}
+	} else {
+		if ln.LiquidBalances != nil {
+			delete(ln.LiquidBalances, nodeId)
+		}
 	}
 
-	amount = min(amount, satAmount-uint64(SwapLbtcDustReserve))
+	return id, nil
+}
 
-	// execute swap with 0 premium limit
-	autoSwapId, err = ps.SwapIn(client, amount, candidate.ChannelId, "lbtc", false, 0)
+// sets channel's fee rate and logs it as a manual change
+func setChannelFeeRate(peerNodeId string, channelId uint64, feeRate int64, inbound bool) error {
+	if inbound {
+		if !ln.HasInboundFees() {
+			// CLN and LND < 0.18 cannot set inbound fees
+			return errors.New("inbound fees are not allowed by your LN backend")
+		}
+
+		if feeRate > 0 {
+			// Only discounts are allowed for now
+			return errors.New("inbound fee rate cannot be positive")
+		}
+	} else {
+		if feeRate < 0 {
+			return errors.New("outbound fee rate cannot be negative")
+		}
+	}
+
+	oldRate, err := ln.SetFeeRate(peerNodeId, channelId, feeRate, inbound, false)
 	if err != nil {
-		log.Println("AutoSwap error:", err)
-		return
+		return err
 	}
 
-	// Log swap id
-	log.Println("Initiated Auto Swap-In, id: "+autoSwapId+", Peer: "+candidate.PeerAlias+", L-BTC Amount: "+formatWithThousandSeparators(amount)+", Channel's PPM: ", formatWithThousandSeparators(candidate.RoutingPpm))
+	// log change
+	ln.LogFee(channelId, oldRate, int(feeRate), inbound, true)
 
-	// Send telegram
-	telegramSendMessage("🤖 Initiated Auto Swap-In with " + candidate.PeerAlias + " for " + formatWithThousandSeparators(amount) + " Liquid sats. Channel's PPM: " + formatWithThousandSeparators(candidate.RoutingPpm))
+	return nil
 }
 
-// total cost, verbal breakdown, new changes to persist
-func swapCost(swap *peerswaprpc.PrettyPrintSwap) (int64, string, bool) {
+// profits of swaps with a peer by type and role, negative means cost
+func peerSwapProfits(swaps []*peerswaprpc.PrettyPrintSwap, id string) (senderIn, senderOut, receiverIn, receiverOut int64) {
+	for _, swap := range swaps {
+		switch swap.Type + swap.Role {
+		case "swap-insender":
+			if swap.PeerNodeId == id {
+				cost, _ := swapCost(swap)
+				senderIn -= cost
+			}
+		case "swap-outsender":
+			if swap.PeerNodeId == id {
+				cost, _ := swapCost(swap)
+				senderOut -= cost
+			}
+		case "swap-outreceiver":
+			if swap.InitiatorNodeId == id {
+				cost, _ := swapCost(swap)
+				receiverOut -= cost
+			}
+		case "swap-inreceiver":
+			if swap.InitiatorNodeId == id {
+				cost, _ := swapCost(swap)
+				receiverIn -= cost
+			}
+		}
+	}
+
+	return
+}
+
+// total cost, verbal breakdown
+func swapCost(swap *peerswaprpc.PrettyPrintSwap) (int64, string) {
 	if swap == nil {
-		return 0, "", false
+		return 0, ""
 	}
 
-	fee := int64(0)
+	e := swapCostItems(swap)
+	_, hasRebate := ln.SwapRebates[swap.Id]
 	breakdown := ""
-	newChanges := false
 
-	if swap.PremiumAmount < 0 {
-		fee = -swap.PremiumAmount
-		breakdown = fmt.Sprintf("premium paid: %s, ", formatSigned(-swap.PremiumAmount))
-	} else if swap.PremiumAmount > 0 {
-		fee = -swap.PremiumAmount
-		breakdown = fmt.Sprintf("premium received: %s, ", formatSigned(swap.PremiumAmount))
+	if e.Premium > 0 {
+		breakdown = fmt.Sprintf("premium paid: %s, ", formatSigned(e.Premium))
+	} else if e.Premium < 0 {
+		breakdown = fmt.Sprintf("premium received: %s, ", formatSigned(-e.Premium))
 	}
 
 	switch swap.Type + swap.Role {
 	case "swap-outsender":
-		rebate, exists := ln.SwapRebates[swap.Id]
-		if exists {
-			breakdown += fmt.Sprintf("rebate paid: %s", formatSigned(rebate))
-			fee += rebate
+		if hasRebate {
+			breakdown += fmt.Sprintf("rebate paid: %s", formatSigned(e.Rebate))
 		}
-		claim, new := onchainTxFee(swap.Asset, swap.ClaimTxId)
-		if claim > 0 {
-			newChanges = newChanges || new
-			fee += claim
-			breakdown += fmt.Sprintf(", claim cost: %s", formatSigned(claim))
+		if e.Claim > 0 {
+			breakdown += fmt.Sprintf(", claim cost: %s", formatSigned(e.Claim))
 		}
 
 	case "swap-outreceiver":
-		rebate, exists := ln.SwapRebates[swap.Id]
-		if exists {
-			fee -= rebate
-			breakdown += fmt.Sprintf("rebate received: %s, ", formatSigned(rebate))
+		if hasRebate {
+			breakdown += fmt.Sprintf("rebate received: %s, ", formatSigned(-e.Rebate))
 		}
 		fallthrough
 
 	case "swap-insender":
-		chainFee, new := onchainTxFee(swap.Asset, swap.OpeningTxId)
-		newChanges = newChanges || new
-		fee += chainFee
-		breakdown += fmt.Sprintf("opening cost: %s", formatSigned(chainFee))
+		breakdown += fmt.Sprintf("opening cost: %s", formatSigned(e.Opening))
+		if e.Claim > 0 {
+			breakdown += fmt.Sprintf(", claim cost: %s", formatSigned(e.Claim))
+		}
+
+	case "swap-inreceiver":
+		breakdown += fmt.Sprintf("claim cost: %s", formatSigned(e.Claim))
+	}
+
+	return e.Cost, breakdown
+}
+
+// splits the cost of a swap into premium, rebate and on-chain fees
+func swapCostItems(swap *peerswaprpc.PrettyPrintSwap) *db.SwapLedgerEntry {
+	e := &db.SwapLedgerEntry{
+		// negative PremiumAmount is paid by us
+		Premium: -swap.PremiumAmount,
+	}
+
+	switch swap.Type + swap.Role {
+	case "swap-outsender":
+		e.Rebate = ln.SwapRebates[swap.Id]
+		e.Claim = onchainTxFee(swap.Asset, swap.ClaimTxId)
+
+	case "swap-outreceiver":
+		e.Rebate = -ln.SwapRebates[swap.Id]
+		fallthrough
+
+	case "swap-insender":
+		e.Opening = onchainTxFee(swap.Asset, swap.OpeningTxId)
 		if swap.State == "State_ClaimedCoop" || swap.State == "State_ClaimedCsv" {
-			claim, new := onchainTxFee(swap.Asset, swap.ClaimTxId)
-			if claim > 0 {
-				newChanges = newChanges || new
-				fee += claim
-				breakdown += fmt.Sprintf(", claim cost: %s", formatSigned(claim))
-			}
+			e.Claim = onchainTxFee(swap.Asset, swap.ClaimTxId)
 		}
 
 	case "swap-inreceiver":
-		chainFee, new := onchainTxFee(swap.Asset, swap.ClaimTxId)
-		newChanges = newChanges || new
-		fee += chainFee
-		breakdown += fmt.Sprintf("claim cost: %s", formatSigned(chainFee))
+		e.Claim = onchainTxFee(swap.Asset, swap.ClaimTxId)
 	}
 
-	return fee, breakdown, newChanges
+	e.Cost = e.Premium + e.Rebate + e.Opening + e.Claim
+	return e
 }
 
 // get tx fee from cache or online
-func onchainTxFee(asset, txId string) (int64, bool) {
+func onchainTxFee(asset, txId string) int64 {
 	if txId == "" {
-		return 0, false
+		return 0
 	}
 
 	// try cache
 	fee, exists := txFee[txId]
 	if exists {
-		return fee, false
+		return fee
 	}
 
 	switch asset {
@@ -1640,13 +1948,12 @@ func onchainTxFee(asset, txId string) (int64, bool) {
 
 	}
 
-	save := false
-	// save to cache
+	// save to cache and db
 	if fee > 0 {
 		txFee[txId] = fee
-		save = true
+		db.SaveTxFee(txId, fee)
 	}
-	return fee, save
+	return fee
 }
 
 func showRestartScreen(w http.ResponseWriter, r *http.Request, enableHTTPS bool, password string, exit bool) {
@@ -1706,6 +2013,7 @@ func showRestartScreen(w http.ResponseWriter, r *http.Request, enableHTTPS bool,
 	if exit {
 		log.Println("Restart requested, stopping PSWeb.")
 		// assume systemd will restart it
+		db.Close()
 		os.Exit(0)
 	}
 }
@@ -9,25 +9,47 @@ import (
 // Middleware to check authentication
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if config.Config.SecureConnection && !strings.HasPrefix(r.RequestURI, "/downloadca") {
-			if r.TLS != nil {
-				// Check client certificate
-				if len(r.TLS.PeerCertificates) == 0 {
-					if config.Config.Password != "" {
-						if !isAuthenticated(r) {
-							if !strings.HasPrefix(r.RequestURI, "/static/") && !strings.HasPrefix(r.RequestURI, "/login") {
-								http.Redirect(w, r, "/login", http.StatusFound)
-								return
-							}
-						}
-					} else {
-						http.Error(w, "Client certificate not provided", http.StatusForbidden)
+		// API token takes precedence and is limited by its scope,
+		// whether or not the connection is secure
+		if token, ok := bearerToken(r); ok {
+			scope, valid := checkApiToken(token)
+			if !valid {
+				http.Error(w, "Invalid API token", http.StatusUnauthorized)
+				return
+			}
+			if requiredScope(r) > scope {
+				http.Error(w, "API token scope does not allow this action", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the TLS handshake accepts connections without a client certificate,
+		// so everything but the token path must be checked here, no exemptions
+		if config.Config.SecureConnection {
+			if r.TLS == nil {
+				http.Error(w, "Requires TLS connection", http.StatusForbidden)
+				return
+			}
+			// chains are only set for a certificate signed by our CA
+			if len(r.TLS.VerifiedChains) == 0 {
+				if config.Config.Password == "" {
+					http.Error(w, "Client certificate not provided", http.StatusForbidden)
+					return
+				}
+				if !isAuthenticated(r) {
+					if strings.HasPrefix(r.RequestURI, API_PREFIX) {
+						// scripts cannot follow the login form
+						http.Error(w, "Unauthorized", http.StatusUnauthorized)
+						return
+					}
+					// password mode needs the login form and its styles
+					if !strings.HasPrefix(r.RequestURI, "/static/") && !strings.HasPrefix(r.RequestURI, "/login") {
+						http.Redirect(w, r, "/login", http.StatusFound)
 						return
 					}
 				}
-			} else {
-				http.Error(w, "Requires TLS connection", http.StatusForbidden)
-				return
 			}
 		}
 
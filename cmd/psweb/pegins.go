@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"peerswap-web/cmd/psweb/bitcoin"
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/ln"
+)
+
+// moves the single peg-in of older versions from config to db,
+// must run before ln.LoadDB to keep its ClaimJoin
+func migrateLegacyPegin() {
+	if config.Config.PeginTxId == "" {
+		return
+	}
+
+	p := &db.Pegin{
+		TimeStamp:     time.Now().Unix(),
+		TxId:          config.Config.PeginTxId,
+		ClaimScript:   config.Config.PeginClaimScript,
+		Address:       config.Config.PeginAddress,
+		Amount:        config.Config.PeginAmount,
+		FeeRate:       config.Config.PeginFeeRate,
+		ClaimJoin:     config.Config.PeginClaimJoin,
+		Confirmations: -1,
+		Status:        "pending",
+	}
+
+	if config.Config.PeginReplacedTxId != "" {
+		p.ReplacedTxIds = []string{config.Config.PeginReplacedTxId}
+	}
+
+	if p.ClaimScript == "done" {
+		// claimed by ClaimJoin, PeginTxId was the Liquid txid
+		p.ClaimScript = ""
+		p.ClaimTxId = p.TxId
+		p.TxId = ""
+		p.Status = "claimed"
+	}
+
+	if err := db.SavePegin(p); err != nil {
+		log.Println("Cannot migrate pending peg-in:", err)
+		return
+	}
+
+	if p.ClaimJoin {
+		db.Save("ClaimJoin", "PeginId", p.Id)
+	}
+
+	config.Config.PeginTxId = ""
+	config.Config.PeginClaimScript = ""
+	config.Config.PeginReplacedTxId = ""
+	config.Config.PeginAddress = ""
+	config.Config.PeginAmount = 0
+	config.Config.PeginFeeRate = 0
+	config.Config.PeginClaimJoin = false
+	config.Save()
+
+	log.Println("Moved pending peg-in to db, id:", p.Id)
+}
+
+// peg-ins and withdrawals still followed by checkPegin, oldest first
+func activePegins() []*db.Pegin {
+	pegins, err := db.LoadPegins("")
+	if err != nil {
+		log.Println("Cannot load peg-ins:", err)
+	}
+
+	result := []*db.Pegin{}
+	for _, p := range pegins {
+		if p.Status == "pending" || p.Status == "claimed" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// true if a Liquid peg-in is in progress
+func hasPendingPegin() bool {
+	for _, p := range activePegins() {
+		if p.IsPegin() {
+			return true
+		}
+	}
+	return false
+}
+
+// the pending record by form's id, caller holds peginMutex
+func pendingPeginFromForm(r *http.Request) (*db.Pegin, error) {
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := db.LoadPegin(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if p == nil || p.Status != "pending" {
+		return nil, errors.New("no pending peg-in")
+	}
+
+	return p, nil
+}
+
+// amount the transaction pays to the address
+func peginOutputAmount(txId, address string) (int64, error) {
+	var tx bitcoin.Transaction
+	_, err := bitcoin.GetRawTransaction(txId, &tx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, out := range tx.Vout {
+		if out.ScriptPubKey.Address == address {
+			return int64(toSats(out.Value)), nil
+		}
+	}
+
+	return 0, errors.New("the tx fails to pay the pegin address")
+}
+
+// stops following the peg-in or withdrawal
+func cancelPegin(p *db.Pegin) error {
+	p.Status = "cancelled"
+	ln.ForgetClaimPegin(p.Id)
+	return db.SavePegin(p)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/internet"
+	"peerswap-web/cmd/psweb/liquid"
+	"peerswap-web/cmd/psweb/ln"
+	"peerswap-web/cmd/psweb/ps"
+
+	"github.com/elementsproject/peerswap/peerswaprpc"
+)
+
+// federation fee of 0.1% charged on Liquid mainnet peg-outs
+const PEGOUT_FEE_PPM = 1000
+
+var pegoutMutex sync.Mutex
+
+// reason the wallet cannot peg out, empty if it can
+func pegoutDisabled() string {
+	info, err := liquid.GetSidechainInfo()
+	if err != nil {
+		return "cannot query Elements: " + err.Error()
+	}
+	if info.EnforcePak {
+		// sendtomainchain needs a member's initpegoutwallet key
+		return "peg-outs on this chain are reserved to Liquid Federation members"
+	}
+	return ""
+}
+
+// peg-outs still followed by checkPegout, oldest first
+func activePegouts() []*db.Pegout {
+	pegouts, err := db.LoadPegouts("pending")
+	if err != nil {
+		log.Println("Cannot load peg-outs:", err)
+	}
+	if pegouts == nil {
+		pegouts = []*db.Pegout{}
+	}
+	return pegouts
+}
+
+// the pending record by form's id
+func pendingPegoutFromForm(r *http.Request) (*db.Pegout, error) {
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := db.LoadPegout(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if p == nil || p.Status != "pending" {
+		return nil, errors.New("no pending peg-out")
+	}
+
+	return p, nil
+}
+
+// "liquid", "payout", "confirming" or the record's status
+func pegoutStage(p *db.Pegout) string {
+	switch {
+	case p.Status != "pending":
+		return p.Status
+	case p.Confirmations <= 0:
+		// Liquid tx is not yet confirmed
+		return "liquid"
+	case p.PayoutTxId == "":
+		// awaiting the federation
+		return "payout"
+	}
+	return "confirming"
+}
+
+// human readable stage
+func pegoutStageText(p *db.Pegout) string {
+	switch pegoutStage(p) {
+	case "liquid":
+		return "Awaiting Liquid confirmation"
+	case "payout":
+		return fmt.Sprintf("Liquid tx has %d confirmations, awaiting federation payout", p.Confirmations)
+	case "confirming":
+		return "Bitcoin payout awaiting confirmation"
+	case "complete":
+		return "Complete"
+	}
+	return "Cancelled"
+}
+
+// Check peg-out status
+func checkPegout() {
+	if !pegoutMutex.TryLock() {
+		// another check is in progress
+		return
+	}
+	defer pegoutMutex.Unlock()
+
+	for _, p := range activePegouts() {
+		followPegout(p)
+	}
+}
+
+// advances one peg-out, saves the record when it changes
+func followPegout(p *db.Pegout) {
+	if p.TxId == "" {
+		// not sent or txid was lost
+		return
+	}
+
+	changed := false
+
+	confs := int32(-1)
+	if tx, err := liquid.GetTransaction(p.TxId); err == nil {
+		confs = tx.Confirmations
+	}
+
+	if confs != p.Confirmations {
+		if p.Confirmations <= 0 && confs > 0 {
+			recordOnchainCost("pegout", "lbtc", p.TxId)
+			telegramSendMessage("⏰ Peg-out Liquid tx confirmed, awaiting federation payout. TxId: `" + p.TxId + "`")
+			publishPegout(p, p.TxId, "payout")
+		}
+		p.Confirmations = confs
+		changed = true
+	}
+
+	if confs > 0 {
+		payoutTxId, payoutConfs := pegoutPayout(p)
+		if payoutTxId != "" && payoutTxId != p.PayoutTxId {
+			log.Println("Peg-out payout detected, txId:", payoutTxId)
+			publishPegout(p, payoutTxId, "confirming")
+			p.PayoutTxId = payoutTxId
+			changed = true
+		}
+		if payoutConfs != p.PayoutConfirmations {
+			p.PayoutConfirmations = payoutConfs
+			changed = true
+		}
+	}
+
+	if p.PayoutConfirmations > 0 {
+		if p.ToWallet {
+			labelTx("btc", p.PayoutTxId, "Liquid Peg-out payout")
+		}
+		log.Println("Peg-out complete, BTC txId:", p.PayoutTxId)
+		telegramSendMessage("💸 Peg-out complete! BTC TxId: `" + p.PayoutTxId + "`")
+		publishPegout(p, p.PayoutTxId, "complete")
+		p.Status = "complete"
+		changed = true
+	}
+
+	if changed {
+		db.SavePegout(p)
+	}
+}
+
+// federation's Bitcoin payout to the peg-out address and its confirmations
+func pegoutPayout(p *db.Pegout) (string, int32) {
+	if p.ToWallet {
+		cl, clean, err := ln.GetClient()
+		if err == nil {
+			defer clean()
+
+			var utxos []ln.UTXO
+			if ln.ListUnspent(cl, &utxos, 0) == nil {
+				for _, u := range utxos {
+					if u.Address == p.Address {
+						return u.TxidStr, int32(u.Confirmations)
+					}
+				}
+			}
+		}
+		if p.PayoutTxId != "" {
+			// spent already
+			return p.PayoutTxId, max(p.PayoutConfirmations, 1)
+		}
+		// never reveal own address to a third party
+		return "", 0
+	}
+
+	txId, height := internet.GetAddressPayment(p.Address)
+	if txId == "" || height == 0 {
+		return txId, 0
+	}
+	return txId, int32(ln.GetBlockHeight()) - height + 1
+}
+
+// sends L-BTC to the mainchain address and starts following the peg-out
+func startPegout(address string, amount int64, subtractFee bool) (*db.Pegout, error) {
+	if !db.IsOpen() {
+		// the record must persist to follow the payout
+		return nil, errors.New("database is not open")
+	}
+
+	if reason := pegoutDisabled(); reason != "" {
+		return nil, errors.New(reason)
+	}
+
+	toWallet := false
+	if address == "" {
+		// pay to own lightning wallet
+		var err error
+		address, err = ln.NewAddress()
+		if err != nil {
+			return nil, err
+		}
+		toWallet = true
+	}
+
+	if amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+
+	if uint64(amount) > getUnlockedLbtcBalance() {
+		return nil, errors.New("amount exceeds spendable L-BTC balance")
+	}
+
+	p := &db.Pegout{
+		TimeStamp:     time.Now().Unix(),
+		Address:       address,
+		Amount:        amount,
+		ToWallet:      toWallet,
+		Confirmations: -1,
+		Status:        "pending",
+	}
+
+	// persist before any funds are sent
+	if err := db.SavePegout(p); err != nil {
+		return nil, err
+	}
+
+	txId, err := liquid.SendToMainchain(address, uint64(amount), subtractFee)
+	if err != nil {
+		p.Status = "cancelled"
+		db.SavePegout(p)
+		return nil, err
+	}
+
+	p.TxId = txId
+	if err := db.SavePegout(p); err != nil {
+		log.Println("Cannot save peg-out", p.Id, "Liquid txId:", txId, "error:", err)
+		return nil, err
+	}
+
+	labelTx("lbtc", txId, "Liquid Peg-out")
+	log.Println("Peg-out started, Liquid txId:", txId)
+	telegramSendMessage("⏰ Started peg-out of " + formatWithThousandSeparators(uint64(amount)) + " sats. TxId: `" + txId + "`")
+	publishPegout(p, txId, "liquid")
+
+	return p, nil
+}
+
+// cost of moving L-BTC to the mainchain by one route, sats
+type PegoutRoute struct {
+	Name      string
+	PeerId    string
+	PeerAlias string
+	ChannelId uint64
+	// ppm, negative is a rebate
+	PremiumRate int64
+	Premium     int64
+	// estimated on-chain fee
+	Fee  int64
+	Cost int64
+	// reason the route cannot be used, empty if it can
+	Unavailable string
+}
+
+// peg-out versus a BTC swap-out followed by an L-BTC swap-in
+// on the same or another channel to restore outbound liquidity
+type PegoutComparison struct {
+	Amount  int64
+	Pegout  PegoutRoute
+	SwapOut PegoutRoute
+	SwapIn  PegoutRoute
+	// both swaps
+	SwapCost int64
+	// "pegout", "swaps" or empty if neither is possible
+	Cheapest string
+}
+
+// estimates the cost of each route for the amount
+func comparePegout(amount int64) (*PegoutComparison, error) {
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	res, err := ps.ListPeers(client)
+	if err != nil {
+		return nil, err
+	}
+	peers := res.GetPeers()
+
+	c := &PegoutComparison{
+		Amount:   amount,
+		Pegout:   PegoutRoute{Name: "Peg-out"},
+		Cheapest: "pegout",
+	}
+
+	// explicit Liquid tx, estimated like a swap opening
+	c.Pegout.Fee = openingTxCost("lbtc")
+	c.Pegout.PremiumRate = PEGOUT_FEE_PPM
+	c.Pegout.Premium = amount * PEGOUT_FEE_PPM / 1_000_000
+	c.Pegout.Cost = c.Pegout.Premium + c.Pegout.Fee
+
+	if reason := pegoutDisabled(); reason != "" {
+		c.Pegout.Unavailable = reason
+	} else if uint64(amount) > getUnlockedLbtcBalance() {
+		c.Pegout.Unavailable = "amount exceeds spendable L-BTC balance"
+	}
+
+	c.SwapOut = bestSwapRoute(peers, amount, "btc", peerswaprpc.OperationType_SWAP_OUT, 0)
+	c.SwapIn = bestSwapRoute(peers, amount, "lbtc", peerswaprpc.OperationType_SWAP_IN, c.SwapOut.ChannelId)
+	c.SwapCost = c.SwapOut.Cost + c.SwapIn.Cost
+
+	swapsOk := c.SwapOut.Unavailable == "" && c.SwapIn.Unavailable == ""
+	switch {
+	case swapsOk && (c.Pegout.Unavailable != "" || c.SwapCost < c.Pegout.Cost):
+		c.Cheapest = "swaps"
+	case c.Pegout.Unavailable != "":
+		c.Cheapest = ""
+	}
+
+	return c, nil
+}
+
+// cheapest channel to swap the amount of the asset, the one that
+// the previous swap has made room in qualifies regardless of balance
+func bestSwapRoute(peers []*peerswaprpc.PeerSwapPeer, amount int64, asset string, operation peerswaprpc.OperationType, freedChannelId uint64) PegoutRoute {
+	name := assetDisplayName(asset) + " swap-out"
+	if operation == peerswaprpc.OperationType_SWAP_IN {
+		name = assetDisplayName(asset) + " swap-in"
+	}
+
+	best := PegoutRoute{
+		Name:        name,
+		Unavailable: "no channel with " + formatWithThousandSeparators(uint64(amount)) + " sats of liquidity and advertised " + assetDisplayName(asset) + " premium",
+	}
+
+	if asset == "btc" && !config.Config.BitcoinSwaps {
+		best.Unavailable = "BTC swaps disabled"
+		return best
+	}
+
+	fee := openingTxCost(asset)
+	found := false
+
+	for _, peer := range peers {
+		if !peer.SwapsAllowed || !stringIsInSlice(asset, peer.SupportedAssets) {
+			continue
+		}
+
+		rate, ok := peerPremiumRate(peer, swapAssetType(asset), operation)
+		if !ok {
+			continue
+		}
+
+		for _, channel := range peer.Channels {
+			if !channel.Active {
+				continue
+			}
+
+			balance := channel.LocalBalance
+			if operation == peerswaprpc.OperationType_SWAP_IN {
+				balance = channel.RemoteBalance
+			}
+			if balance < uint64(amount) && channel.ChannelId != freedChannelId {
+				continue
+			}
+
+			premium := rate * amount / 1_000_000
+			if found && premium+fee >= best.Cost {
+				continue
+			}
+
+			found = true
+			best = PegoutRoute{
+				Name:        name,
+				PeerId:      peer.NodeId,
+				PeerAlias:   getNodeAlias(peer.NodeId),
+				ChannelId:   channel.ChannelId,
+				PremiumRate: rate,
+				Premium:     premium,
+				Fee:         fee,
+				Cost:        premium + fee,
+			}
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/events"
+	"peerswap-web/cmd/psweb/ln"
+	"peerswap-web/cmd/psweb/ps"
+	"strings"
+	"time"
+
+	"github.com/elementsproject/peerswap/peerswaprpc"
+)
+
+var (
+	// last known state of active swaps
+	swapStates = make(map[string]string)
+	// last published ClaimJoin status
+	claimStatus = ""
+)
+
+// Server-Sent Events stream
+// optional ?types=swap,fee to filter
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	filter := make(map[string]bool)
+	if t := r.URL.Query().Get("types"); t != "" {
+		for _, s := range strings.Split(t, ",") {
+			filter[strings.TrimSpace(s)] = true
+		}
+	}
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// disable proxy buffering
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	// keep connection alive through proxies
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if len(filter) > 0 && !filter[e.Type] {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Println("eventsHandler:", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// polls for state changes while somebody listens
+func watchEvents() {
+	for range time.Tick(2 * time.Second) {
+		if !events.HasSubscribers() {
+			continue
+		}
+
+		if ln.ClaimStatus != claimStatus {
+			claimStatus = ln.ClaimStatus
+			events.Publish(events.CLAIMJOIN, &events.ClaimJoin{
+				Status: claimStatus,
+				Role:   ln.MyRole,
+			})
+		}
+
+		pollSwapStates()
+	}
+}
+
+// publishes swap state transitions
+func pollSwapStates() {
+	client, cleanup, err := ps.GetClient(config.Config.RpcHost)
+	if err != nil {
+		return
+	}
+	defer cleanup()
+
+	res, err := ps.ListActiveSwaps(client)
+	if err != nil {
+		return
+	}
+
+	active := make(map[string]bool)
+	for _, swap := range res.GetSwaps() {
+		active[swap.Id] = true
+		if oldState := swapStates[swap.Id]; oldState != swap.State {
+			swapStates[swap.Id] = swap.State
+			publishSwap(swap, oldState)
+		}
+	}
+
+	// swaps that are no longer active reached their final state
+	for id, oldState := range swapStates {
+		if active[id] {
+			continue
+		}
+		delete(swapStates, id)
+		res, err := ps.GetSwap(client, id)
+		if err != nil {
+			continue
+		}
+		swap := res.GetSwap()
+		publishSwap(swap, oldState)
+	}
+}
+
+func publishSwap(swap *peerswaprpc.PrettyPrintSwap, oldState string) {
+	events.Publish(events.SWAP, &events.Swap{
+		Id:        swap.Id,
+		Asset:     swap.Asset,
+		Type:      swap.Type,
+		Role:      swap.Role,
+		PeerId:    swap.PeerNodeId,
+		Amount:    swap.Amount,
+		OldState:  oldState,
+		State:     swap.State,
+		ChannelId: swap.LndChanId,
+	})
+}
+
+// confs = -1 when unknown
+func publishPegin(p *db.Pegin, txId string, confs int32, status string) {
+	events.Publish(events.PEGIN, &events.Pegin{
+		Id:            p.Id,
+		TxId:          txId,
+		Confirmations: confs,
+		IsPegin:       p.IsPegin(),
+		Status:        status,
+	})
+}
+
+func publishPegout(p *db.Pegout, txId string, status string) {
+	events.Publish(events.PEGOUT, &events.Pegout{
+		Id:     p.Id,
+		TxId:   txId,
+		Status: status,
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
+	"peerswap-web/cmd/psweb/liquid"
+	"peerswap-web/cmd/psweb/ln"
+	"time"
+)
+
+const stateManifest = "manifest.json"
+
+// files copied as is when moving to a new host
+var stateFiles = []string{"pswebconfig.json", "CA.crt", "CA.key", "server.crt", "server.key"}
+
+// describes the origin of a state archive
+type StateManifest struct {
+	Version        string
+	Implementation string
+	Chain          string
+	NodeId         string
+	SchemaVersion  uint64
+	Created        int64
+	Files          []string
+}
+
+// zips config, database and TLS files, encrypted with Elements RPC password
+func exportState() (string, error) {
+	if config.Config.ElementsPass == "" {
+		return "", errors.New("elements password is required to encrypt the archive")
+	}
+
+	files := make(map[string][]byte)
+
+	for _, name := range stateFiles {
+		data, err := os.ReadFile(filepath.Join(config.Config.DataDir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// TLS may be disabled
+				continue
+			}
+			return "", err
+		}
+		files[name] = data
+	}
+
+	var buf bytes.Buffer
+	if err := db.Snapshot(&buf); err != nil {
+		return "", err
+	}
+	files["psweb.db"] = buf.Bytes()
+
+	manifest := StateManifest{
+		Version:        VERSION,
+		Implementation: ln.IMPLEMENTATION,
+		Chain:          config.Config.Chain,
+		NodeId:         ln.MyNodeId,
+		SchemaVersion:  db.SchemaVersion(),
+		Created:        time.Now().Unix(),
+	}
+	for name := range files {
+		manifest.Files = append(manifest.Files, name)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	files[stateManifest] = data
+
+	fileName := time.Now().Format("2006-01-02") + "_psweb_state.zip"
+	err = liquid.ZipEncrypted(filepath.Join(config.Config.DataDir, fileName), config.Config.ElementsPass, files)
+	if err != nil {
+		return "", err
+	}
+
+	return fileName, nil
+}
+
+// validates the archive and restores its files, returns imported config
+func importState(fileName, password string) (*config.Configuration, error) {
+	files, err := liquid.UnzipEncrypted(fileName, password)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest StateManifest
+	data, ok := files[stateManifest]
+	if !ok {
+		return nil, errors.New("not a PSWeb state archive")
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+
+	if manifest.Implementation != ln.IMPLEMENTATION {
+		return nil, fmt.Errorf("archive is from %s, this is %s", manifest.Implementation, ln.IMPLEMENTATION)
+	}
+	if manifest.Chain != config.Config.Chain {
+		return nil, fmt.Errorf("archive is for %s, this node runs on %s", manifest.Chain, config.Config.Chain)
+	}
+	if ln.MyNodeId == "" {
+		return nil, errors.New("lightning node id is unknown yet, try again later")
+	}
+	if manifest.NodeId != ln.MyNodeId {
+		return nil, fmt.Errorf("archive belongs to node %s", manifest.NodeId)
+	}
+	if manifest.SchemaVersion > db.SchemaVersion() {
+		return nil, fmt.Errorf("%w, please upgrade first", db.ErrNewerSchema)
+	}
+
+	var cfg config.Configuration
+	data, ok = files["pswebconfig.json"]
+	if !ok {
+		return nil, errors.New("archive has no pswebconfig.json")
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	data, ok = files["psweb.db"]
+	if !ok {
+		return nil, errors.New("archive has no psweb.db")
+	}
+
+	// stage every file next to the live one before touching it
+	var staged []string
+	defer func() {
+		// leftovers of a failed import
+		for _, name := range staged {
+			os.Remove(name)
+		}
+	}()
+
+	stage := func(name string, data []byte) (string, error) {
+		path := filepath.Join(config.Config.DataDir, name+".import")
+		staged = append(staged, path)
+		return path, os.WriteFile(path, data, 0600)
+	}
+
+	tmpDb, err := stage("psweb.db", data)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.CheckFile(tmpDb); err != nil {
+		return nil, err
+	}
+
+	tlsFiles := make(map[string]string)
+	for _, name := range stateFiles {
+		data, ok := files[name]
+		if !ok || name == "pswebconfig.json" {
+			// config is saved by the caller
+			continue
+		}
+		if tlsFiles[name], err = stage(name, data); err != nil {
+			return nil, err
+		}
+	}
+
+	// TLS files are read on restart only, the old ones are kept
+	// until the database is swapped too
+	var installed []string
+	restoreTLS := func() {
+		for _, name := range installed {
+			live := filepath.Join(config.Config.DataDir, name)
+			if _, e := os.Stat(live + ".bak"); e == nil {
+				e = os.Rename(live+".bak", live)
+				if e != nil {
+					log.Println("Cannot restore", name+":", e)
+				}
+			} else {
+				os.Remove(live)
+			}
+		}
+	}
+
+	for name, path := range tlsFiles {
+		live := filepath.Join(config.Config.DataDir, name)
+		os.Remove(live + ".bak")
+		if err = os.Rename(live, live+".bak"); err != nil && !os.IsNotExist(err) {
+			restoreTLS()
+			return nil, err
+		}
+		installed = append(installed, name)
+		if err = os.Rename(path, live); err != nil {
+			restoreTLS()
+			return nil, err
+		}
+	}
+
+	// the running state is replaced last
+	db.Close()
+	if err = os.Rename(tmpDb, filepath.Join(config.Config.DataDir, "psweb.db")); err != nil {
+		restoreTLS()
+		if e := db.Open(); e != nil {
+			log.Println("Cannot reopen database:", e)
+		}
+		return nil, err
+	}
+
+	for _, name := range installed {
+		os.Remove(filepath.Join(config.Config.DataDir, name+".bak"))
+	}
+
+	keepHostSettings(&cfg)
+
+	return &cfg, nil
+}
+
+// paths, RPC and listener settings belong to this host, not to the archive
+func keepHostSettings(cfg *config.Configuration) {
+	local := &config.Config
+
+	cfg.DataDir = local.DataDir
+	cfg.RpcHost = local.RpcHost
+	cfg.ListenPort = local.ListenPort
+	cfg.LocalMempool = local.LocalMempool
+	cfg.ProxyURL = local.ProxyURL
+
+	cfg.ElementsUser = local.ElementsUser
+	cfg.ElementsPass = local.ElementsPass
+	cfg.ElementsDir = local.ElementsDir
+	cfg.ElementsDirMapped = local.ElementsDirMapped
+	cfg.ElementsHost = local.ElementsHost
+	cfg.ElementsPort = local.ElementsPort
+	cfg.ElementsWallet = local.ElementsWallet
+
+	cfg.LightningDir = local.LightningDir
+	cfg.BitcoinHost = local.BitcoinHost
+	cfg.BitcoinUser = local.BitcoinUser
+	cfg.BitcoinPass = local.BitcoinPass
+
+	cfg.ServerIPs = local.ServerIPs
+	cfg.SecurePort = local.SecurePort
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"peerswap-web/cmd/psweb/config"
+	"peerswap-web/cmd/psweb/db"
 	"peerswap-web/cmd/psweb/ln"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -89,54 +90,36 @@ func telegramStart() {
 				telegramConnect()
 			case "/backup":
 				liquidBackup(true)
+			case "/export":
+				fileName, err := exportState()
+				if err != nil {
+					telegramSendMessage("❗ State export failed: " + err.Error())
+					break
+				}
+				if err = telegramSendFile(config.Config.DataDir, fileName, "🗄️ PSWeb state, password is Elements RPC password"); err != nil {
+					log.Println("Error sending zip:", err)
+				}
+				if err = os.Remove(filepath.Join(config.Config.DataDir, fileName)); err != nil {
+					log.Println("Error deleting zip file:", err)
+				}
 			case "/pegin":
-				t := ""
-				if config.Config.PeginTxId == "" {
-					t = "No pending peg-in or BTC withdrawal"
-				} else {
-					if config.Config.PeginTxId != "external" {
-						confs, _ := peginConfirmations(config.Config.PeginTxId)
-						if config.Config.PeginClaimJoin && ln.ClaimBlockHeight > 0 {
-							bh := ln.GetBlockHeight()
-							duration := time.Duration(10*(ln.ClaimBlockHeight-bh)) * time.Minute
-							if ln.ClaimBlockHeight == 0 {
-								duration = time.Duration(10*(int32(peginBlocks)-confs)) * time.Minute
-							}
-							eta := time.Now().Add(duration).Format("3:04 PM")
-							if duration < 0 {
-								eta = "Past due"
-							}
-							t = "🧬 " + ln.ClaimStatus
-							if ln.MyRole == "none" && ln.ClaimJoinHandler != "" {
-								t += ". Time limit to apply: " + eta
-							} else if confs > 0 {
-								t += ". ETA: " + eta
-							}
-						} else {
-							// solo peg-in
-							duration := time.Duration(10*(int32(peginBlocks)-confs)) * time.Minute
-							eta := time.Now().Add(duration).Format("3:04 PM")
-							t = "⏰ Peg-in pending:: "
-							if config.Config.PeginClaimScript == "" {
-								t = "⛓️ BTC withdrawal pending: "
-							}
-							t += formatWithThousandSeparators(uint64(config.Config.PeginAmount)) + " sats, Confs: " + strconv.Itoa(int(confs))
-							if config.Config.PeginClaimScript != "" {
-								t += "/102, ETA: " + eta
-							}
-							t += ". TxId: `" + config.Config.PeginTxId + "`"
-							if confs == 0 {
-								t += fmt.Sprintf(", sat/vb: %0.2f", config.Config.PeginFeeRate)
-							}
-
-						}
-					} else {
-						t = "Awaiting external funding to a peg-in address"
-					}
+				pegins := activePegins()
+				if len(pegins) == 0 {
+					telegramSendMessage("No pending peg-in or BTC withdrawal")
+				}
+				for _, p := range pegins {
+					telegramSendMessage(peginTelegramStatus(p))
+				}
+			case "/pegout":
+				pegouts := activePegouts()
+				if len(pegouts) == 0 {
+					telegramSendMessage("No pending peg-out")
+				}
+				for _, p := range pegouts {
+					telegramSendMessage("⏰ Peg-out of " + formatWithThousandSeparators(uint64(p.Amount)) + " sats: " + pegoutStageText(p) + ". TxId: `" + p.TxId + "`")
 				}
-				telegramSendMessage(t)
 			case "/autoswaps":
-				t := "🤖 Liquid auto swaps are "
+				t := "🤖 Auto swap-ins are "
 				if config.Config.AutoSwapEnabled {
 					t += "Enabled"
 					t += "\nThreshold Amount: " + formatWithThousandSeparators(config.Config.AutoSwapThresholdAmount)
@@ -150,6 +133,7 @@ func telegramStart() {
 							t += "\nCandidate: " + candidate.PeerAlias
 							t += "\nMax Amount: " + formatWithThousandSeparators(candidate.Amount)
 							t += "\nRecent PPM: " + formatWithThousandSeparators(candidate.RoutingPpm)
+							t += "\nAsset: " + candidate.Asset
 						} else {
 							t += "No swap candidates"
 						}
@@ -157,6 +141,36 @@ func telegramStart() {
 				} else {
 					t += "Disabled"
 				}
+				t += "\n\nIn flight: " + strconv.Itoa(len(autoSwapsInFlightSnapshot()))
+				t += "\nFees 24h: " + formatSigned(autoSwapFeesSpent(time.Now().Add(-24*time.Hour).Unix()))
+				if _, reason := autoSwapRoom(); reason != "" {
+					t += "\nWaiting: " + reason
+				}
+				if outcomes, _, _ := autoSwapOutcomesSnapshot(); len(outcomes) > 0 {
+					o := outcomes[len(outcomes)-1]
+					t += "\nLast outcome: " + o.Result + " with " + getNodeAlias(o.PeerId) + ", " + timePassedAgo(time.Unix(o.TimeStamp, 0))
+				}
+				t += "\n\n🤖 Auto swap-outs are "
+				if config.Config.AutoSwapOutEnabled {
+					t += "Enabled"
+					t += "\nThreshold Pct: " + formatWithThousandSeparators(config.Config.AutoSwapOutThresholdPct)
+					t += "\nMinimum PPM: " + formatWithThousandSeparators(config.Config.AutoSwapOutThresholdPPM)
+					t += "\nSpent Today: " + formatWithThousandSeparators(autoSwapOutSpent())
+
+					var candidate AutoSwapParams
+
+					if err := findSwapOutCandidate(&candidate); err == nil {
+						if candidate.Amount > 0 {
+							t += "\nCandidate: " + candidate.PeerAlias
+							t += "\nMax Amount: " + formatWithThousandSeparators(candidate.Amount)
+							t += "\nInbound PPM: " + formatWithThousandSeparators(candidate.RoutingPpm)
+						} else {
+							t += "\nNo swap candidates"
+						}
+					}
+				} else {
+					t += "Disabled"
+				}
 				telegramSendMessage(t)
 			case "/version":
 				t := "Current version: " + VERSION + "\n"
@@ -169,6 +183,51 @@ func telegramStart() {
 	}
 }
 
+// reply to /pegin command for one peg-in or withdrawal
+func peginTelegramStatus(p *db.Pegin) string {
+	if p.TxId == "external" {
+		return "Awaiting external funding to a peg-in address"
+	}
+
+	if p.Status == "claimed" {
+		return "💸 Peg-in complete! Liquid TxId: `" + p.ClaimTxId + "`"
+	}
+
+	confs, _ := peginConfirmations(p.TxId)
+	if p.ClaimJoin && ln.ClaimPeginId == p.Id && ln.ClaimBlockHeight > 0 {
+		bh := ln.GetBlockHeight()
+		duration := time.Duration(10*(int32(ln.ClaimBlockHeight)-int32(bh))) * time.Minute
+		eta := time.Now().Add(duration).Format("3:04 PM")
+		if duration < 0 {
+			eta = "Past due"
+		}
+		t := "🧬 " + ln.ClaimStatus
+		if ln.MyRole == "none" && ln.ClaimJoinHandler != "" {
+			t += ". Time limit to apply: " + eta
+		} else if confs > 0 {
+			t += ". ETA: " + eta
+		}
+		return t
+	}
+
+	// solo peg-in
+	duration := time.Duration(10*(int32(peginBlocks)-confs)) * time.Minute
+	eta := time.Now().Add(duration).Format("3:04 PM")
+	t := "⏰ Peg-in pending: "
+	if !p.IsPegin() {
+		t = "⛓️ BTC withdrawal pending: "
+	}
+	t += formatWithThousandSeparators(uint64(p.Amount)) + " sats, Confs: " + strconv.Itoa(int(confs))
+	if p.IsPegin() {
+		t += "/" + strconv.Itoa(int(peginBlocks)) + ", ETA: " + eta
+	}
+	t += ". TxId: `" + p.TxId + "`"
+	if confs == 0 {
+		t += fmt.Sprintf(", sat/vb: %0.2f", p.FeeRate)
+	}
+	return t
+}
+
 func telegramConnect() bool {
 	if telegramSendMessage("📟 PeerSwap connected") {
 		// successfully connected
@@ -181,13 +240,21 @@ func telegramConnect() bool {
 				Command:     "backup",
 				Description: "Elements wallet backup",
 			},
+			tgbotapi.BotCommand{
+				Command:     "export",
+				Description: "PSWeb state export",
+			},
 			tgbotapi.BotCommand{
 				Command:     "pegin",
 				Description: "Status of peg-in or BTC withdrawal",
 			},
+			tgbotapi.BotCommand{
+				Command:     "pegout",
+				Description: "Status of Liquid peg-out",
+			},
 			tgbotapi.BotCommand{
 				Command:     "autoswaps",
-				Description: "Status of Liquid auto swaps",
+				Description: "Status of auto swaps",
 			},
 			tgbotapi.BotCommand{
 				Command:     "version",
@@ -225,7 +292,7 @@ func telegramSendMessage(msgText string) bool {
 	return true
 }
 
-func telegramSendFile(folder, fileName, satAmount string) error {
+func telegramSendFile(folder, fileName, caption string) error {
 	// Open file
 	file, err := os.Open(filepath.Join(folder, fileName))
 	if err != nil {
@@ -239,7 +306,7 @@ func telegramSendFile(folder, fileName, satAmount string) error {
 	// Create message config
 	msg := tgbotapi.NewDocument(chatId, fileConfig)
 
-	msg.Caption = "🌊 " + satAmount
+	msg.Caption = caption
 
 	// Send file
 	_, err = bot.Send(msg)
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"peerswap-web/cmd/psweb/db"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API token scopes, each one includes the ones below it
+const (
+	SCOPE_READ = iota
+	SCOPE_FEES
+	SCOPE_SWAPS
+	SCOPE_WALLET
+	// reserved for session or certificate, never granted to a token
+	SCOPE_ADMIN
+)
+
+var scopeNames = []string{"read-only", "fees", "swaps", "wallet-spend"}
+
+// bearer token for scripts and dashboards
+type ApiToken struct {
+	// short prefix of the hash to identify the token in UI
+	Id   string
+	Name string
+	// SCOPE_READ..SCOPE_WALLET
+	Scope int
+	// sha256 of the token, the token itself is never stored
+	Hash     string
+	Created  int64
+	LastUsed int64
+}
+
+func (t *ApiToken) ScopeName() string {
+	if t.Scope >= 0 && t.Scope < len(scopeNames) {
+		return scopeNames[t.Scope]
+	}
+	return "unknown"
+}
+
+func (t *ApiToken) CreatedAt() string {
+	return time.Unix(t.Created, 0).Format("2006-01-02 15:04")
+}
+
+func (t *ApiToken) LastUsedAgo() string {
+	if t.LastUsed == 0 {
+		return "never"
+	}
+	return timePassedAgo(time.Unix(t.LastUsed, 0))
+}
+
+var (
+	// keyed by Hash
+	apiTokens   = make(map[string]*ApiToken)
+	apiTokensMu sync.Mutex
+)
+
+func loadApiTokens() {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+	db.Load("ApiTokens", "Tokens", &apiTokens)
+}
+
+// must be called with apiTokensMu locked
+func saveApiTokens() {
+	db.Save("ApiTokens", "Tokens", apiTokens)
+}
+
+func hashApiToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// creates a new token and returns it in plain text, to be shown once
+func newApiToken(name string, scope int) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("token name is required")
+	}
+	if scope < SCOPE_READ || scope > SCOPE_WALLET {
+		return "", errors.New("invalid token scope")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	token := "psw_" + hex.EncodeToString(b)
+	hash := hashApiToken(token)
+
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	apiTokens[hash] = &ApiToken{
+		Id:      hash[:8],
+		Name:    name,
+		Scope:   scope,
+		Hash:    hash,
+		Created: time.Now().Unix(),
+	}
+	saveApiTokens()
+
+	return token, nil
+}
+
+func revokeApiToken(id string) error {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	for hash, t := range apiTokens {
+		if t.Id == id {
+			delete(apiTokens, hash)
+			saveApiTokens()
+			return nil
+		}
+	}
+	return errors.New("token not found")
+}
+
+// sorted by creation time for config page
+func listApiTokens() []*ApiToken {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	var list []*ApiToken
+	for _, t := range apiTokens {
+		c := *t
+		list = append(list, &c)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created < list[j].Created
+	})
+
+	return list
+}
+
+// returns scope of a valid token
+func checkApiToken(token string) (int, bool) {
+	hash := hashApiToken(token)
+
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	for h, t := range apiTokens {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			now := time.Now().Unix()
+			// avoid a db write on every request
+			if now-t.LastUsed > 300 {
+				t.LastUsed = now
+				saveApiTokens()
+			}
+			return t.Scope, true
+		}
+	}
+	return 0, false
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+// scope needed for a request made with a token
+func requiredScope(r *http.Request) int {
+	path := r.URL.Path
+
+	if strings.HasPrefix(path, API_PREFIX) {
+		switch {
+		case r.Method == http.MethodGet:
+			return SCOPE_READ
+		case strings.HasPrefix(path, API_PREFIX+"/channels/") && strings.HasSuffix(path, "/fee"):
+			return SCOPE_FEES
+		case path == API_PREFIX+"/swaps":
+			return SCOPE_SWAPS
+		}
+		return SCOPE_ADMIN
+	}
+
+	switch path {
+	case "/submit":
+		if r.Method != http.MethodPost {
+			return SCOPE_ADMIN
+		}
+		switch r.FormValue("action") {
+		case "saveAutoFee", "toggleAutoFee", "toggleDryRun", "addAutoFeeGroup", "setAutoFeeGroup", "setFee", "setBase":
+			return SCOPE_FEES
+		case "doSwap", "setAutoSwap", "setAutoSwapOut", "setAutoSwapLimits", "setPremium":
+			return SCOPE_SWAPS
+		case "sendLiquid", "keySend", "newAddress", "newBitcoinAddress", "externalPeginTxId", "deleteTxId", "cancelPegout":
+			return SCOPE_WALLET
+		}
+	case "/pegin", "/bumpfee", "/pegout":
+		if r.Method == http.MethodPost {
+			return SCOPE_WALLET
+		}
+		return SCOPE_READ
+	case "/", "/swap", "/peer", "/liquid", "/autoswaps", "/ledger", "/accounting", "/labels", "/bitcoin", "/af", "/backtest", "/premiums", "/log", "/logapi", "/loading", "/events":
+		if r.Method == http.MethodGet {
+			return SCOPE_READ
+		}
+	default:
+		if strings.HasPrefix(path, "/static/") {
+			return SCOPE_READ
+		}
+	}
+
+	// config, backup, stop, peer management etc
+	return SCOPE_ADMIN
+}